@@ -1,55 +1,276 @@
+// Консольный клиент сервиса сокращения ссылок.
+//
+// Использование:
+//
+//	client [флаги] <команда> [аргументы]
+//
+// Команды:
+//
+//	shorten <url>...   сократить ссылки
+//	batch [файл]       сократить ссылки из файла или из stdin, по одной в строке
+//	expand <ссылка>... раскрыть короткие ссылки или коды
+//	list               ссылки пользователя
+//	delete <код>...    удалить ссылки пользователя
+//	stats              статистика сервиса
 package main
 
 import (
 	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
-	"net/http"
-	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/h1067675/shortUrl/pkg/shortclient"
 )
 
+// Структура параметров запуска клиента
+type options struct {
+	endpoint string
+	apiKey   string
+	session  string
+	output   string
+	gzip     bool
+	timeout  time.Duration
+}
+
 func main() {
-	endpoint := "http://localhost:8080/"
-	// контейнер данных для запроса
-	data := url.Values{}
-	// приглашение в консоли
-	fmt.Println("Введите длинный URL")
-	// открываем потоковое чтение из консоли
-	reader := bufio.NewReader(os.Stdin)
-	// читаем строку из консоли
-	long, err := reader.ReadString('\n')
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// Функция возвращает значение переменной окружения или значение по умолчанию
+func envOr(name string, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// Функция возвращает путь файла сеанса по умолчанию, в нем хранится токен пользователя между запусками
+func defaultSession() string {
+	dir, err := os.UserConfigDir()
 	if err != nil {
-		panic(err)
-	}
-	long = strings.TrimSuffix(long, "\n")
-	// заполняем контейнер данными
-	data.Set("url", long)
-	// добавляем HTTP-клиент
-	client := &http.Client{}
-	// пишем запрос
-	// запрос методом POST должен, помимо заголовков, содержать тело
-	// тело должно быть источником потокового чтения io.Reader
-	request, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(data.Encode()))
+		return ""
+	}
+	return filepath.Join(dir, "shortener", "session")
+}
+
+// run - разбирает флаги и выполняет команду, возвращает код завершения
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	var opt options
+	fs := flag.NewFlagSet("client", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&opt.endpoint, "endpoint", envOr("SHORTENER_ENDPOINT", "http://localhost:8080"), "Service base URL")
+	fs.StringVar(&opt.apiKey, "key", os.Getenv("SHORTENER_API_KEY"), "API key (cookie session is used if empty)")
+	fs.StringVar(&opt.session, "session", envOr("SHORTENER_SESSION", defaultSession()), "File to keep the user cookie between runs")
+	fs.StringVar(&opt.output, "o", "table", "Output format: table or json")
+	fs.BoolVar(&opt.gzip, "gzip", false, "Compress requests and accept compressed responses")
+	fs.DurationVar(&opt.timeout, "timeout", 30*time.Second, "Request timeout")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: client [flags] shorten|batch|expand|list|delete|stats [args]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 || opt.output != "table" && opt.output != "json" {
+		fs.Usage()
+		return 2
+	}
+
+	c := shortclient.New(opt.endpoint)
+	c.APIKey = opt.apiKey
+	c.Gzip = opt.gzip
+	if opt.session != "" {
+		if token, err := os.ReadFile(opt.session); err == nil {
+			c.SetToken(strings.TrimSpace(string(token)))
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), opt.timeout)
+	defer cancel()
+
+	out := printer{w: stdout, json: opt.output == "json"}
+	cmd, rest := fs.Arg(0), fs.Args()[1:]
+	var err error
+	switch cmd {
+	case "shorten":
+		err = shorten(ctx, c, rest, out)
+	case "batch":
+		err = batch(ctx, c, rest, stdin, out)
+	case "expand":
+		err = expand(ctx, c, rest, out)
+	case "list":
+		err = list(ctx, c, out)
+	case "delete":
+		err = remove(ctx, c, rest, out)
+	case "stats":
+		err = stats(ctx, c, out)
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n", cmd)
+		fs.Usage()
+		return 2
+	}
+	if opt.session != "" && c.APIKey == "" && c.Token() != "" {
+		if err := saveSession(opt.session, c.Token()); err != nil {
+			fmt.Fprintln(stderr, "can't save session:", err)
+		}
+	}
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// Функция сохраняет токен пользователя в файл сеанса
+func saveSession(file string, token string) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return err
 	}
-	// в заголовках запроса указываем кодировку
-	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	// отправляем запрос и получаем ответ
-	response, err := client.Do(request)
+	return os.WriteFile(file, []byte(token), 0o600)
+}
+
+// Структура вывода результатов в виде таблицы или json
+type printer struct {
+	w    io.Writer
+	json bool
+}
+
+// Функция выводит значение v в json или строки rows таблицы с заголовком header
+func (p printer) print(v interface{}, header []string, rows [][]string) error {
+	if p.json {
+		enc := json.NewEncoder(p.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, r := range rows {
+		fmt.Fprintln(tw, strings.Join(r, "\t"))
+	}
+	return tw.Flush()
+}
+
+// shorten - сокращает ссылки из аргументов, для уже сокращенных ссылок выводит существующие короткие ссылки
+func shorten(ctx context.Context, c *shortclient.Client, urls []string, out printer) error {
+	if len(urls) == 0 {
+		return errors.New("shorten: url is required")
+	}
+	var result []shortclient.Link
+	var rows [][]string
+	for _, u := range urls {
+		short, err := c.Shorten(ctx, u)
+		var apiErr *shortclient.Error
+		if err != nil && !(errors.As(err, &apiErr) && apiErr.Code == "conflict") {
+			return err
+		}
+		result = append(result, shortclient.Link{ShortURL: short, OriginalURL: u})
+		rows = append(rows, []string{short, u})
+	}
+	return out.print(result, []string{"SHORT URL", "ORIGINAL URL"}, rows)
+}
+
+// batch - сокращает одним запросом ссылки из файла или stdin, идентификатором корреляции служит номер строки
+func batch(ctx context.Context, c *shortclient.Client, args []string, stdin io.Reader, out printer) error {
+	in := stdin
+	if len(args) > 0 && args[0] != "-" {
+		fl, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer fl.Close()
+		in = fl
+	}
+	var items []shortclient.BatchItem
+	sc := bufio.NewScanner(in)
+	for n := 1; sc.Scan(); n++ {
+		if u := strings.TrimSpace(sc.Text()); u != "" {
+			items = append(items, shortclient.BatchItem{CorrelationID: strconv.Itoa(n), OriginalURL: u})
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return errors.New("batch: no urls")
+	}
+	result, err := c.ShortenBatch(ctx, items)
+	if err != nil {
+		return err
+	}
+	rows := make([][]string, 0, len(result))
+	for _, r := range result {
+		rows = append(rows, []string{r.CorrelationID, r.ShortURL})
+	}
+	return out.print(result, []string{"LINE", "SHORT URL"}, rows)
+}
+
+// expand - раскрывает короткие ссылки или коды из аргументов
+func expand(ctx context.Context, c *shortclient.Client, shorts []string, out printer) error {
+	if len(shorts) == 0 {
+		return errors.New("expand: short url is required")
+	}
+	var result []shortclient.Link
+	var rows [][]string
+	for _, s := range shorts {
+		orig, err := c.Expand(ctx, s)
+		if err != nil {
+			return err
+		}
+		result = append(result, shortclient.Link{ShortURL: s, OriginalURL: orig})
+		rows = append(rows, []string{s, orig})
+	}
+	return out.print(result, []string{"SHORT URL", "ORIGINAL URL"}, rows)
+}
+
+// list - выводит ссылки пользователя
+func list(ctx context.Context, c *shortclient.Client, out printer) error {
+	links, err := c.UserURLs(ctx)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	if links == nil {
+		links = []shortclient.Link{}
+	}
+	rows := make([][]string, 0, len(links))
+	for _, l := range links {
+		rows = append(rows, []string{l.ShortURL, l.OriginalURL})
+	}
+	return out.print(links, []string{"SHORT URL", "ORIGINAL URL"}, rows)
+}
+
+// remove - удаляет ссылки пользователя по кодам или коротким ссылкам
+func remove(ctx context.Context, c *shortclient.Client, args []string, out printer) error {
+	if len(args) == 0 {
+		return errors.New("delete: code is required")
+	}
+	codes := make([]string, 0, len(args))
+	for _, a := range args {
+		codes = append(codes, a[strings.LastIndex(a, "/")+1:])
+	}
+	if err := c.DeleteUserURLs(ctx, codes); err != nil {
+		return err
 	}
-	// выводим код ответа
-	fmt.Println("Статус-код ", response.Status)
-	defer response.Body.Close()
-	// читаем поток из тела ответа
-	body, err := io.ReadAll(response.Body)
+	rows := make([][]string, 0, len(codes))
+	for _, code := range codes {
+		rows = append(rows, []string{code})
+	}
+	return out.print(map[string][]string{"deleted": codes}, []string{"DELETED"}, rows)
+}
+
+// stats - выводит статистику сервиса
+func stats(ctx context.Context, c *shortclient.Client, out printer) error {
+	s, err := c.Stats(ctx)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	// и печатаем его
-	fmt.Println(string(body))
+	return out.print(s, []string{"URLS", "USERS"}, [][]string{{strconv.Itoa(s.URLs), strconv.Itoa(s.Users)}})
 }
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/h1067675/shortUrl/cmd/configsurl"
+	"github.com/h1067675/shortUrl/cmd/netservice"
+	"github.com/h1067675/shortUrl/cmd/storage"
+	"github.com/h1067675/shortUrl/internal/logger"
+	"github.com/h1067675/shortUrl/pkg/shortclient"
+)
+
+func Test_run(t *testing.T) {
+	logger.Initialize("debug")
+	conf := configsurl.NewConfig("localhost:8080", "http://localhost:8080", t.TempDir()+"/storage.json")
+	conn := netservice.NewConnect(storage.NewStorage(), conf)
+	conn.AdminToken = "admin"
+	srv := httptest.NewServer(conn.RouterFunc())
+	defer srv.Close()
+	session := filepath.Join(t.TempDir(), "session")
+	client := func(stdin string, args ...string) (int, string) {
+		var stdout, stderr bytes.Buffer
+		args = append([]string{"-endpoint", srv.URL, "-session", session}, args...)
+		code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+		return code, stdout.String() + stderr.String()
+	}
+
+	code, out := client("", "-o", "json", "shorten", "http://ya.ru/")
+	require.Equal(t, 0, code, out)
+	var links []shortclient.Link
+	require.NoError(t, json.Unmarshal([]byte(out), &links))
+	require.Len(t, links, 1)
+	short := links[0].ShortURL
+
+	// повторное сокращение выводит существующую ссылку
+	code, out = client("", "shorten", "http://ya.ru/")
+	assert.Equal(t, 0, code)
+	assert.Contains(t, out, short)
+
+	code, out = client("http://mail.ru/\n\nhttp://go.dev/\n", "-gzip", "batch")
+	require.Equal(t, 0, code, out)
+	assert.Contains(t, out, "LINE")
+	assert.Equal(t, 3, strings.Count(out, "\n"))
+
+	shortCode := short[strings.LastIndex(short, "/")+1:]
+	code, out = client("", "expand", shortCode)
+	assert.Equal(t, 0, code, out)
+	assert.Contains(t, out, "http://ya.ru/")
+
+	// токен пользователя сохраняется между запусками
+	code, out = client("", "list")
+	assert.Equal(t, 0, code, out)
+	assert.Equal(t, 4, strings.Count(out, "\n"))
+
+	code, _ = client("", "delete", short)
+	assert.Equal(t, 0, code)
+	// статистика доступна только администратору
+	code, _ = client("", "stats")
+	assert.Equal(t, 1, code)
+	code, out = client("", "-key", "admin", "-o", "json", "stats")
+	assert.Equal(t, 0, code)
+	assert.JSONEq(t, `{"urls":2,"users":1}`, out)
+
+	code, out = client("", "expand", shortCode)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, out, "gone")
+
+	code, _ = client("", "unknown")
+	assert.Equal(t, 2, code)
+}
@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/caarlos0/env/v6"
 )
@@ -15,8 +17,38 @@ import (
 // Структура настроек сервера
 type Config struct {
 	NetAddressServerShortener NetAddressServer
-	NetAddressServerExpand    NetAddressServer
+	NetAddressServerGRPC      NetAddressServer
+	DebugAddress              NetAddressServer
+	BaseURL                   BaseURL
+	AcceptedHosts             HostList
 	FileStoragePath           FilePath
+	TenantsFilePath           FilePath
+	SecretKey                 Secret
+	AllowedSchemes            SchemeList
+	PolicyFilePath            FilePath
+	AdminToken                Secret
+	KeysFilePath              FilePath
+	AuditFilePath             FilePath
+	ShortenRateLimit          RateLimit
+	ExpandRateLimit           RateLimit
+	MaxBodySize               ByteSize
+	MaxDecodedSize            ByteSize
+	MaxCompressionRatio       Ratio
+	LogLevel                  LogLevel
+	LogEncoding               LogEncoding
+	LogOutputs                OutputList
+	LogSampling               LogSampling
+	LogRotate                 LogRotate
+	LogRedactHeaders          NameList
+	LogRedactParams           NameList
+	LogStripCredentials       Toggle
+	AccessLogPath             FilePath
+	AccessLogFormat           AccessFormat
+	AccessLogRotate           LogRotate
+	TrustedProxies            NetList
+	TraceExporter             TraceExporter
+	TraceEndpoint             Endpoint
+	TraceSampleRatio          Fraction
 	EnvConf                   EnvConfig
 }
 
@@ -26,6 +58,84 @@ type NetAddressServer struct {
 	Port int
 }
 
+// Структура описывающая базовый адрес сокращенных ссылок вида scheme://host[:port][/path]
+type BaseURL struct {
+	Scheme string
+	Host   string
+	Path   string
+}
+
+// Список имен хостов, по которым принимаются запросы на раскрытие коротких ссылок
+type HostList []string
+
+// Список схем ссылок, которые разрешено сокращать
+type SchemeList []string
+
+// Структура описывающая ключ подписи токенов пользователей
+type Secret struct {
+	Key string
+}
+
+// Структура описывающая ограничение частоты запросов одного клиента: Count запросов за период Per с допустимым
+// всплеском Burst запросов. Нулевой Count отключает ограничение
+type RateLimit struct {
+	Count int
+	Per   time.Duration
+	Burst int
+}
+
+// Размер в байтах, задается числом с необязательным суффиксом KB, MB или GB (кратными 1024)
+type ByteSize int64
+
+// Наибольшая допустимая степень сжатия тела запроса, 0 отключает проверку
+type Ratio int64
+
+// Уровень логгера: debug, info, warn или error
+type LogLevel string
+
+// Формат записей лога: json или console
+type LogEncoding string
+
+// Список выводов лога: stdout, stderr или пути к файлам
+type OutputList []string
+
+// Структура описывающая выборку записей лога: из одинаковых записей за секунду пишутся первые Initial, затем каждая
+// Thereafter. Нулевой Initial отключает выборку
+type LogSampling struct {
+	Initial    int
+	Thereafter int
+}
+
+// Структура описывающая ротацию файлов лога: файл больше MaxSize переименовывается, хранится не больше MaxBackups
+// старых файлов не старше MaxAge дней. Нулевой MaxSize отключает ротацию
+type LogRotate struct {
+	MaxSize    ByteSize
+	MaxAge     int
+	MaxBackups int
+	Compress   bool
+}
+
+// Список имен, перечисленных через запятую
+type NameList []string
+
+// Формат журнала доступа: common, combined или шаблон из директив Apache
+type AccessFormat string
+
+// Список адресов и подсетей, перечисленных через запятую
+type NetList []string
+
+// Выгрузка спанов трассировки: none, stdout или otlp
+type TraceExporter string
+
+// Адрес внешнего сервиса вида host:port или scheme://host[:port][/path]
+type Endpoint string
+
+// Доля от 0 до 1
+type Fraction float64
+
+// Логическое значение настройки
+type Toggle bool
+
 // Структура описывающая формат пути к файлу сохранения для получения переменной среды
 type FilePath struct {
 	Path string
@@ -34,28 +144,88 @@ type FilePath struct {
 // Структура описывающая название переменных среды
 type EnvConfig struct {
 	ServerShortener string `env:"SERVER_ADDRESS"`
+	ServerGRPC      string `env:"GRPC_ADDRESS"`
+	DebugAddress    string `env:"DEBUG_ADDRESS"`
 	ServerExpand    string `env:"BASE_URL"`
+	AcceptedHosts   string `env:"ACCEPTED_HOSTS"`
 	FileStoragePath string `env:"FILE_STORAGE_PATH"`
+	TenantsFilePath string `env:"TENANTS_FILE"`
+	SecretKey       string `env:"SECRET_KEY"`
+	AllowedSchemes  string `env:"ALLOWED_SCHEMES"`
+	PolicyFilePath  string `env:"POLICY_FILE"`
+	AdminToken      string `env:"ADMIN_TOKEN"`
+	KeysFilePath    string `env:"API_KEYS_FILE"`
+	AuditFilePath   string `env:"AUDIT_FILE"`
+	ShortenLimit    string `env:"SHORTEN_RATE_LIMIT"`
+	ExpandLimit     string `env:"EXPAND_RATE_LIMIT"`
+	MaxBodySize     string `env:"MAX_BODY_SIZE"`
+	MaxDecodedSize  string `env:"MAX_DECODED_SIZE"`
+	MaxRatio        string `env:"MAX_COMPRESSION_RATIO"`
+	LogLevel        string `env:"LOG_LEVEL"`
+	LogEncoding     string `env:"LOG_FORMAT"`
+	LogOutputs      string `env:"LOG_OUTPUT"`
+	LogSampling     string `env:"LOG_SAMPLING"`
+	LogRotate       string `env:"LOG_ROTATE"`
+	RedactHeaders   string `env:"LOG_REDACT_HEADERS"`
+	RedactParams    string `env:"LOG_REDACT_PARAMS"`
+	StripCreds      string `env:"LOG_STRIP_CREDENTIALS"`
+	AccessLogPath   string `env:"ACCESS_LOG"`
+	AccessLogFormat string `env:"ACCESS_LOG_FORMAT"`
+	AccessLogRotate string `env:"ACCESS_LOG_ROTATE"`
+	TrustedProxies  string `env:"TRUSTED_PROXIES"`
+	TraceExporter   string `env:"TRACE_EXPORTER"`
+	TraceEndpoint   string `env:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+	TraceSample     string `env:"TRACE_SAMPLE_RATIO"`
 }
 
-// функция создания конфига, получает адреса серверов в виде строки при этом если строки не установлены, то устанавливает
-// адреса по умолчанию: localhost:8080
-func NewConfig(netAddressServerShortener string, netAddressServerExpand string, fileStoragePath string) *Config {
+// функция создания конфига, получает адрес сервера и базовый адрес коротких ссылок в виде строки при этом если строки
+// не установлены, то устанавливает адреса по умолчанию: localhost:8080 и http://localhost:8080
+func NewConfig(netAddressServerShortener string, baseURL string, fileStoragePath string) *Config {
 	var r = Config{ // переменная которая будет хранить сетевой адрес сервера (аргумент -a командной строки)
 		NetAddressServerShortener: NetAddressServer{
 			// переменная которая будет хранить сетевой адрес сервера (аргумент -a командной строки)
 			Host: "localhost",
 			Port: 8080,
 		},
-		NetAddressServerExpand: NetAddressServer{
-			// переменная которая будет хранить сетевой адрес подставляемый к сокращенным ссылкам (аргумент -b командной строки)
-			Host: "localhost",
-			Port: 8080,
+		BaseURL: BaseURL{
+			// переменная которая будет хранить базовый адрес подставляемый к сокращенным ссылкам (аргумент -b командной строки)
+			Scheme: "http",
+			Host:   "localhost:8080",
+		},
+		ShortenRateLimit: RateLimit{
+			// переменная которая будет хранить ограничение запросов на сокращение (аргумент -shorten-limit командной строки)
+			Count: 60,
+			Per:   time.Minute,
+			Burst: 60,
 		},
-		EnvConf: EnvConfig{},
+		ExpandRateLimit: RateLimit{
+			// переменная которая будет хранить ограничение запросов на раскрытие (аргумент -expand-limit командной строки)
+			Count: 600,
+			Per:   time.Minute,
+			Burst: 600,
+		},
+		// ограничения тел запросов (аргументы -max-body, -max-decoded и -max-ratio командной строки)
+		MaxBodySize:         1 << 20,
+		MaxDecodedSize:      8 << 20,
+		MaxCompressionRatio: 100,
+		// настройки логгера (аргументы -log-level, -log-format, -log-output, -log-sampling и -log-rotate командной строки)
+		LogLevel:    "info",
+		LogEncoding: "json",
+		LogOutputs:  OutputList{"stdout"},
+		LogSampling: LogSampling{Initial: 100, Thereafter: 100},
+		// учетные данные из адресов в логах удаляются (аргумент -log-strip-credentials командной строки)
+		LogStripCredentials: true,
+		// журнал доступа (аргументы -access-log, -access-log-format и -access-log-rotate командной строки)
+		AccessLogFormat: "combined",
+		AccessLogRotate: LogRotate{MaxSize: 100 << 20, MaxAge: 30, MaxBackups: 10},
+		// трассировка (аргументы -trace-exporter, -trace-endpoint и -trace-sample командной строки)
+		TraceExporter:    "none",
+		TraceEndpoint:    "localhost:4318",
+		TraceSampleRatio: 1,
+		EnvConf:          EnvConfig{},
 	}
 	r.NetAddressServerShortener.Set(netAddressServerShortener)
-	r.NetAddressServerExpand.Set(netAddressServerExpand)
+	r.BaseURL.Set(baseURL)
 	r.FileStoragePath.Set(fileStoragePath)
 	return &r
 }
@@ -82,7 +252,7 @@ func checkNetAddress(s string, h string, p int) (host string, port int, e error)
 		e = errors.New("incorrect net address")
 		return
 	}
-	if a[1] != "" {
+	if len(a) == 2 && a[1] != "" {
 		port, e = strconv.Atoi(a[1])
 		if e != nil || port < 0 || port > 65535 {
 			e = errors.New("incorrect net address")
@@ -106,6 +276,56 @@ func (n *NetAddressServer) Set(s string) (err error) {
 	return nil
 }
 
+// разбирает базовый адрес коротких ссылок, если схема не указана, то подставляет http
+func (b *BaseURL) Set(s string) error {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "://") {
+		s = "http://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return errors.New("incorrect base url")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Hostname() == "" || u.RawQuery != "" || u.Fragment != "" {
+		return errors.New("incorrect base url")
+	}
+	b.Scheme = u.Scheme
+	b.Host = strings.ToLower(u.Host)
+	b.Path = strings.TrimRight(u.Path, "/")
+	return nil
+}
+
+// возвращаем базовый адрес вида scheme://host[:port][/path] без завершающего слеша
+func (b *BaseURL) String() string {
+	return b.Scheme + "://" + b.Host + b.Path
+}
+
+// возвращаем имя хоста базового адреса без порта
+func (b *BaseURL) Hostname() string {
+	return (&url.URL{Host: b.Host}).Hostname()
+}
+
+// разбирает список хостов, перечисленных через запятую
+func (h *HostList) Set(s string) error {
+	*h = (*h)[:0]
+	for _, v := range strings.Split(s, ",") {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if v == "" {
+			continue
+		}
+		if strings.ContainsAny(v, "/:") {
+			return errors.New("incorrect host name")
+		}
+		*h = append(*h, v)
+	}
+	return nil
+}
+
+// возвращаем список хостов через запятую
+func (h *HostList) String() string {
+	return strings.Join(*h, ",")
+}
+
 // Сохраняет значение переменной среды
 func (n *FilePath) Set(s string) (err error) {
 	n.Path = s
@@ -117,11 +337,427 @@ func (n *FilePath) String() string {
 	return n.Path
 }
 
+// разбирает список схем, перечисленных через запятую
+func (l *SchemeList) Set(s string) error {
+	*l = (*l)[:0]
+	for _, v := range strings.Split(s, ",") {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if v == "" {
+			continue
+		}
+		if strings.ContainsAny(v, "/:") {
+			return errors.New("incorrect scheme")
+		}
+		*l = append(*l, v)
+	}
+	return nil
+}
+
+// возвращаем список схем через запятую
+func (l *SchemeList) String() string {
+	return strings.Join(*l, ",")
+}
+
+// разбирает ограничение частоты вида count/unit[,burst], где unit это s, m или h. Если всплеск не указан, то он
+// равен count, значение 0 или off отключает ограничение
+func (l *RateLimit) Set(s string) error {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "0" || s == "off" {
+		*l = RateLimit{}
+		return nil
+	}
+	spec, burst, hasBurst := strings.Cut(s, ",")
+	count, unit, ok := strings.Cut(spec, "/")
+	if !ok {
+		return errors.New("incorrect rate limit")
+	}
+	var r RateLimit
+	var err error
+	if r.Count, err = strconv.Atoi(strings.TrimSpace(count)); err != nil || r.Count <= 0 {
+		return errors.New("incorrect rate limit")
+	}
+	switch strings.TrimSpace(unit) {
+	case "s":
+		r.Per = time.Second
+	case "m":
+		r.Per = time.Minute
+	case "h":
+		r.Per = time.Hour
+	default:
+		return errors.New("incorrect rate limit")
+	}
+	r.Burst = r.Count
+	if hasBurst {
+		if r.Burst, err = strconv.Atoi(strings.TrimSpace(burst)); err != nil || r.Burst <= 0 {
+			return errors.New("incorrect rate limit")
+		}
+	}
+	*l = r
+	return nil
+}
+
+// возвращаем ограничение частоты вида count/unit,burst
+func (l *RateLimit) String() string {
+	if l.Count == 0 {
+		return "off"
+	}
+	unit := map[time.Duration]string{time.Second: "s", time.Minute: "m", time.Hour: "h"}[l.Per]
+	return strconv.Itoa(l.Count) + "/" + unit + "," + strconv.Itoa(l.Burst)
+}
+
+// возвращаем количество запросов в секунду
+func (l *RateLimit) PerSecond() float64 {
+	if l.Count == 0 {
+		return 0
+	}
+	return float64(l.Count) / l.Per.Seconds()
+}
+
+// разбирает размер вида 512, 64KB, 1MB или 1GB
+func (b *ByteSize) Set(s string) error {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	mult := int64(1)
+	for suffix, m := range map[string]int64{"KB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30} {
+		if v, ok := strings.CutSuffix(s, suffix); ok {
+			s, mult = strings.TrimSpace(v), m
+			break
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSuffix(s, "B"), 10, 64)
+	if err != nil || n < 0 {
+		return errors.New("incorrect size")
+	}
+	*b = ByteSize(n * mult)
+	return nil
+}
+
+// возвращаем размер в байтах
+func (b *ByteSize) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+// разбирает степень сжатия
+func (r *Ratio) Set(s string) error {
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || n < 0 {
+		return errors.New("incorrect compression ratio")
+	}
+	*r = Ratio(n)
+	return nil
+}
+
+// возвращаем степень сжатия
+func (r *Ratio) String() string {
+	return strconv.FormatInt(int64(*r), 10)
+}
+
+// проверяет и сохраняет уровень логгера
+func (l *LogLevel) Set(s string) error {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "debug", "info", "warn", "error":
+		*l = LogLevel(s)
+		return nil
+	}
+	return errors.New("incorrect log level")
+}
+
+// возвращаем уровень логгера
+func (l *LogLevel) String() string {
+	return string(*l)
+}
+
+// проверяет и сохраняет формат записей лога
+func (e *LogEncoding) Set(s string) error {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s != "json" && s != "console" {
+		return errors.New("incorrect log format")
+	}
+	*e = LogEncoding(s)
+	return nil
+}
+
+// возвращаем формат записей лога
+func (e *LogEncoding) String() string {
+	return string(*e)
+}
+
+// разбирает список выводов лога, перечисленных через запятую
+func (o *OutputList) Set(s string) error {
+	*o = (*o)[:0]
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*o = append(*o, v)
+		}
+	}
+	if len(*o) == 0 {
+		return errors.New("incorrect log output")
+	}
+	return nil
+}
+
+// возвращаем список выводов лога через запятую
+func (o *OutputList) String() string {
+	return strings.Join(*o, ",")
+}
+
+// разбирает выборку вида initial,thereafter, значение 0 или off отключает выборку
+func (l *LogSampling) Set(s string) error {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "0" || s == "off" {
+		*l = LogSampling{}
+		return nil
+	}
+	initial, thereafter, ok := strings.Cut(s, ",")
+	var r LogSampling
+	var err error
+	if r.Initial, err = strconv.Atoi(strings.TrimSpace(initial)); err != nil || r.Initial <= 0 {
+		return errors.New("incorrect log sampling")
+	}
+	r.Thereafter = r.Initial
+	if ok {
+		if r.Thereafter, err = strconv.Atoi(strings.TrimSpace(thereafter)); err != nil || r.Thereafter < 0 {
+			return errors.New("incorrect log sampling")
+		}
+	}
+	*l = r
+	return nil
+}
+
+// возвращаем выборку вида initial,thereafter
+func (l *LogSampling) String() string {
+	if l.Initial == 0 {
+		return "off"
+	}
+	return strconv.Itoa(l.Initial) + "," + strconv.Itoa(l.Thereafter)
+}
+
+// разбирает ротацию вида size=100MB,age=7d,backups=5,compress, значение off отключает ротацию
+func (l *LogRotate) Set(s string) error {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "0" || s == "off" {
+		*l = LogRotate{}
+		return nil
+	}
+	var r LogRotate
+	for _, part := range strings.Split(s, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		var err error
+		switch key {
+		case "size":
+			err = r.MaxSize.Set(value)
+		case "age":
+			r.MaxAge, err = strconv.Atoi(strings.TrimSuffix(value, "d"))
+		case "backups":
+			r.MaxBackups, err = strconv.Atoi(value)
+		case "compress":
+			r.Compress = true
+		default:
+			err = errors.New("unknown key")
+		}
+		if err != nil || r.MaxAge < 0 || r.MaxBackups < 0 {
+			return errors.New("incorrect log rotation")
+		}
+	}
+	if r.MaxSize == 0 {
+		return errors.New("incorrect log rotation: size is required")
+	}
+	*l = r
+	return nil
+}
+
+// возвращаем ротацию вида size=N,age=Nd,backups=N[,compress]
+func (l *LogRotate) String() string {
+	if l.MaxSize == 0 {
+		return "off"
+	}
+	s := "size=" + l.MaxSize.String() + ",age=" + strconv.Itoa(l.MaxAge) + "d,backups=" + strconv.Itoa(l.MaxBackups)
+	if l.Compress {
+		s += ",compress"
+	}
+	return s
+}
+
+// возвращаем размер файла в мегабайтах с округлением вверх, как его принимает ротация
+func (l *LogRotate) MaxSizeMB() int {
+	return int((int64(l.MaxSize) + 1<<20 - 1) >> 20)
+}
+
+// разбирает список имен, перечисленных через запятую
+func (l *NameList) Set(s string) error {
+	*l = (*l)[:0]
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if strings.ContainsAny(v, " =&?#:") {
+			return errors.New("incorrect name " + v)
+		}
+		*l = append(*l, v)
+	}
+	return nil
+}
+
+// возвращаем список имен через запятую
+func (l *NameList) String() string {
+	return strings.Join(*l, ",")
+}
+
+// проверяет и сохраняет формат журнала доступа
+func (f *AccessFormat) Set(s string) error {
+	s = strings.TrimSpace(s)
+	if s != "common" && s != "combined" && !strings.Contains(s, "%") {
+		return errors.New("incorrect access log format")
+	}
+	*f = AccessFormat(s)
+	return nil
+}
+
+// возвращаем формат журнала доступа
+func (f *AccessFormat) String() string {
+	return string(*f)
+}
+
+// разбирает список адресов и подсетей, перечисленных через запятую
+func (l *NetList) Set(s string) error {
+	*l = (*l)[:0]
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(v); err != nil && net.ParseIP(v) == nil {
+			return errors.New("incorrect address or network " + v)
+		}
+		*l = append(*l, v)
+	}
+	return nil
+}
+
+// возвращаем список адресов и подсетей через запятую
+func (l *NetList) String() string {
+	return strings.Join(*l, ",")
+}
+
+// проверяет и сохраняет выгрузку спанов
+func (e *TraceExporter) Set(s string) error {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s != "none" && s != "stdout" && s != "otlp" {
+		return errors.New("incorrect trace exporter")
+	}
+	*e = TraceExporter(s)
+	return nil
+}
+
+// возвращаем выгрузку спанов
+func (e *TraceExporter) String() string {
+	return string(*e)
+}
+
+// проверяет и сохраняет адрес внешнего сервиса
+func (e *Endpoint) Set(s string) error {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "://") {
+		if u, err := url.Parse(s); err != nil || u.Host == "" {
+			return errors.New("incorrect endpoint")
+		}
+	} else if _, _, err := net.SplitHostPort(s); err != nil {
+		return errors.New("incorrect endpoint")
+	}
+	*e = Endpoint(s)
+	return nil
+}
+
+// возвращаем адрес внешнего сервиса
+func (e *Endpoint) String() string {
+	return string(*e)
+}
+
+// разбирает долю от 0 до 1
+func (f *Fraction) Set(s string) error {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || v < 0 || v > 1 {
+		return errors.New("incorrect fraction")
+	}
+	*f = Fraction(v)
+	return nil
+}
+
+// возвращаем долю
+func (f *Fraction) String() string {
+	return strconv.FormatFloat(float64(*f), 'g', -1, 64)
+}
+
+// разбирает логическое значение
+func (t *Toggle) Set(s string) error {
+	v, err := strconv.ParseBool(strings.TrimSpace(s))
+	if err != nil {
+		return errors.New("incorrect boolean value")
+	}
+	*t = Toggle(v)
+	return nil
+}
+
+// возвращаем логическое значение
+func (t *Toggle) String() string {
+	return strconv.FormatBool(bool(*t))
+}
+
+// IsBoolFlag - позволяет указывать флаг без значения
+func (t *Toggle) IsBoolFlag() bool {
+	return true
+}
+
+// Сохраняет ключ подписи
+func (n *Secret) Set(s string) (err error) {
+	n.Key = s
+	return nil
+}
+
+// возвращаем ключ подписи скрытым, чтобы он не попадал в справку и логи
+func (n *Secret) String() string {
+	if n.Key == "" {
+		return ""
+	}
+	return "***"
+}
+
 // разбираем атрибуты командной строки
 func (c *Config) ParseFlags() {
 	flag.Var(&c.NetAddressServerShortener, "a", "Net address shortener service (host:port)")
-	flag.Var(&c.NetAddressServerExpand, "b", "Net address expand service (host:port)")
+	flag.Var(&c.NetAddressServerGRPC, "g", "Net address gRPC service (host:port, disabled if empty)")
+	flag.Var(&c.DebugAddress, "debug-address", "Net address of pprof, expvar and build info listener, keep it private (disabled if empty)")
+	flag.Var(&c.BaseURL, "b", "Base URL of short links (scheme://host[:port][/path])")
+	flag.Var(&c.AcceptedHosts, "hosts", "Comma separated list of accepted host names for short links")
 	flag.Var(&c.FileStoragePath, "f", "File storage path")
+	flag.Var(&c.TenantsFilePath, "tenants", "Tenants file path (json list of tenants with their domains)")
+	flag.Var(&c.SecretKey, "secret", "Secret key for signing user tokens")
+	flag.Var(&c.AllowedSchemes, "schemes", "Comma separated list of url schemes allowed for shortening (default http,https)")
+	flag.Var(&c.PolicyFilePath, "policy", "Denylist file path (domains and re: prefixed regular expressions, reloaded on change)")
+	flag.Var(&c.AdminToken, "admin-token", "Bearer token for admin endpoints (admin endpoints are disabled if empty)")
+	flag.Var(&c.KeysFilePath, "keys", "API keys file path (keys are kept in memory only if empty)")
+	flag.Var(&c.AuditFilePath, "audit", "Append-only audit log file path (audit log is kept in memory only if empty)")
+	flag.Var(&c.ShortenRateLimit, "shorten-limit", "Shorten requests limit per client (count/s|m|h[,burst] or off)")
+	flag.Var(&c.ExpandRateLimit, "expand-limit", "Expand requests limit per client (count/s|m|h[,burst] or off)")
+	flag.Var(&c.MaxBodySize, "max-body", "Max request body size as received, compressed or not (0 disables the limit)")
+	flag.Var(&c.MaxDecodedSize, "max-decoded", "Max decompressed request body size (0 disables the limit)")
+	flag.Var(&c.MaxCompressionRatio, "max-ratio", "Max compression ratio of request bodies (0 disables the check)")
+	flag.Var(&c.LogLevel, "log-level", "Log level: debug, info, warn or error (can be changed at runtime by admin)")
+	flag.Var(&c.LogEncoding, "log-format", "Log format: json or console")
+	flag.Var(&c.LogOutputs, "log-output", "Comma separated list of log outputs: stdout, stderr or file paths")
+	flag.Var(&c.LogSampling, "log-sampling", "Log sampling per second of equal entries (initial[,thereafter] or off)")
+	flag.Var(&c.LogRotate, "log-rotate", "Log files rotation (size=100MB[,age=7d][,backups=5][,compress] or off)")
+	flag.Var(&c.LogRedactHeaders, "log-redact-headers", "Comma separated list of headers hidden in logs in addition to Authorization, Cookie and others")
+	flag.Var(&c.LogRedactParams, "log-redact-params", "Comma separated list of query parameters hidden in logs in addition to token, key, password and others")
+	flag.Var(&c.LogStripCredentials, "log-strip-credentials", "Strip user:password@ credentials from urls in logs")
+	flag.Var(&c.AccessLogPath, "access-log", "Access log output: file path, stdout or stderr (access log is disabled if empty)")
+	flag.Var(&c.AccessLogFormat, "access-log-format", "Access log format: combined, common or a template of Apache directives")
+	flag.Var(&c.AccessLogRotate, "access-log-rotate", "Access log file rotation (size=100MB[,age=30d][,backups=10][,compress] or off)")
+	flag.Var(&c.TraceExporter, "trace-exporter", "Trace spans exporter: none, stdout or otlp (traceparent is propagated in any case)")
+	flag.Var(&c.TraceEndpoint, "trace-endpoint", "OTLP/HTTP collector endpoint (host:port or url)")
+	flag.Var(&c.TraceSampleRatio, "trace-sample", "Ratio of traces started by the service that are sampled (0..1)")
+	flag.Var(&c.TrustedProxies, "trusted-proxies", "Comma separated list of proxy addresses and networks trusted to set X-Forwarded-For and X-Real-IP")
 	flag.Parse()
 }
 
@@ -134,12 +770,150 @@ func (c *Config) EnvConfigSet() {
 	if c.EnvConf.ServerShortener != "" {
 		c.NetAddressServerShortener.Set(c.EnvConf.ServerShortener)
 	}
+	if c.EnvConf.ServerGRPC != "" {
+		if err := c.NetAddressServerGRPC.Set(c.EnvConf.ServerGRPC); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if c.EnvConf.DebugAddress != "" {
+		if err := c.DebugAddress.Set(c.EnvConf.DebugAddress); err != nil {
+			log.Fatal(err)
+		}
+	}
 	if c.EnvConf.ServerExpand != "" {
-		c.NetAddressServerExpand.Set(c.EnvConf.ServerExpand)
+		if err := c.BaseURL.Set(c.EnvConf.ServerExpand); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if c.EnvConf.AcceptedHosts != "" {
+		if err := c.AcceptedHosts.Set(c.EnvConf.AcceptedHosts); err != nil {
+			log.Fatal(err)
+		}
 	}
 	if c.EnvConf.FileStoragePath != "" {
 		c.FileStoragePath.Set(c.EnvConf.FileStoragePath)
 	}
+	if c.EnvConf.TenantsFilePath != "" {
+		c.TenantsFilePath.Set(c.EnvConf.TenantsFilePath)
+	}
+	if c.EnvConf.SecretKey != "" {
+		c.SecretKey.Set(c.EnvConf.SecretKey)
+	}
+	if c.EnvConf.AllowedSchemes != "" {
+		if err := c.AllowedSchemes.Set(c.EnvConf.AllowedSchemes); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if c.EnvConf.PolicyFilePath != "" {
+		c.PolicyFilePath.Set(c.EnvConf.PolicyFilePath)
+	}
+	if c.EnvConf.AdminToken != "" {
+		c.AdminToken.Set(c.EnvConf.AdminToken)
+	}
+	if c.EnvConf.MaxBodySize != "" {
+		if err := c.MaxBodySize.Set(c.EnvConf.MaxBodySize); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if c.EnvConf.MaxDecodedSize != "" {
+		if err := c.MaxDecodedSize.Set(c.EnvConf.MaxDecodedSize); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if c.EnvConf.MaxRatio != "" {
+		if err := c.MaxCompressionRatio.Set(c.EnvConf.MaxRatio); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if c.EnvConf.LogLevel != "" {
+		if err := c.LogLevel.Set(c.EnvConf.LogLevel); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if c.EnvConf.LogEncoding != "" {
+		if err := c.LogEncoding.Set(c.EnvConf.LogEncoding); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if c.EnvConf.LogOutputs != "" {
+		if err := c.LogOutputs.Set(c.EnvConf.LogOutputs); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if c.EnvConf.LogSampling != "" {
+		if err := c.LogSampling.Set(c.EnvConf.LogSampling); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if c.EnvConf.LogRotate != "" {
+		if err := c.LogRotate.Set(c.EnvConf.LogRotate); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if c.EnvConf.RedactHeaders != "" {
+		if err := c.LogRedactHeaders.Set(c.EnvConf.RedactHeaders); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if c.EnvConf.RedactParams != "" {
+		if err := c.LogRedactParams.Set(c.EnvConf.RedactParams); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if c.EnvConf.StripCreds != "" {
+		if err := c.LogStripCredentials.Set(c.EnvConf.StripCreds); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if c.EnvConf.AccessLogPath != "" {
+		c.AccessLogPath.Set(c.EnvConf.AccessLogPath)
+	}
+	if c.EnvConf.AccessLogFormat != "" {
+		if err := c.AccessLogFormat.Set(c.EnvConf.AccessLogFormat); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if c.EnvConf.AccessLogRotate != "" {
+		if err := c.AccessLogRotate.Set(c.EnvConf.AccessLogRotate); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if c.EnvConf.TrustedProxies != "" {
+		if err := c.TrustedProxies.Set(c.EnvConf.TrustedProxies); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if c.EnvConf.TraceExporter != "" {
+		if err := c.TraceExporter.Set(c.EnvConf.TraceExporter); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if c.EnvConf.TraceEndpoint != "" {
+		if err := c.TraceEndpoint.Set(c.EnvConf.TraceEndpoint); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if c.EnvConf.TraceSample != "" {
+		if err := c.TraceSampleRatio.Set(c.EnvConf.TraceSample); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if c.EnvConf.KeysFilePath != "" {
+		c.KeysFilePath.Set(c.EnvConf.KeysFilePath)
+	}
+	if c.EnvConf.AuditFilePath != "" {
+		c.AuditFilePath.Set(c.EnvConf.AuditFilePath)
+	}
+	if c.EnvConf.ShortenLimit != "" {
+		if err := c.ShortenRateLimit.Set(c.EnvConf.ShortenLimit); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if c.EnvConf.ExpandLimit != "" {
+		if err := c.ExpandRateLimit.Set(c.EnvConf.ExpandLimit); err != nil {
+			log.Fatal(err)
+		}
+	}
 }
 
 // инициирует процесс установки настроек
@@ -148,15 +922,24 @@ func (c *Config) Set() {
 	c.EnvConfigSet()
 }
 
-// Возвращает данные настроек в текстовом формате
+// Возвращает данные настроек в текстовом формате. Список принимаемых хостов всегда содержит хост базового адреса,
+// если список хостов не задан, то он остается пустым и хост запроса не проверяется
 func (c *Config) GetConfig() struct {
 	ServerAddress   string
 	OuterAddress    string
+	BasePath        string
+	AcceptedHosts   []string
 	FileStoragePath string
 } {
+	var hosts []string
+	if len(c.AcceptedHosts) > 0 {
+		hosts = append([]string{c.BaseURL.Hostname()}, c.AcceptedHosts...)
+	}
 	return struct {
 		ServerAddress   string
 		OuterAddress    string
+		BasePath        string
+		AcceptedHosts   []string
 		FileStoragePath string
-	}{ServerAddress: c.NetAddressServerShortener.String(), OuterAddress: c.NetAddressServerExpand.String(), FileStoragePath: c.FileStoragePath.Path}
+	}{ServerAddress: c.NetAddressServerShortener.String(), OuterAddress: c.BaseURL.String(), BasePath: c.BaseURL.Path, AcceptedHosts: hosts, FileStoragePath: c.FileStoragePath.Path}
 }
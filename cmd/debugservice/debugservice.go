@@ -0,0 +1,133 @@
+// Пакет debugservice - служебный http сервер диагностики: профили pprof, переменные expvar, сведения о сборке
+// и стеки горутин. Сервер слушает отдельный адрес и не связан с публичным роутером, поэтому его адрес не должен быть
+// доступен снаружи
+package debugservice
+
+import (
+	"encoding/json"
+	"expvar"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	rpprof "runtime/pprof"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/h1067675/shortUrl/internal/logger"
+)
+
+// Sizer - источник размеров карт хранилища
+type Sizer interface {
+	Sizes() map[string]int
+}
+
+// Структура сервера диагностики
+type Server struct {
+	Storage Sizer
+	started time.Time
+}
+
+// Сервер, переменные которого публикуются в expvar. Переменные expvar глобальные и публикуются один раз,
+// поэтому они читают последний созданный сервер
+var current atomic.Pointer[Server]
+
+func init() {
+	expvar.Publish("storage", expvar.Func(func() interface{} {
+		if s := current.Load(); s != nil && s.Storage != nil {
+			return s.Storage.Sizes()
+		}
+		return nil
+	}))
+	expvar.Publish("goroutines", expvar.Func(func() interface{} {
+		return runtime.NumGoroutine()
+	}))
+	expvar.Publish("uptime_seconds", expvar.Func(func() interface{} {
+		if s := current.Load(); s != nil {
+			return int64(time.Since(s.started) / time.Second)
+		}
+		return nil
+	}))
+}
+
+// Функция создания сервера диагностики
+func NewServer(storage Sizer) *Server {
+	var r = Server{Storage: storage, started: time.Now()}
+	current.Store(&r)
+	return &r
+}
+
+// Структура сведений о сборке
+type BuildInfo struct {
+	GoVersion string            `json:"go_version"`
+	Path      string            `json:"path"`
+	Version   string            `json:"version"`
+	Settings  map[string]string `json:"settings"`
+	Deps      map[string]string `json:"deps"`
+	Started   time.Time         `json:"started"`
+	Uptime    string            `json:"uptime"`
+}
+
+// BuildInfoHandler - хандлер возвращает версию Go, версию модуля, параметры сборки вроде ревизии vcs и версии
+// зависимостей
+func (s *Server) BuildInfoHandler(responce http.ResponseWriter, request *http.Request) {
+	info := BuildInfo{
+		GoVersion: runtime.Version(),
+		Settings:  map[string]string{},
+		Deps:      map[string]string{},
+		Started:   s.started,
+		Uptime:    time.Since(s.started).Truncate(time.Second).String(),
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		info.Path, info.Version = bi.Main.Path, bi.Main.Version
+		for _, st := range bi.Settings {
+			info.Settings[st.Key] = st.Value
+		}
+		for _, d := range bi.Deps {
+			info.Deps[d.Path] = d.Version
+		}
+	}
+	responce.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(responce)
+	enc.SetIndent("", "  ")
+	enc.Encode(info)
+}
+
+// GoroutinesHandler - хандлер возвращает стеки всех горутин в текстовом виде, как при аварийном завершении
+func (s *Server) GoroutinesHandler(responce http.ResponseWriter, request *http.Request) {
+	responce.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	rpprof.Lookup("goroutine").WriteTo(responce, 2)
+}
+
+// Handler - возвращает маршруты сервера диагностики. Профили регистрируются на собственном мультиплексоре,
+// а не на http.DefaultServeMux
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/buildinfo", s.BuildInfoHandler)
+	mux.HandleFunc("/debug/goroutines", s.GoroutinesHandler)
+	return mux
+}
+
+// Функция запуска сервера диагностики. Если адрес доступен не только с локальной машины, то в лог пишется
+// предупреждение
+func (s *Server) StartServer(address string) {
+	if host, _, err := net.SplitHostPort(address); err == nil && host != "localhost" {
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			logger.Log.Warn("Debug server is reachable from the network", zap.String("debug address", address))
+		}
+	}
+	srv := &http.Server{Addr: address, Handler: s.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	logger.Log.Info("Debug server is running", zap.String("debug address", address))
+	if err := srv.ListenAndServe(); err != nil {
+		logger.Log.Fatal(err.Error(), zap.String("debug address", address))
+	}
+}
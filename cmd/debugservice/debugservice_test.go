@@ -0,0 +1,58 @@
+package debugservice
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/h1067675/shortUrl/cmd/storage"
+)
+
+func TestServer(t *testing.T) {
+	strg := storage.NewStorage()
+	_, err := strg.CreateShortURL("http://ya.ru/", "http://localhost:8080/", "user")
+	require.NoError(t, err)
+	srv := httptest.NewServer(NewServer(strg).Handler())
+	defer srv.Close()
+	get := func(target string) (int, string) {
+		resp, err := http.Get(srv.URL + target)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	status, body := get("/debug/vars")
+	require.Equal(t, http.StatusOK, status)
+	var vars struct {
+		Storage    map[string]int  `json:"storage"`
+		Goroutines int             `json:"goroutines"`
+		MemStats   json.RawMessage `json:"memstats"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(body), &vars))
+	assert.Equal(t, map[string]int{"inner_links": 1, "outter_links": 1, "meta": 1}, vars.Storage)
+	assert.Positive(t, vars.Goroutines)
+	assert.NotEmpty(t, vars.MemStats)
+
+	status, body = get("/debug/buildinfo")
+	require.Equal(t, http.StatusOK, status)
+	var info BuildInfo
+	require.NoError(t, json.Unmarshal([]byte(body), &info))
+	assert.True(t, strings.HasPrefix(info.GoVersion, "go"))
+
+	status, body = get("/debug/goroutines")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, "goroutine ")
+
+	status, body = get("/debug/pprof/")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, "heap")
+	status, _ = get("/debug/pprof/heap?debug=1")
+	assert.Equal(t, http.StatusOK, status)
+}
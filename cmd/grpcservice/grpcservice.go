@@ -0,0 +1,295 @@
+package grpcservice
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/h1067675/shortUrl/api/shortener"
+	"github.com/h1067675/shortUrl/cmd/netservice"
+	"github.com/h1067675/shortUrl/internal/apikey"
+	"github.com/h1067675/shortUrl/internal/audit"
+	"github.com/h1067675/shortUrl/internal/auth"
+	"github.com/h1067675/shortUrl/internal/logger"
+	"github.com/h1067675/shortUrl/internal/ratelimit"
+	"github.com/h1067675/shortUrl/internal/tracing"
+)
+
+// Структура gRPC сервера, использует хранилище, настройки и аутентификацию http коннектора
+type Server struct {
+	pb.UnimplementedShortenerServer
+	Conn *netservice.Connect
+}
+
+// Функция создания gRPC сервера поверх http коннектора
+func NewServer(conn *netservice.Connect) *Server {
+	var r = Server{Conn: conn}
+	return &r
+}
+
+// Функция возвращает первое значение метаданных запроса с указанным ключом
+func metadataValue(ctx context.Context, key string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if v := md.Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// Функция переводит ошибку в ошибку gRPC через общий с http сервисом преобразователь ошибок, код ошибки api
+// передается в сообщении. При конфликте существующая короткая ссылка передается в деталях ошибки ResourceInfo
+func statusError(err error) error {
+	p := netservice.ProblemFor(err)
+	code := codes.Internal
+	switch p.Status {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusNotFound, http.StatusGone:
+		code = codes.NotFound
+	case http.StatusConflict:
+		code = codes.AlreadyExists
+	case http.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+	}
+	st := status.New(code, p.Code+": "+p.Detail)
+	if p.ShortURL != "" {
+		if withShort, err := st.WithDetails(&errdetails.ResourceInfo{ResourceType: "short_url", ResourceName: p.ShortURL}); err == nil {
+			st = withShort
+		}
+	}
+	return st.Err()
+}
+
+// Функция возвращает адрес клиента без порта, пустой, если адрес неизвестен
+func peerHost(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// LoggingInterceptor - логирует вызовы методов с временем выполнения и кодом ответа. Идентификатор запроса берется
+// из метаданных x-request-id или создается новый, он сохраняется в контексте и возвращается в заголовках ответа.
+// Адрес клиента сохраняется в контексте для журнала аудита
+func (s *Server) LoggingInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	id := metadataValue(ctx, "x-request-id")
+	if !logger.ValidRequestID(id) {
+		id = logger.NewRequestID()
+	}
+	ctx = logger.WithRequestID(ctx, id)
+	if host := peerHost(ctx); host != "" {
+		ctx = audit.WithSource(ctx, host)
+	}
+	grpc.SetHeader(ctx, metadata.Pairs("x-request-id", id))
+	resp, err := handler(ctx, req)
+	logger.FromContext(ctx).Info("User gRPC request",
+		zap.String("method", info.FullMethod),
+		zap.Duration("duration", time.Since(start)),
+		zap.String("code", status.Code(err).String()))
+	return resp, err
+}
+
+// Области действия ключей api, необходимые для вызова методов
+var methodScopes = map[string]string{
+	pb.Shortener_Shorten_FullMethodName:        apikey.ScopeShorten,
+	pb.Shortener_ShortenBatch_FullMethodName:   apikey.ScopeShorten,
+	pb.Shortener_ListUserURLs_FullMethodName:   apikey.ScopeRead,
+	pb.Shortener_DeleteUserURLs_FullMethodName: apikey.ScopeDelete,
+	pb.Shortener_Stats_FullMethodName:          apikey.ScopeStats,
+}
+
+// AuthInterceptor - определяет пользователя по токену из метаданных user_id так же, как http сервис по cookie,
+// при отсутствии или повреждении токена выдает новый в заголовках ответа. Если передан ключ api в метаданных
+// authorization, то метод вызывается от имени ключа. Метод вызывается при условии, что у ключа или пользователя
+// есть нужная область действия
+func (s *Server) AuthInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if token, ok := strings.CutPrefix(metadataValue(ctx, "authorization"), "Bearer "); ok && token != "" {
+		id, err := s.Conn.IdentifyBearer(token)
+		if err != nil {
+			return nil, statusError(err)
+		}
+		if scope, ok := methodScopes[info.FullMethod]; ok && !id.HasScope(scope) {
+			return nil, statusError(netservice.ErrScope(scope))
+		}
+		return handler(auth.WithIdentity(ctx, id), req)
+	}
+	id, issued := s.Conn.Auth.Identify(metadataValue(ctx, auth.CookieName))
+	if scope, ok := methodScopes[info.FullMethod]; ok && !id.HasScope(scope) {
+		return nil, statusError(netservice.ErrScope(scope))
+	}
+	if issued != "" {
+		if err := grpc.SetHeader(ctx, metadata.Pairs(auth.CookieName, issued)); err != nil {
+			return nil, err
+		}
+	}
+	return handler(auth.WithIdentity(ctx, id), req)
+}
+
+// Функция возвращает ограничитель частоты вызовов метода: сокращение и раскрытие ссылок ограничиваются теми же
+// ограничителями, что и в http сервисе, остальные методы не ограничиваются
+func (s *Server) methodLimiter(method string) *ratelimit.Limiter {
+	switch method {
+	case pb.Shortener_Shorten_FullMethodName, pb.Shortener_ShortenBatch_FullMethodName:
+		return s.Conn.ShortenLimiter
+	case pb.Shortener_Expand_FullMethodName:
+		return s.Conn.ExpandLimiter
+	}
+	return nil
+}
+
+// RateLimitInterceptor - ограничивает частоту вызовов клиента по тем же ключам, что и http сервис: адрес клиента,
+// арендатор, ключ api или пользователь. Подключается после AuthInterceptor, на превышение ограничения возвращает
+// ResourceExhausted и время ожидания в секундах в метаданных retry-after
+func (s *Server) RateLimitInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	l := s.methodLimiter(info.FullMethod)
+	if l == nil {
+		return handler(ctx, req)
+	}
+	ok, wait := l.Allow(s.Conn.ClientKeys(ctx, peerHost(ctx), metadataValue(ctx, "x-api-key"))...)
+	if !ok {
+		grpc.SetHeader(ctx, metadata.Pairs("retry-after", netservice.RetryAfter(wait)))
+		return nil, statusError(netservice.ErrRateLimited)
+	}
+	return handler(ctx, req)
+}
+
+// Функция возвращает пользователя запроса, Issued пользователь не имеет доступа к своим ссылкам
+func userFromContext(ctx context.Context) (auth.Identity, error) {
+	id, ok := auth.FromContext(ctx)
+	if !ok || id.Issued {
+		return id, statusError(netservice.ErrUnauthorized)
+	}
+	return id, nil
+}
+
+// Shorten - сокращение ссылки на базовом домене или домене арендатора. Для уже сокращенной ссылки, как и в http
+// сервисе, возвращается ошибка AlreadyExists с существующей короткой ссылкой в деталях ошибки
+func (s *Server) Shorten(ctx context.Context, in *pb.ShortenRequest) (*pb.ShortenResponse, error) {
+	adr, err := s.Conn.TenantAddress(metadataValue(ctx, "x-api-key"), in.GetDomain())
+	if err != nil {
+		return nil, statusError(err)
+	}
+	id, _ := auth.FromContext(ctx)
+	short, err := s.Conn.Shorten(ctx, in.GetUrl(), adr, id.UserID)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &pb.ShortenResponse{Result: short}, nil
+}
+
+// ShortenBatch - пакетное сокращение ссылок
+func (s *Server) ShortenBatch(ctx context.Context, in *pb.ShortenBatchRequest) (*pb.ShortenBatchResponse, error) {
+	if len(in.GetItems()) == 0 {
+		return nil, statusError(netservice.ErrInvalidRequest)
+	}
+	adr, err := s.Conn.TenantAddress(metadataValue(ctx, "x-api-key"), "")
+	if err != nil {
+		return nil, statusError(err)
+	}
+	batch := make([]netservice.BatchRequest, 0, len(in.GetItems()))
+	for _, e := range in.GetItems() {
+		batch = append(batch, netservice.BatchRequest{CorrelationID: e.GetCorrelationId(), OriginalURL: e.GetOriginalUrl()})
+	}
+	id, _ := auth.FromContext(ctx)
+	result, err := s.Conn.ShortenBatch(ctx, batch, adr, id.UserID)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	var resp pb.ShortenBatchResponse
+	for _, e := range result {
+		resp.Items = append(resp.Items, &pb.BatchResult{CorrelationId: e.CorrelationID, ShortUrl: e.ShortURL})
+	}
+	return &resp, nil
+}
+
+// Expand - раскрытие короткой ссылки, ссылка ищется по ее хосту и пути так же, как в http сервисе
+func (s *Server) Expand(ctx context.Context, in *pb.ExpandRequest) (*pb.ExpandResponse, error) {
+	u, err := url.Parse(in.GetShortUrl())
+	if err != nil || u.Host == "" {
+		return nil, statusError(netservice.ErrInvalidURL)
+	}
+	original, err := s.Conn.ExpandURL(ctx, u.Host, u.Path)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &pb.ExpandResponse{OriginalUrl: original}, nil
+}
+
+// ListUserURLs - ссылки пользователя
+func (s *Server) ListUserURLs(ctx context.Context, in *pb.ListUserURLsRequest) (*pb.ListUserURLsResponse, error) {
+	id, err := userFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var resp pb.ListUserURLsResponse
+	for _, e := range s.Conn.UserURLs(ctx, id.UserID) {
+		resp.Urls = append(resp.Urls, &pb.UserURL{ShortUrl: e.ShortLink, OriginalUrl: e.OriginalLink})
+	}
+	return &resp, nil
+}
+
+// DeleteUserURLs - удаление ссылок пользователя по кодам
+func (s *Server) DeleteUserURLs(ctx context.Context, in *pb.DeleteUserURLsRequest) (*pb.DeleteUserURLsResponse, error) {
+	id, err := userFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.DeleteUserURLsResponse{Deleted: int64(s.Conn.DeleteUserURLs(ctx, id.UserID, in.GetCodes()))}, nil
+}
+
+// Stats - статистика сервиса
+func (s *Server) Stats(ctx context.Context, in *pb.StatsRequest) (*pb.StatsResponse, error) {
+	st := s.Conn.Stats(ctx)
+	return &pb.StatsResponse{Urls: int64(st.URLs), Users: int64(st.Users)}, nil
+}
+
+// GRPCServer - создает gRPC сервер с перехватчиками логирования, аутентификации и ограничения частоты вызовов
+// и регистрирует в нем сервис
+func (s *Server) GRPCServer() *grpc.Server {
+	srv := grpc.NewServer(grpc.ChainUnaryInterceptor(tracing.UnaryServerInterceptor, s.LoggingInterceptor, s.AuthInterceptor,
+		s.RateLimitInterceptor))
+	pb.RegisterShortenerServer(srv, s)
+	return srv
+}
+
+// Функция запуска gRPC сервера. После отмены ctx сервер перестает принимать вызовы, дожидается завершения текущих
+// и возвращает управление
+func (s *Server) StartServer(ctx context.Context, address string) {
+	listen, err := net.Listen("tcp", address)
+	if err != nil {
+		logger.Log.Fatal(err.Error(), zap.String("grpc address", address))
+	}
+	logger.Log.Debug("gRPC server is running", zap.String("grpc address", address))
+	srv := s.GRPCServer()
+	go func() {
+		<-ctx.Done()
+		srv.GracefulStop()
+	}()
+	if err := srv.Serve(listen); err != nil {
+		logger.Log.Fatal(err.Error(), zap.String("grpc address", address))
+	}
+}
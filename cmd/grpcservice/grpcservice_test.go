@@ -0,0 +1,158 @@
+package grpcservice
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	pb "github.com/h1067675/shortUrl/api/shortener"
+	"github.com/h1067675/shortUrl/cmd/configsurl"
+	"github.com/h1067675/shortUrl/cmd/netservice"
+	"github.com/h1067675/shortUrl/cmd/storage"
+	"github.com/h1067675/shortUrl/internal/apikey"
+	"github.com/h1067675/shortUrl/internal/auth"
+	"github.com/h1067675/shortUrl/internal/logger"
+	"github.com/h1067675/shortUrl/internal/ratelimit"
+)
+
+// newTestClient - создает сервис с хранилищем в памяти, запускает его gRPC сервер в памяти и возвращает клиента.
+// setup меняет сервис перед запуском сервера
+func newTestClient(t *testing.T, setup ...func(c *netservice.Connect)) (*netservice.Connect, pb.ShortenerClient) {
+	logger.Initialize("debug")
+	conf := configsurl.NewConfig("localhost:8080", "http://localhost:8080", t.TempDir()+"/storage.json")
+	c := netservice.NewConnect(storage.NewStorage(), conf)
+	for _, f := range setup {
+		f(c)
+	}
+	srv := NewServer(c).GRPCServer()
+	listen := bufconn.Listen(1 << 20)
+	go srv.Serve(listen)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) { return listen.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return c, pb.NewShortenerClient(conn)
+}
+
+func Test_grpcService(t *testing.T) {
+	c, client := newTestClient(t)
+	ctx := context.Background()
+
+	// первый вызов выдает токен пользователя в заголовках ответа
+	var header metadata.MD
+	short, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "http://ya.ru/"}, grpc.Header(&header))
+	require.NoError(t, err)
+	require.Len(t, header.Get(auth.CookieName), 1)
+	userCtx := metadata.AppendToOutgoingContext(ctx, auth.CookieName, header.Get(auth.CookieName)[0])
+
+	// повторное сокращение, как и в http сервисе, является конфликтом с существующей ссылкой в деталях
+	_, err = client.Shorten(userCtx, &pb.ShortenRequest{Url: "http://ya.ru/"})
+	st := status.Convert(err)
+	assert.Equal(t, codes.AlreadyExists, st.Code())
+	require.Len(t, st.Details(), 1)
+	info, ok := st.Details()[0].(*errdetails.ResourceInfo)
+	require.True(t, ok)
+	assert.Equal(t, short.GetResult(), info.GetResourceName())
+
+	_, err = client.ListUserURLs(ctx, &pb.ListUserURLsRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	batch, err := client.ShortenBatch(userCtx, &pb.ShortenBatchRequest{Items: []*pb.BatchItem{{CorrelationId: "1", OriginalUrl: "http://mail.ru/"}}})
+	require.NoError(t, err)
+	require.Len(t, batch.GetItems(), 1)
+
+	urls, err := client.ListUserURLs(userCtx, &pb.ListUserURLsRequest{})
+	require.NoError(t, err)
+	assert.Len(t, urls.GetUrls(), 2)
+
+	expanded, err := client.Expand(ctx, &pb.ExpandRequest{ShortUrl: short.GetResult()})
+	require.NoError(t, err)
+	assert.Equal(t, "http://ya.ru/", expanded.GetOriginalUrl())
+
+	code := short.GetResult()[len("http://localhost:8080/"):]
+	deleted, err := client.DeleteUserURLs(userCtx, &pb.DeleteUserURLsRequest{Codes: []string{code}})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted.GetDeleted())
+
+	_, err = client.Expand(ctx, &pb.ExpandRequest{ShortUrl: short.GetResult()})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// статистика доступна только ключу с областью stats
+	_, err = client.Stats(userCtx, &pb.StatsRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, statsToken, err := c.Keys.Create("monitoring", []string{apikey.ScopeStats})
+	require.NoError(t, err)
+	stats, err := client.Stats(metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+statsToken), &pb.StatsRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), stats.GetUrls())
+	assert.Equal(t, int64(1), stats.GetUsers())
+
+	// ключ api действует только в пределах своих областей
+	_, token, err := c.Keys.Create("backend", []string{apikey.ScopeShorten})
+	require.NoError(t, err)
+	keyCtx := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+	_, err = client.Shorten(keyCtx, &pb.ShortenRequest{Url: "http://ya.ru/key"})
+	require.NoError(t, err)
+	_, err = client.Stats(keyCtx, &pb.StatsRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = client.Stats(metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer sk_unknown"), &pb.StatsRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func Test_grpcRateLimit(t *testing.T) {
+	c, client := newTestClient(t, func(c *netservice.Connect) {
+		c.ShortenLimiter = ratelimit.New(0.1, 2)
+		c.ExpandLimiter = ratelimit.New(0.1, 1)
+	})
+	ctx := context.Background()
+
+	// сокращение ограничивается по адресу клиента, новый токен пользователя на каждый вызов не дает новой корзины
+	short, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "http://ya.ru/0"})
+	require.NoError(t, err)
+	_, err = client.ShortenBatch(ctx, &pb.ShortenBatchRequest{Items: []*pb.BatchItem{{CorrelationId: "1", OriginalUrl: "http://ya.ru/1"}}})
+	require.NoError(t, err)
+	var header metadata.MD
+	_, err = client.Shorten(ctx, &pb.ShortenRequest{Url: "http://ya.ru/2"}, grpc.Header(&header))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, []string{"10"}, header.Get("retry-after"))
+	urls, _ := c.Storage.Stats()
+	assert.Equal(t, 2, urls)
+
+	// раскрытие ограничивается отдельно от сокращения, остальные методы не ограничиваются
+	_, err = client.Expand(ctx, &pb.ExpandRequest{ShortUrl: short.GetResult()})
+	require.NoError(t, err)
+	_, err = client.Expand(ctx, &pb.ExpandRequest{ShortUrl: short.GetResult()})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	_, err = client.ListUserURLs(ctx, &pb.ListUserURLsRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func Test_startServerStops(t *testing.T) {
+	c, _ := newTestClient(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		NewServer(c).StartServer(ctx, "localhost:0")
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("gRPC server did not stop after context cancel")
+	}
+}
@@ -0,0 +1,320 @@
+package netservice
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"os"
+	"strconv"
+
+	"go.uber.org/zap"
+
+	"github.com/h1067675/shortUrl/cmd/storage"
+	"github.com/h1067675/shortUrl/internal/auth"
+	"github.com/h1067675/shortUrl/internal/logger"
+)
+
+// Структура запроса на блокировку или разблокировку короткой ссылки, вместо ссылки можно передать ее код
+// на базовом домене
+type BlockRequest struct {
+	ShortURL string `json:"short_url"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// Структура страницы найденных ссылок, Total - количество ссылок, подходящих под условия
+type LinksResponce struct {
+	Links  []storage.StorageJSON `json:"links"`
+	Total  int                   `json:"total"`
+	Offset int                   `json:"offset"`
+	Limit  int                   `json:"limit"`
+}
+
+// Структура запроса на передачу ссылок другому пользователю, вместо ссылок можно передать их коды на базовом домене
+type ReassignRequest struct {
+	ShortURLs []string `json:"short_urls"`
+	UserID    string   `json:"user_id"`
+}
+
+// Структура ответа с количеством ссылок, которые затронула операция
+type AffectedResponce struct {
+	Affected int `json:"affected"`
+}
+
+// Структура ответа со статистикой хранилища и размером его файла
+type StorageStatsResponce struct {
+	storage.Summary
+	File     string `json:"file"`
+	FileSize int64  `json:"file_size"`
+}
+
+// Размер страницы поиска ссылок по умолчанию и наибольший
+const (
+	defaultLinksLimit = 100
+	maxLinksLimit     = 1000
+)
+
+// Структура запроса и ответа с уровнем логгера
+type LogLevelRequest struct {
+	Level string `json:"level" example:"info"`
+}
+
+// Страница с предупреждением, которая показывается вместо перехода по заблокированной ссылке
+var blockedPage = template.Must(template.New("blocked").Parse(`<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>Ссылка заблокирована</title>
+</head>
+<body>
+  <h1>Переход по ссылке заблокирован</h1>
+  <p>Ссылка ведет на адрес, который может быть опасен, поэтому переход по ней отключен.</p>
+  <p>Причина: {{.}}</p>
+</body>
+</html>
+`))
+
+// writeBlockedPage - записывает в ответ страницу с предупреждением о заблокированной ссылке со статусом Forbidden
+func writeBlockedPage(responce http.ResponseWriter, request *http.Request, reason string) {
+	responce.Header().Set("Content-Type", "text/html; charset=utf-8")
+	responce.WriteHeader(http.StatusForbidden)
+	if err := blockedPage.Execute(responce, reason); err != nil {
+		logger.FromContext(request.Context()).Error("Can't render blocked page", zap.Error(err))
+	}
+}
+
+// adminOnly - middleware административных маршрутов, пропускает запросы с токеном администратора из настроек в
+// заголовке Authorization: Bearer. Если токен не задан, то административные маршруты отключены
+func (c *Connect) adminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(responce http.ResponseWriter, request *http.Request) {
+		if _, ok := bearerToken(request); !ok {
+			writeProblem(responce, request, ErrUnauthorized)
+			return
+		}
+		if id, _ := auth.FromContext(request.Context()); !id.Admin {
+			writeProblem(responce, request, ErrAdminOnly)
+			return
+		}
+		next.ServeHTTP(responce, request)
+	})
+}
+
+// BlockHandler - хандлер блокировки существующей короткой ссылки администратором. Заблокированная ссылка не
+// раскрывается, вместо перехода показывается страница с предупреждением и причиной блокировки
+//
+// @Summary      Блокировка короткой ссылки
+// @Tags         admin
+// @Accept       json
+// @Security     BearerAuth
+// @Param        request  body  BlockRequest  true  "Короткая ссылка и причина блокировки"
+// @Success      204  "Ссылка заблокирована"
+// @Failure      400  {object}  Problem  "Некорректный запрос"
+// @Failure      401  {object}  Problem  "Токен не передан или недействителен"
+// @Failure      403  {object}  Problem  "Токен не является токеном администратора"
+// @Failure      404  {object}  Problem  "Ссылка не найдена"
+// @Router       /api/v1/admin/block [post]
+// @Router       /api/admin/block [post]
+func (c *Connect) BlockHandler(responce http.ResponseWriter, request *http.Request) {
+	var req BlockRequest
+	if err := json.NewDecoder(request.Body).Decode(&req); err != nil || req.ShortURL == "" {
+		writeProblem(responce, request, bodyError(err))
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = "blocked by administrator"
+	}
+	if err := c.BlockURL(request.Context(), c.ShortURL(req.ShortURL), req.Reason); err != nil {
+		writeProblem(responce, request, err)
+		return
+	}
+	responce.WriteHeader(http.StatusNoContent)
+}
+
+// UnblockHandler - хандлер снятия блокировки администратора с короткой ссылки. Блокировка по спискам запрещенных
+// адресов этим не снимается
+//
+// @Summary      Разблокировка короткой ссылки
+// @Tags         admin
+// @Accept       json
+// @Security     BearerAuth
+// @Param        request  body  BlockRequest  true  "Короткая ссылка"
+// @Success      204  "Блокировка снята"
+// @Failure      400  {object}  Problem  "Некорректный запрос"
+// @Failure      401  {object}  Problem  "Токен не передан или недействителен"
+// @Failure      403  {object}  Problem  "Токен не является токеном администратора"
+// @Failure      404  {object}  Problem  "Ссылка не найдена"
+// @Router       /api/v1/admin/unblock [post]
+// @Router       /api/admin/unblock [post]
+func (c *Connect) UnblockHandler(responce http.ResponseWriter, request *http.Request) {
+	var req BlockRequest
+	if err := json.NewDecoder(request.Body).Decode(&req); err != nil || req.ShortURL == "" {
+		writeProblem(responce, request, bodyError(err))
+		return
+	}
+	if err := c.UnblockURL(request.Context(), c.ShortURL(req.ShortURL)); err != nil {
+		writeProblem(responce, request, err)
+		return
+	}
+	responce.WriteHeader(http.StatusNoContent)
+}
+
+// LogLevelHandler - хандлер возвращает текущий уровень логгера
+//
+// @Summary      Уровень логгера
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  LogLevelRequest  "Текущий уровень"
+// @Failure      401  {object}  Problem  "Токен не передан или недействителен"
+// @Failure      403  {object}  Problem  "Токен не является токеном администратора"
+// @Router       /api/v1/admin/log/level [get]
+// @Router       /api/admin/log/level [get]
+func (c *Connect) LogLevelHandler(responce http.ResponseWriter, request *http.Request) {
+	responce.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(responce).Encode(LogLevelRequest{Level: logger.Level.String()})
+}
+
+// SetLogLevelHandler - хандлер меняет уровень логгера во время работы сервиса, уровень действует до перезапуска
+//
+// @Summary      Изменение уровня логгера
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request  body  LogLevelRequest  true  "Новый уровень: debug, info, warn или error"
+// @Success      200  {object}  LogLevelRequest  "Установленный уровень"
+// @Failure      400  {object}  Problem  "Некорректный запрос или неизвестный уровень"
+// @Failure      401  {object}  Problem  "Токен не передан или недействителен"
+// @Failure      403  {object}  Problem  "Токен не является токеном администратора"
+// @Router       /api/v1/admin/log/level [put]
+// @Router       /api/admin/log/level [put]
+func (c *Connect) SetLogLevelHandler(responce http.ResponseWriter, request *http.Request) {
+	var req LogLevelRequest
+	if err := json.NewDecoder(request.Body).Decode(&req); err != nil {
+		writeProblem(responce, request, bodyError(err))
+		return
+	}
+	if err := logger.SetLevel(req.Level); err != nil {
+		writeProblem(responce, request, &APIError{Status: http.StatusBadRequest, Code: CodeInvalidRequest, Detail: err.Error()})
+		return
+	}
+	logger.FromContext(request.Context()).Warn("Log level changed", zap.String("level", logger.Level.String()))
+	c.LogLevelHandler(responce, request)
+}
+
+// SearchLinksHandler - хандлер поиска ссылок администратором по подстроке исходной ссылки, владельцу и интервалу
+// времени создания. Ссылки возвращаются от новых к старым страницами по limit ссылок начиная с offset, ссылки
+// из старых файлов хранилища без времени создания в поиск по времени не попадают
+//
+// @Summary      Поиск ссылок
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Param        q        query  string  false  "Подстрока исходной ссылки без учета регистра"
+// @Param        owner    query  string  false  "Идентификатор владельца"
+// @Param        from     query  string  false  "Начало интервала времени создания в формате RFC 3339"
+// @Param        to       query  string  false  "Конец интервала времени создания в формате RFC 3339, не включается"
+// @Param        deleted  query  bool    false  "Искать и среди удаленных ссылок"
+// @Param        offset   query  int     false  "Количество пропускаемых ссылок"
+// @Param        limit    query  int     false  "Размер страницы, по умолчанию 100, не больше 1000"
+// @Success      200  {object}  LinksResponce
+// @Failure      400  {object}  Problem  "Некорректные условия поиска"
+// @Failure      401  {object}  Problem  "Токен не передан или недействителен"
+// @Failure      403  {object}  Problem  "Токен не является токеном администратора"
+// @Router       /api/v1/admin/links [get]
+// @Router       /api/admin/links [get]
+func (c *Connect) SearchLinksHandler(responce http.ResponseWriter, request *http.Request) {
+	q := request.URL.Query()
+	f := storage.Filter{Destination: q.Get("q"), UserID: q.Get("owner")}
+	var err error
+	if f.From, f.To, err = queryInterval(q); err == nil {
+		f.Offset, f.Limit, err = queryPage(q, defaultLinksLimit, maxLinksLimit)
+	}
+	if v := q.Get("deleted"); err == nil && v != "" {
+		if f.Deleted, err = strconv.ParseBool(v); err != nil {
+			err = &APIError{Status: http.StatusBadRequest, Code: CodeInvalidRequest, Detail: "deleted must be a boolean"}
+		}
+	}
+	if err != nil {
+		writeProblem(responce, request, err)
+		return
+	}
+	r := LinksResponce{Offset: f.Offset, Limit: f.Limit}
+	r.Links, r.Total = c.SearchURLs(request.Context(), f)
+	writeJSON(responce, request, http.StatusOK, r)
+}
+
+// DeleteLinksHandler - хандлер удаления ссылок администратором независимо от владельца, принимает json список
+// коротких ссылок или их кодов на базовом домене. Несуществующие и уже удаленные ссылки пропускаются
+//
+// @Summary      Удаление ссылок администратором
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        links  body  []string  true  "Короткие ссылки или их коды"
+// @Success      200  {object}  AffectedResponce  "Количество удаленных ссылок"
+// @Failure      400  {object}  Problem  "Некорректный запрос"
+// @Failure      401  {object}  Problem  "Токен не передан или недействителен"
+// @Failure      403  {object}  Problem  "Токен не является токеном администратора"
+// @Failure      413  {object}  Problem  "Тело запроса превышает ограничение размера"
+// @Router       /api/v1/admin/links [delete]
+// @Router       /api/admin/links [delete]
+func (c *Connect) DeleteLinksHandler(responce http.ResponseWriter, request *http.Request) {
+	var links []string
+	if err := json.NewDecoder(request.Body).Decode(&links); err != nil || len(links) == 0 {
+		writeProblem(responce, request, bodyError(err))
+		return
+	}
+	for i, l := range links {
+		links[i] = c.ShortURL(l)
+	}
+	writeJSON(responce, request, http.StatusOK, AffectedResponce{Affected: c.ForceDeleteURLs(request.Context(), links)})
+}
+
+// ReassignHandler - хандлер передачи ссылок другому пользователю. Несуществующие ссылки и ссылки, которые уже
+// принадлежат пользователю, пропускаются
+//
+// @Summary      Передача ссылок другому пользователю
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request  body  ReassignRequest  true  "Короткие ссылки или их коды и новый владелец"
+// @Success      200  {object}  AffectedResponce  "Количество переданных ссылок"
+// @Failure      400  {object}  Problem  "Некорректный запрос"
+// @Failure      401  {object}  Problem  "Токен не передан или недействителен"
+// @Failure      403  {object}  Problem  "Токен не является токеном администратора"
+// @Failure      413  {object}  Problem  "Тело запроса превышает ограничение размера"
+// @Router       /api/v1/admin/links/owner [post]
+// @Router       /api/admin/links/owner [post]
+func (c *Connect) ReassignHandler(responce http.ResponseWriter, request *http.Request) {
+	var req ReassignRequest
+	if err := json.NewDecoder(request.Body).Decode(&req); err != nil || len(req.ShortURLs) == 0 || req.UserID == "" {
+		writeProblem(responce, request, bodyError(err))
+		return
+	}
+	for i, l := range req.ShortURLs {
+		req.ShortURLs[i] = c.ShortURL(l)
+	}
+	writeJSON(responce, request, http.StatusOK, AffectedResponce{Affected: c.ReassignURLs(request.Context(), req.ShortURLs, req.UserID)})
+}
+
+// StorageStatsHandler - хандлер статистики хранилища: количество ссылок по состояниям, пользователей и размер
+// файла хранилища
+//
+// @Summary      Статистика хранилища
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  StorageStatsResponce
+// @Failure      401  {object}  Problem  "Токен не передан или недействителен"
+// @Failure      403  {object}  Problem  "Токен не является токеном администратора"
+// @Router       /api/v1/admin/stats [get]
+// @Router       /api/admin/stats [get]
+func (c *Connect) StorageStatsHandler(responce http.ResponseWriter, request *http.Request) {
+	r := StorageStatsResponce{Summary: c.Summary(request.Context()), File: c.Config.GetConfig().FileStoragePath}
+	if fi, err := os.Stat(r.File); err == nil {
+		r.FileSize = fi.Size()
+	}
+	writeJSON(responce, request, http.StatusOK, r)
+}
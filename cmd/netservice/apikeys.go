@@ -0,0 +1,178 @@
+package netservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+
+	"github.com/h1067675/shortUrl/internal/apikey"
+	"github.com/h1067675/shortUrl/internal/audit"
+	"github.com/h1067675/shortUrl/internal/auth"
+	"github.com/h1067675/shortUrl/internal/logger"
+)
+
+// Структура запроса на создание ключа api, ключ с арендатором открывает его домены в заголовке X-API-Key
+type KeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	Tenant string   `json:"tenant,omitempty"`
+}
+
+// Структура сведений о ключе api, сам ключ возвращается только при создании
+type KeyResponce struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Scopes  []string  `json:"scopes"`
+	Tenant  string    `json:"tenant,omitempty"`
+	Created time.Time `json:"created"`
+	Revoked bool      `json:"revoked,omitempty"`
+	Key     string    `json:"key,omitempty"`
+}
+
+// Функция собирает сведения о ключе api без его хеша
+func keyResponce(k apikey.Key) KeyResponce {
+	return KeyResponce{ID: k.ID, Name: k.Name, Scopes: k.Scopes, Tenant: k.Tenant, Created: k.Created, Revoked: k.Revoked}
+}
+
+// bearerToken - возвращает токен из заголовка Authorization: Bearer
+func bearerToken(request *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer ")
+	return token, ok && token != ""
+}
+
+// authenticate - middleware определения пользователя. Запрос с заголовком Authorization: Bearer выполняется от имени
+// ключа api или администратора, с недействительным токеном отклоняется, остальные запросы определяются по cookie
+func (c *Connect) authenticate(next http.Handler) http.Handler {
+	cookies := c.Auth.Middleware(next)
+	return http.HandlerFunc(func(responce http.ResponseWriter, request *http.Request) {
+		token, ok := bearerToken(request)
+		if !ok {
+			cookies.ServeHTTP(responce, request)
+			return
+		}
+		id, err := c.IdentifyBearer(token)
+		if err != nil {
+			writeProblem(responce, request, err)
+			return
+		}
+		next.ServeHTTP(responce, request.WithContext(auth.WithIdentity(request.Context(), id)))
+	})
+}
+
+// requireScope - middleware проверки области действия пользователя, пользователи с cookie ограничены
+// apikey.CookieScopes
+func requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(responce http.ResponseWriter, request *http.Request) {
+			if id, _ := auth.FromContext(request.Context()); !id.HasScope(scope) {
+				writeProblem(responce, request, ErrScope(scope))
+				return
+			}
+			next.ServeHTTP(responce, request)
+		})
+	}
+}
+
+// CreateKeyHandler - хандлер создания ключа api. Ключ возвращается в ответе один раз, в хранилище остается только
+// его хеш. Ключ можно привязать к зарегистрированному арендатору
+//
+// @Summary      Создание ключа api
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request  body  KeyRequest  true  "Название ключа, области действия: shorten, read, delete, stats, edit, admin и арендатор"
+// @Success      201  {object}  KeyResponce
+// @Failure      400  {object}  Problem  "Некорректный запрос, область действия или арендатор"
+// @Failure      401  {object}  Problem  "Токен не передан или недействителен"
+// @Failure      403  {object}  Problem  "Токен не является токеном администратора"
+// @Router       /api/v1/admin/keys [post]
+// @Router       /api/admin/keys [post]
+func (c *Connect) CreateKeyHandler(responce http.ResponseWriter, request *http.Request) {
+	var req KeyRequest
+	if err := json.NewDecoder(request.Body).Decode(&req); err != nil {
+		writeProblem(responce, request, bodyError(err))
+		return
+	}
+	if req.Tenant != "" && !c.hasTenant(req.Tenant) {
+		writeProblem(responce, request, ErrUnknownTenant)
+		return
+	}
+	k, token, err := c.Keys.CreateForTenant(req.Name, req.Tenant, req.Scopes)
+	if err != nil {
+		writeProblem(responce, request, err)
+		return
+	}
+	c.saveKeys(request)
+	after := "name=" + k.Name + " scopes=" + strings.Join(k.Scopes, ",")
+	if k.Tenant != "" {
+		after += " tenant=" + k.Tenant
+	}
+	c.record(request.Context(), audit.ActionKeyCreate, k.ID, "", after)
+	r := keyResponce(k)
+	r.Key = token
+	writeJSON(responce, request, http.StatusCreated, r)
+}
+
+// ListKeysHandler - хандлер получения списка ключей api, включая отозванные
+//
+// @Summary      Список ключей api
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {array}   KeyResponce
+// @Failure      401  {object}  Problem  "Токен не передан или недействителен"
+// @Failure      403  {object}  Problem  "Токен не является токеном администратора"
+// @Router       /api/v1/admin/keys [get]
+// @Router       /api/admin/keys [get]
+func (c *Connect) ListKeysHandler(responce http.ResponseWriter, request *http.Request) {
+	keys := c.Keys.List()
+	result := make([]KeyResponce, 0, len(keys))
+	for _, k := range keys {
+		result = append(result, keyResponce(k))
+	}
+	writeJSON(responce, request, http.StatusOK, result)
+}
+
+// RevokeKeyHandler - хандлер отзыва ключа api, отозванный ключ сразу перестает действовать
+//
+// @Summary      Отзыв ключа api
+// @Tags         admin
+// @Security     BearerAuth
+// @Param        id  path  string  true  "Идентификатор ключа"
+// @Success      204  "Ключ отозван"
+// @Failure      401  {object}  Problem  "Токен не передан или недействителен"
+// @Failure      403  {object}  Problem  "Токен не является токеном администратора"
+// @Failure      404  {object}  Problem  "Ключ не найден"
+// @Router       /api/v1/admin/keys/{id} [delete]
+// @Router       /api/admin/keys/{id} [delete]
+func (c *Connect) RevokeKeyHandler(responce http.ResponseWriter, request *http.Request) {
+	keyID := chi.URLParam(request, "id")
+	if err := c.Keys.Revoke(keyID); err != nil {
+		writeProblem(responce, request, err)
+		return
+	}
+	c.saveKeys(request)
+	c.record(request.Context(), audit.ActionKeyRevoke, keyID, "active", "revoked")
+	responce.WriteHeader(http.StatusNoContent)
+}
+
+// hasTenant - проверяет, что арендатор зарегистрирован
+func (c *Connect) hasTenant(name string) bool {
+	if c.Tenants == nil {
+		return false
+	}
+	_, ok := c.Tenants.ByName(name)
+	return ok
+}
+
+// saveKeys - сохраняет ключи api в файл из настроек
+func (c *Connect) saveKeys(request *http.Request) {
+	if err := c.Keys.SaveToFile(c.KeysFilePath); err != nil {
+		logger.FromContext(request.Context()).Error("Can't save api keys", zap.Error(err))
+	}
+}
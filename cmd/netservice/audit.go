@@ -0,0 +1,140 @@
+package netservice
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/h1067675/shortUrl/internal/audit"
+	"github.com/h1067675/shortUrl/internal/auth"
+	"github.com/h1067675/shortUrl/internal/logger"
+)
+
+// Структура ответа со страницей журнала аудита, Total - количество записей, подходящих под условия
+type AuditResponce struct {
+	Events []audit.Event `json:"events"`
+	Total  int           `json:"total"`
+	Offset int           `json:"offset"`
+	Limit  int           `json:"limit"`
+}
+
+// auditSource - middleware запоминает в контексте адрес клиента для журнала аудита, адрес за доверенными прокси
+// берется из заголовков так же, как в журнале доступа
+func (c *Connect) auditSource(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(responce http.ResponseWriter, request *http.Request) {
+		ctx := audit.WithSource(request.Context(), logger.ClientIP(request, c.TrustedProxies))
+		next.ServeHTTP(responce, request.WithContext(ctx))
+	})
+}
+
+// record - записывает действие в журнал аудита от имени пользователя из контекста. Если журнал не задан, то
+// ничего не записывается, ошибка записи только логируется и не отменяет уже выполненное действие
+func (c *Connect) record(ctx context.Context, action string, target string, before string, after string) {
+	if c.Audit == nil {
+		return
+	}
+	id, _ := auth.FromContext(ctx)
+	_, err := c.Audit.Record(audit.Event{
+		Action:    action,
+		Actor:     id.UserID,
+		KeyID:     id.KeyID,
+		IP:        audit.SourceFrom(ctx),
+		RequestID: logger.RequestIDFrom(ctx),
+		Target:    target,
+		Before:    before,
+		After:     after,
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("Can't write audit log", zap.String("action", action), zap.Error(err))
+	}
+}
+
+// Функция разбирает условия выборки из журнала аудита из параметров запроса
+func auditFilter(request *http.Request) (audit.Filter, error) {
+	q := request.URL.Query()
+	f := audit.Filter{
+		Action: q.Get("action"),
+		Actor:  q.Get("actor"),
+		IP:     q.Get("ip"),
+		Target: q.Get("target"),
+	}
+	var err error
+	if f.From, f.To, err = queryInterval(q); err != nil {
+		return f, err
+	}
+	f.Offset, f.Limit, err = queryPage(q, audit.DefaultLimit, audit.MaxLimit)
+	return f, err
+}
+
+// Функция разбирает из параметров запроса полуинтервал времени [from, to) в формате RFC 3339
+func queryInterval(q url.Values) (time.Time, time.Time, error) {
+	var r [2]time.Time
+	for i, name := range []string{"from", "to"} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return r[0], r[1], &APIError{Status: http.StatusBadRequest, Code: CodeInvalidRequest, Detail: name + " must be an RFC 3339 time"}
+			}
+			r[i] = t
+		}
+	}
+	return r[0], r[1], nil
+}
+
+// Функция разбирает из параметров запроса страницу выборки offset и limit. Без limit страница содержит def записей,
+// limit больше max уменьшается до max
+func queryPage(q url.Values, def int, max int) (int, int, error) {
+	var r [2]int
+	for i, name := range []string{"offset", "limit"} {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.Atoi(strings.TrimSpace(v))
+			if err != nil || n < 0 {
+				return 0, 0, &APIError{Status: http.StatusBadRequest, Code: CodeInvalidRequest, Detail: name + " must be a non-negative integer"}
+			}
+			r[i] = n
+		}
+	}
+	if r[1] == 0 {
+		r[1] = def
+	}
+	return r[0], min(r[1], max), nil
+}
+
+// AuditHandler - хандлер выборки из журнала аудита. Записи возвращаются от новых к старым страницами по limit
+// записей начиная с offset
+//
+// @Summary      Журнал аудита
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Param        action  query  string  false  "Действие: create, edit, delete, block, unblock, reassign, key.create, key.revoke"
+// @Param        actor   query  string  false  "Идентификатор пользователя"
+// @Param        ip      query  string  false  "Адрес клиента"
+// @Param        target  query  string  false  "Подстрока короткой ссылки или идентификатора ключа"
+// @Param        from    query  string  false  "Начало интервала времени в формате RFC 3339"
+// @Param        to      query  string  false  "Конец интервала времени в формате RFC 3339, не включается"
+// @Param        offset  query  int     false  "Количество пропускаемых записей"
+// @Param        limit   query  int     false  "Размер страницы, по умолчанию 100, не больше 1000"
+// @Success      200  {object}  AuditResponce
+// @Failure      400  {object}  Problem  "Некорректные условия выборки"
+// @Failure      401  {object}  Problem  "Токен не передан или недействителен"
+// @Failure      403  {object}  Problem  "Токен не является токеном администратора"
+// @Router       /api/v1/admin/audit [get]
+// @Router       /api/admin/audit [get]
+func (c *Connect) AuditHandler(responce http.ResponseWriter, request *http.Request) {
+	f, err := auditFilter(request)
+	if err != nil {
+		writeProblem(responce, request, err)
+		return
+	}
+	r := AuditResponce{Events: []audit.Event{}, Offset: f.Offset, Limit: f.Limit}
+	if c.Audit != nil {
+		r.Events, r.Total = c.Audit.Query(f)
+	}
+	writeJSON(responce, request, http.StatusOK, r)
+}
@@ -2,18 +2,35 @@ package netservice
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"image/png"
 	"io"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/h1067675/shortUrl/cmd/storage"
+	"github.com/h1067675/shortUrl/internal/audit"
 	"github.com/h1067675/shortUrl/internal/logger"
+	"github.com/h1067675/shortUrl/internal/policy"
+	"github.com/h1067675/shortUrl/internal/ratelimit"
+	"github.com/h1067675/shortUrl/internal/tenant"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 type want struct {
@@ -22,6 +39,7 @@ type want struct {
 	contentType string
 	shortCode   string
 	location    string
+	problem     string
 }
 type test struct {
 	name         string
@@ -39,22 +57,60 @@ type TestStorage struct {
 	Test        test
 }
 
-func (s *TestStorage) CreateShortURL(url string, adr string) string {
-	val, ok := s.OutterLinks[url]
+func (s *TestStorage) CreateShortURL(url string, adr string, userID string) (string, error) {
+	val, ok := s.OutterLinks[adr+" "+url]
 	if ok {
-		return val
+		return val, &storage.ConflictError{ShortURL: val}
 	}
-	result := "http://" + adr + "/" + s.Test.shortCode
-	s.OutterLinks[url] = result
+	result := adr + "/" + s.Test.shortCode
+	s.OutterLinks[adr+" "+url] = result
 	s.InnerLinks[result] = url
-	return result
+	return result, nil
+}
+func (s *TestStorage) CheckURL(url string) error {
+	return nil
 }
 func (s *TestStorage) GetURL(url string) (l string, e error) {
 	l, ok := s.InnerLinks[url]
 	if ok {
 		return l, nil
 	}
-	return "", errors.New("link not found")
+	return "", storage.ErrNotFound
+}
+func (s *TestStorage) CountClick(short string) {
+}
+func (s *TestStorage) GetUserURLs(userID string) []storage.StorageJSON {
+	return nil
+}
+func (s *TestStorage) DeleteUserURLs(userID string, codes []string) []storage.StorageJSON {
+	return nil
+}
+func (s *TestStorage) UpdateURL(userID string, code string, url string) (string, string, error) {
+	return "", "", storage.ErrNotFound
+}
+func (s *TestStorage) GetURLHistory(userID string, code string) (storage.LinkHistory, error) {
+	return storage.LinkHistory{}, storage.ErrNotFound
+}
+func (s *TestStorage) Stats() (urls int, users int) {
+	return len(s.InnerLinks), 0
+}
+func (s *TestStorage) BlockURL(short string, reason string) (string, error) {
+	return "", storage.ErrNotFound
+}
+func (s *TestStorage) UnblockURL(short string) (string, error) {
+	return "", storage.ErrNotFound
+}
+func (s *TestStorage) SearchURLs(f storage.Filter) ([]storage.StorageJSON, int) {
+	return nil, 0
+}
+func (s *TestStorage) ForceDeleteURLs(shorts []string) []storage.StorageJSON {
+	return nil
+}
+func (s *TestStorage) ReassignURLs(shorts []string, userID string) []storage.StorageJSON {
+	return nil
+}
+func (s *TestStorage) Summary() storage.Summary {
+	return storage.Summary{Links: len(s.InnerLinks), Active: len(s.InnerLinks)}
 }
 func (s *TestStorage) TakeTestData(test test) {
 	s.Test = test
@@ -62,6 +118,9 @@ func (s *TestStorage) TakeTestData(test test) {
 func (s *TestStorage) SaveToFile(file string) {
 
 }
+func (s *TestStorage) SaveClicks(file string) bool {
+	return false
+}
 
 type NetAddressServer struct {
 	Host string
@@ -70,6 +129,8 @@ type NetAddressServer struct {
 type Cnfg struct {
 	NetAddressServerShortener NetAddressServer
 	NetAddressServerExpand    NetAddressServer
+	BasePath                  string
+	AcceptedHosts             []string
 	FileStoragePath           FilePath
 }
 type FilePath struct {
@@ -79,13 +140,17 @@ type FilePath struct {
 func (c *Cnfg) GetConfig() struct {
 	ServerAddress   string
 	OuterAddress    string
+	BasePath        string
+	AcceptedHosts   []string
 	FileStoragePath string
 } {
 	return struct {
 		ServerAddress   string
 		OuterAddress    string
+		BasePath        string
+		AcceptedHosts   []string
 		FileStoragePath string
-	}{ServerAddress: c.NetAddressServerShortener.String(), OuterAddress: c.NetAddressServerExpand.String(), FileStoragePath: c.FileStoragePath.Path}
+	}{ServerAddress: c.NetAddressServerShortener.String(), OuterAddress: "http://" + c.NetAddressServerExpand.String() + c.BasePath, BasePath: c.BasePath, AcceptedHosts: c.AcceptedHosts, FileStoragePath: c.FileStoragePath.Path}
 }
 func (n *NetAddressServer) String() string {
 	return n.Host + ":" + strconv.Itoa(n.Port)
@@ -165,8 +230,9 @@ func Test_shortenHandler(t *testing.T) {
 			want: want{
 				code:        400,
 				response:    "",
-				contentType: "",
+				contentType: "application/problem+json",
 				shortCode:   "",
+				problem:     "invalid_request",
 			},
 		},
 	}
@@ -200,9 +266,16 @@ func Test_shortenHandler(t *testing.T) {
 			body, _ := io.ReadAll(resp.Body)
 			var want string
 			if test.want.shortCode != "" {
-				want = "http://" + r.Config.GetConfig().OuterAddress + "/" + test.want.shortCode
+				want = r.Config.GetConfig().OuterAddress + "/" + test.want.shortCode
+			}
+			if test.want.problem != "" {
+				var p Problem
+				require.NoError(t, json.Unmarshal(body, &p))
+				assert.Equal(t, test.want.problem, p.Code)
+				assert.Equal(t, test.want.code, p.Status)
+			} else {
+				assert.Equal(t, string(body), want)
 			}
-			assert.Equal(t, string(body), want)
 			assert.Equal(t, test.want.code, resp.StatusCode)
 			assert.Equal(t, test.want.contentType, resp.Header.Get("Content-Type"))
 
@@ -245,8 +318,9 @@ func Test_shortenJsonHandler(t *testing.T) {
 			want: want{
 				code:        400,
 				response:    "",
-				contentType: "",
+				contentType: "application/problem+json",
 				shortCode:   "",
+				problem:     "invalid_request",
 			},
 		},
 	}
@@ -280,9 +354,16 @@ func Test_shortenJsonHandler(t *testing.T) {
 			body, _ := io.ReadAll(resp.Body)
 			var want string
 			if test.want.shortCode != "" {
-				want = `{"result":"http://` + r.Config.GetConfig().OuterAddress + "/" + test.want.shortCode + `"}`
+				want = `{"result":"` + r.Config.GetConfig().OuterAddress + "/" + test.want.shortCode + `"}`
+			}
+			if test.want.problem != "" {
+				var p Problem
+				require.NoError(t, json.Unmarshal(body, &p))
+				assert.Equal(t, test.want.problem, p.Code)
+				assert.Equal(t, test.want.code, p.Status)
+			} else {
+				assert.Equal(t, string(body), want)
 			}
-			assert.Equal(t, string(body), want)
 			assert.Equal(t, test.want.code, resp.StatusCode)
 			assert.Equal(t, test.want.contentType, resp.Header.Get("Content-Type"))
 
@@ -303,7 +384,7 @@ func Test_expand(t *testing.T) {
 				response:    "",
 				contentType: "text/plain",
 				shortCode:   "12345678",
-				location:    "http://mail.ru",
+				location:    "http://mail.ru/",
 			},
 		},
 		{
@@ -378,3 +459,1067 @@ func Test_expand(t *testing.T) {
 		})
 	}
 }
+func Test_expandBasePath(t *testing.T) {
+	tests := []struct {
+		name     string
+		host     string
+		path     string
+		code     int
+		location string
+	}{
+		{name: "base host", host: "localhoxt:8080", path: "/s/12345678", code: http.StatusTemporaryRedirect, location: "http://ya.ru/"},
+		{name: "alias host", host: "go.example.com", path: "/s/12345678", code: http.StatusTemporaryRedirect, location: "http://ya.ru/"},
+		{name: "unknown host", host: "evil.example.com", path: "/s/12345678", code: http.StatusNotFound},
+		{name: "without prefix", host: "go.example.com", path: "/12345678", code: http.StatusNotFound},
+	}
+
+	var strg = TestStorage{
+		InnerLinks:  map[string]string{},
+		OutterLinks: map[string]string{},
+		Test:        test{shortCode: "12345678"},
+	}
+	cnf := testConfig(t)
+	cnf.BasePath = "/s"
+	cnf.AcceptedHosts = []string{"localhoxt", "go.example.com"}
+	_, router := newTestRouter(t, cnf, func(c *Connect) { c.Storage = &strg })
+	short, _ := strg.CreateShortURL("http://ya.ru/", cnf.GetConfig().OuterAddress, "")
+	require.Equal(t, "http://localhoxt:8080/s/12345678", short)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := do(t, router, testRequest{target: test.path, host: test.host})
+			assert.Equal(t, test.code, resp.StatusCode)
+			assert.Equal(t, test.location, resp.Header.Get("Location"))
+		})
+	}
+}
+func Test_tenantDomains(t *testing.T) {
+	tests := []struct {
+		name     string
+		apiKey   string
+		body     string
+		code     int
+		response string
+	}{
+		{name: "default domain", body: `{"url": "http://ya.ru/"}`, code: http.StatusCreated, response: `{"result":"http://localhoxt:8080/12345678"}`},
+		{name: "domain by key", apiKey: "brand-key", body: `{"url": "http://ya.ru/"}`, code: http.StatusCreated, response: `{"result":"http://brand.example/12345678"}`},
+		{name: "domain by field", apiKey: "brand-key", body: `{"url": "http://ya.ru/", "domain": "go.brand.example"}`, code: http.StatusCreated, response: `{"result":"http://go.brand.example/12345678"}`},
+		{name: "open domain", body: `{"url": "http://ya.ru/", "domain": "open.example"}`, code: http.StatusCreated, response: `{"result":"http://open.example/12345678"}`},
+		{name: "domain without key", body: `{"url": "http://ya.ru/", "domain": "brand.example"}`, code: http.StatusForbidden, response: "forbidden"},
+		{name: "private domain", body: `{"url": "http://ya.ru/", "domain": "club.example"}`, code: http.StatusForbidden, response: "forbidden"},
+		{name: "foreign domain", apiKey: "brand-key", body: `{"url": "http://ya.ru/", "domain": "open.example"}`, code: http.StatusForbidden, response: "forbidden"},
+		{name: "unknown key", apiKey: "wrong", body: `{"url": "http://ya.ru/"}`, code: http.StatusUnauthorized, response: "unauthorized"},
+		{name: "unknown domain", body: `{"url": "http://ya.ru/", "domain": "unknown.example"}`, code: http.StatusBadRequest, response: "invalid_domain"},
+	}
+
+	var strg = TestStorage{
+		InnerLinks:  map[string]string{},
+		OutterLinks: map[string]string{},
+		Test:        test{shortCode: "12345678"},
+	}
+	file := t.TempDir() + "/tenants.json"
+	require.NoError(t, os.WriteFile(file, []byte(`[{"name":"brand","domains":["brand.example","go.brand.example"],"api_key":"brand-key"},`+
+		`{"name":"open","domains":["open.example"]},{"name":"club","domains":["club.example"],"private":true}]`), 0o600))
+	r, router := newTestRouter(t, nil, func(c *Connect) {
+		c.Storage = &strg
+		c.AdminToken = "admin"
+		c.Tenants = tenant.NewRegistry()
+		require.NoError(t, c.Tenants.(*tenant.Registry).LoadFromFile(file, c.Keys))
+	})
+	// в реестре и в хранилище ключей остается только хеш ключа арендатора
+	brand, _ := r.Tenants.ByName("brand")
+	assert.Empty(t, brand.APIKey)
+	keys := r.Keys.List()
+	require.Len(t, keys, 1)
+	assert.Equal(t, "brand", keys[0].Tenant)
+	assert.NotContains(t, keys[0].Hash, "brand-key")
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := do(t, router, testRequest{method: http.MethodPost, target: "/api/shorten", body: test.body,
+				header: map[string]string{"X-API-Key": test.apiKey}})
+			body, _ := io.ReadAll(resp.Body)
+			assert.Equal(t, test.code, resp.StatusCode)
+			if test.code >= http.StatusBadRequest {
+				var p Problem
+				require.NoError(t, json.Unmarshal(body, &p))
+				assert.Equal(t, test.response, p.Code)
+				return
+			}
+			assert.Equal(t, test.response, string(body))
+		})
+	}
+
+	// код ссылки ищется в пространстве имен домена из заголовка Host
+	strg.InnerLinks["http://go.brand.example/87654321"] = "http://mail.ru/"
+	runSteps(t, router, []testStep{
+		{name: "tenant domain", req: testRequest{target: "/87654321", host: "go.brand.example"}, code: http.StatusTemporaryRedirect,
+			check: func(t *testing.T, resp *http.Response) {
+				assert.Equal(t, "http://mail.ru/", resp.Header.Get("Location"))
+			}},
+		{name: "other domain", req: testRequest{target: "/87654321", host: "brand.example"}, code: http.StatusNotFound},
+	})
+
+	// ключ арендатора можно выпустить через api администратора, обычный ключ доменов арендатора не открывает
+	create := func(body string) *http.Response {
+		return do(t, router, testRequest{method: http.MethodPost, target: "/api/v1/admin/keys", body: body, token: "admin"})
+	}
+	var issued, plain KeyResponce
+	decodeBody(t, create(`{"name":"brand-ci","scopes":["shorten"],"tenant":"brand"}`), &issued)
+	assert.Equal(t, "brand", issued.Tenant)
+	decodeBody(t, create(`{"name":"backend","scopes":["shorten"]}`), &plain)
+	shorten := func(key string) testRequest {
+		return testRequest{method: http.MethodPost, target: "/api/shorten", body: `{"url": "http://ya.ru/ci"}`, header: map[string]string{"X-API-Key": key}}
+	}
+	runSteps(t, router, []testStep{
+		{name: "issued tenant key", req: shorten(issued.Key), code: http.StatusCreated, body: "http://brand.example/"},
+		{name: "plain key", req: shorten(plain.Key), code: http.StatusUnauthorized},
+		{name: "unknown tenant", req: testRequest{method: http.MethodPost, target: "/api/v1/admin/keys",
+			body: `{"name":"x","scopes":["shorten"],"tenant":"nobody"}`, token: "admin"}, code: http.StatusBadRequest},
+		{name: "revoke", req: testRequest{method: http.MethodDelete, target: "/api/v1/admin/keys/" + issued.ID, token: "admin"},
+			code: http.StatusNoContent},
+		{name: "revoked tenant key", req: shorten(issued.Key), code: http.StatusUnauthorized},
+		// после отзыва всех ключей домены арендатора остаются закрытыми
+		{name: "revoke config key", req: testRequest{method: http.MethodDelete, target: "/api/v1/admin/keys/" + keys[0].ID, token: "admin"},
+			code: http.StatusNoContent},
+		{name: "domain without keys", req: testRequest{method: http.MethodPost, target: "/api/shorten",
+			body: `{"url": "http://ya.ru/ci", "domain": "brand.example"}`}, code: http.StatusForbidden, body: `"code":"forbidden"`},
+	})
+}
+
+// Функция возвращает настройки сервиса для тестов с файлом хранилища во временном каталоге теста
+func testConfig(t *testing.T) *Cnfg {
+	var r = Cnfg{
+		NetAddressServerShortener: NetAddressServer{Host: "localhoxt", Port: 8080},
+		NetAddressServerExpand:    NetAddressServer{Host: "localhoxt", Port: 8080},
+		FileStoragePath:           FilePath{Path: t.TempDir() + "/storage.json"},
+	}
+	return &r
+}
+
+// newTestRouter - создает сервис с хранилищем в памяти и его роутер. Пустые настройки заменяются на testConfig,
+// setup меняет сервис перед созданием роутера
+func newTestRouter(t *testing.T, cnf *Cnfg, setup ...func(c *Connect)) (*Connect, http.Handler) {
+	logger.Initialize("debug")
+	if cnf == nil {
+		cnf = testConfig(t)
+	}
+	if cnf.FileStoragePath.Path == "" {
+		cnf.FileStoragePath.Path = t.TempDir() + "/storage.json"
+	}
+	r := NewConnect(storage.NewStorage(), cnf)
+	for _, f := range setup {
+		f(r)
+	}
+	return r, r.RouterFunc()
+}
+
+// Структура запроса к роутеру в тестах. Пустой метод означает GET, пустой contentType - application/json, token
+// передается в заголовке Authorization, remote и host заменяют адрес клиента и заголовок Host
+type testRequest struct {
+	method      string
+	target      string
+	body        string
+	contentType string
+	token       string
+	header      map[string]string
+	cookies     []*http.Cookie
+	remote      string
+	host        string
+}
+
+// do - выполняет запрос к роутеру, тело ответа закрывается по завершении теста
+func do(t *testing.T, h http.Handler, r testRequest) *http.Response {
+	if r.method == "" {
+		r.method = http.MethodGet
+	}
+	if r.contentType == "" {
+		r.contentType = "application/json"
+	}
+	request := httptest.NewRequest(r.method, r.target, strings.NewReader(r.body))
+	request.Header.Set("Content-Type", r.contentType)
+	if r.token != "" {
+		request.Header.Set("Authorization", "Bearer "+r.token)
+	}
+	for k, v := range r.header {
+		if v != "" {
+			request.Header.Set(k, v)
+		}
+	}
+	for _, c := range r.cookies {
+		request.AddCookie(c)
+	}
+	if r.remote != "" {
+		request.RemoteAddr = r.remote
+	}
+	if r.host != "" {
+		request.Host = r.host
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, request)
+	resp := w.Result()
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// Функция разбирает json тело ответа в v
+func decodeBody(t *testing.T, resp *http.Response, v interface{}) {
+	t.Helper()
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+}
+
+// Функция возвращает код короткой ссылки
+func shortCode(short string) string {
+	return short[strings.LastIndex(short, "/")+1:]
+}
+
+// Структура шага сценария: запрос, ожидаемый статус, подстрока тела ответа и дополнительная проверка ответа
+type testStep struct {
+	name  string
+	req   testRequest
+	code  int
+	body  string
+	check func(t *testing.T, resp *http.Response)
+}
+
+// runSteps - выполняет шаги сценария по порядку, каждый шаг проверяется отдельным подтестом. Тело ответа
+// остается доступным для дополнительной проверки
+func runSteps(t *testing.T, h http.Handler, steps []testStep) {
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			resp := do(t, h, step.req)
+			assert.Equal(t, step.code, resp.StatusCode)
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body = io.NopCloser(bytes.NewReader(body))
+			assert.Contains(t, string(body), step.body)
+			if step.check != nil {
+				step.check(t, resp)
+			}
+		})
+	}
+}
+
+// Функция проверяет, что ответ описывает ошибку в формате application/problem+json
+func isProblem(t *testing.T, resp *http.Response) {
+	assert.Equal(t, problemContentType, resp.Header.Get("Content-Type"))
+}
+
+func Test_userURLs(t *testing.T) {
+	_, router := newTestRouter(t, nil, func(c *Connect) { c.AdminToken = "admin" })
+	resp := do(t, router, testRequest{method: http.MethodPost, target: "/api/shorten/batch",
+		body: `[{"correlation_id":"1","original_url":"http://ya.ru/"},{"correlation_id":"2","original_url":"http://mail.ru/"}]`})
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.Len(t, resp.Cookies(), 1)
+	cookies := resp.Cookies()
+	var batch []BatchResponce
+	decodeBody(t, resp, &batch)
+	require.Len(t, batch, 2)
+	assert.Equal(t, "1", batch[0].CorrelationID)
+	code := shortCode(batch[0].ShortURL)
+
+	runSteps(t, router, []testStep{
+		{name: "without user", req: testRequest{target: "/api/user/urls"}, code: http.StatusUnauthorized},
+		{name: "list", req: testRequest{target: "/api/user/urls", cookies: cookies}, code: http.StatusOK,
+			check: func(t *testing.T, resp *http.Response) {
+				var urls []storage.StorageJSON
+				decodeBody(t, resp, &urls)
+				assert.Len(t, urls, 2)
+			}},
+		{name: "delete", req: testRequest{method: http.MethodDelete, target: "/api/user/urls", body: `["` + code + `"]`, cookies: cookies},
+			code: http.StatusAccepted},
+		{name: "deleted link", req: testRequest{target: "/" + code}, code: http.StatusGone},
+		// статистика доступна администратору, но не пользователю с cookie
+		{name: "stats by user", req: testRequest{target: "/api/internal/stats", cookies: cookies}, code: http.StatusForbidden, check: isProblem},
+		{name: "stats", req: testRequest{target: "/api/internal/stats", token: "admin"}, code: http.StatusOK, body: `{"urls":1,"users":1}`},
+	})
+}
+
+func Test_reshortenDeleted(t *testing.T) {
+	cnf := testConfig(t)
+	_, router := newTestRouter(t, cnf)
+	resp := do(t, router, testRequest{method: http.MethodPost, target: "/api/v1/shorten", body: `{"url":"http://ya.ru/"}`})
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	cookies := resp.Cookies()
+	var short JsResponce
+	decodeBody(t, resp, &short)
+	shorten := testRequest{method: http.MethodPost, target: "/api/v1/shorten", body: `{"url":"http://ya.ru/"}`, cookies: cookies}
+
+	// удаленная ссылка не мешает сократить ее исходную ссылку заново
+	runSteps(t, router, []testStep{
+		{name: "delete", req: testRequest{method: http.MethodDelete, target: "/api/v1/user/urls", body: `["` + shortCode(short.URL) + `"]`,
+			cookies: cookies}, code: http.StatusAccepted},
+		{name: "shorten again", req: shorten, code: http.StatusCreated, check: func(t *testing.T, resp *http.Response) {
+			var again JsResponce
+			decodeBody(t, resp, &again)
+			assert.NotEqual(t, short.URL, again.URL)
+		}},
+		{name: "conflict with new link", req: shorten, code: http.StatusConflict},
+	})
+
+	// после восстановления из файла обратный индекс указывает на действующую ссылку
+	restored := storage.NewStorage()
+	restored.RestoreFromfile(cnf.FileStoragePath.Path)
+	_, err := restored.CreateShortURL("http://ya.ru/", "http://localhoxt:8080", "")
+	var conflict *storage.ConflictError
+	require.ErrorAs(t, err, &conflict)
+	assert.NotEqual(t, short.URL, conflict.ShortURL)
+	assert.False(t, restored.Meta[conflict.ShortURL].Deleted)
+}
+
+func Test_webUI(t *testing.T) {
+	cnf := testConfig(t)
+	cnf.BasePath = "/s"
+	_, router := newTestRouter(t, cnf)
+	// страница выдает cookie пользователя
+	resp := do(t, router, testRequest{target: "/s/ui"})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NotEmpty(t, resp.Cookies())
+	cookies := resp.Cookies()[:1]
+	resp = do(t, router, testRequest{method: http.MethodPost, target: "/s/api/v1/shorten", body: `{"url":"http://ya.ru/"}`, cookies: cookies})
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var short JsResponce
+	decodeBody(t, resp, &short)
+	code := shortCode(short.URL)
+	contentType := func(ctype string) func(t *testing.T, resp *http.Response) {
+		return func(t *testing.T, resp *http.Response) {
+			assert.Contains(t, resp.Header.Get("Content-Type"), ctype)
+		}
+	}
+
+	runSteps(t, router, []testStep{
+		// пути к файлам и api строятся от базового адреса
+		{name: "page", req: testRequest{target: "/s/ui"}, code: http.StatusOK, body: `<form id="shorten">`,
+			check: func(t *testing.T, resp *http.Response) {
+				assert.Contains(t, resp.Header.Get("Content-Type"), "text/html")
+				assert.Contains(t, resp.Header.Get("Content-Security-Policy"), "frame-ancestors 'none'")
+				page, _ := io.ReadAll(resp.Body)
+				assert.Contains(t, string(page), `src="/s/ui/app.js"`)
+				assert.Contains(t, string(page), `data-base="/s/"`)
+			}},
+		{name: "script", req: testRequest{target: "/s/ui/app.js"}, code: http.StatusOK, check: contentType("javascript")},
+		{name: "styles", req: testRequest{target: "/s/ui/style.css"}, code: http.StatusOK, check: contentType("text/css")},
+		{name: "missing file", req: testRequest{target: "/s/ui/missing.js"}, code: http.StatusNotFound, check: isProblem},
+		// отдаются только файлы из списка, страница доступна лишь по пути /ui
+		{name: "page as file", req: testRequest{target: "/s/ui/index.html"}, code: http.StatusNotFound, check: isProblem},
+		{name: "escaped path", req: testRequest{target: "/s/ui/..%2Fwebui.go"}, code: http.StatusNotFound, check: isProblem},
+		// список ссылок пользователя содержит количество переходов
+		{name: "first click", req: testRequest{target: "/s/" + code}, code: http.StatusTemporaryRedirect},
+		{name: "second click", req: testRequest{target: "/s/" + code}, code: http.StatusTemporaryRedirect},
+		{name: "clicks", req: testRequest{target: "/s/api/v1/user/urls", cookies: cookies}, code: http.StatusOK,
+			check: func(t *testing.T, resp *http.Response) {
+				var links []storage.StorageJSON
+				decodeBody(t, resp, &links)
+				require.Len(t, links, 1)
+				assert.Equal(t, int64(2), links[0].Clicks)
+			}},
+	})
+}
+
+func Test_swaggerUI(t *testing.T) {
+	cnf := testConfig(t)
+	cnf.BasePath = "/s"
+	_, router := newTestRouter(t, cnf)
+	contentType := func(ctype string) func(t *testing.T, resp *http.Response) {
+		return func(t *testing.T, resp *http.Response) {
+			assert.Contains(t, resp.Header.Get("Content-Type"), ctype)
+			assert.Equal(t, uiSecurityPolicy, resp.Header.Get("Content-Security-Policy"))
+		}
+	}
+
+	runSteps(t, router, []testStep{
+		// страница загружает Swagger UI только с самого сервиса
+		{name: "page", req: testRequest{target: "/s/swagger"}, code: http.StatusOK, body: `data-base="/s/"`,
+			check: func(t *testing.T, resp *http.Response) {
+				assert.Equal(t, uiSecurityPolicy, resp.Header.Get("Content-Security-Policy"))
+				page, _ := io.ReadAll(resp.Body)
+				assert.Contains(t, string(page), `src="/s/swagger/swagger-ui-bundle.js"`)
+				assert.NotContains(t, string(page), "//")
+			}},
+		{name: "bundle", req: testRequest{target: "/s/swagger/swagger-ui-bundle.js"}, code: http.StatusOK,
+			body: "SwaggerUIBundle", check: contentType("javascript")},
+		{name: "styles", req: testRequest{target: "/s/swagger/swagger-ui.css"}, code: http.StatusOK, check: contentType("text/css")},
+		{name: "init", req: testRequest{target: "/s/swagger/swagger.js"}, code: http.StatusOK, body: "api/openapi.json",
+			check: contentType("javascript")},
+		// отдаются только файлы из списка
+		{name: "not listed", req: testRequest{target: "/s/swagger/index.html"}, code: http.StatusNotFound, check: isProblem},
+	})
+}
+
+func Test_saveClicks(t *testing.T) {
+	cnf := testConfig(t)
+	c, router := newTestRouter(t, cnf)
+	resp := do(t, router, testRequest{method: http.MethodPost, target: "/api/v1/shorten", body: `{"url":"http://ya.ru/"}`})
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var short JsResponce
+	decodeBody(t, resp, &short)
+	// файл может читаться во время периодического сохранения, недописанный файл не содержит счетчика
+	clicks := func() int64 {
+		var links []storage.StorageJSON
+		data, _ := os.ReadFile(cnf.FileStoragePath.Path)
+		if json.Unmarshal(data, &links) != nil {
+			return -1
+		}
+		for _, l := range links {
+			if l.ShortLink == short.URL {
+				return l.Clicks
+			}
+		}
+		return -1
+	}
+
+	// раскрытия считаются одновременно и не сохраняют хранилище сами
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, http.StatusTemporaryRedirect, do(t, router, testRequest{target: "/" + shortCode(short.URL)}).StatusCode)
+		}()
+	}
+	wg.Wait()
+	assert.Zero(t, clicks())
+	c.SaveClicks(context.Background())
+	assert.Equal(t, int64(20), clicks())
+	assert.False(t, c.Storage.SaveClicks(cnf.FileStoragePath.Path))
+
+	// периодическое сохранение записывает новые раскрытия без других изменений хранилища
+	stop := make(chan struct{})
+	defer close(stop)
+	go c.FlushClicks(10*time.Millisecond, stop)
+	do(t, router, testRequest{target: "/" + shortCode(short.URL)})
+	assert.Eventually(t, func() bool { return clicks() == 21 }, time.Second, 10*time.Millisecond)
+}
+
+func Test_qrCode(t *testing.T) {
+	_, router := newTestRouter(t, nil)
+	resp := do(t, router, testRequest{method: http.MethodPost, target: "/api/v1/shorten", body: `{"url":"http://ya.ru/","qr_code":true}`})
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	cookies := resp.Cookies()
+	var short JsResponce
+	decodeBody(t, resp, &short)
+	assert.True(t, strings.HasPrefix(short.QRCode, "data:image/png;base64,"))
+	code := shortCode(short.URL)
+
+	steps := []testStep{
+		{name: "without qr code", req: testRequest{method: http.MethodPost, target: "/api/v1/shorten", body: `{"url":"http://mail.ru/"}`},
+			code: http.StatusCreated, check: func(t *testing.T, resp *http.Response) {
+				body, _ := io.ReadAll(resp.Body)
+				assert.NotContains(t, string(body), "qr_code")
+			}},
+		{name: "png", req: testRequest{target: "/" + code + "/qr?size=300&margin=2&level=h"}, code: http.StatusOK,
+			check: func(t *testing.T, resp *http.Response) {
+				assert.Equal(t, "image/png", resp.Header.Get("Content-Type"))
+				img, err := png.Decode(resp.Body)
+				require.NoError(t, err)
+				assert.Equal(t, 300, img.Bounds().Dx())
+			}},
+		{name: "svg", req: testRequest{target: "/" + code + "/qr?format=svg"}, code: http.StatusOK, body: `width="256" height="256"`,
+			check: func(t *testing.T, resp *http.Response) {
+				assert.Equal(t, "image/svg+xml", resp.Header.Get("Content-Type"))
+			}},
+	}
+	for _, query := range []string{"format=gif", "size=big", "size=5000", "margin=-1", "level=Z", "size=10"} {
+		steps = append(steps, testStep{name: query, req: testRequest{target: "/" + code + "/qr?" + query}, code: http.StatusBadRequest,
+			check: isProblem})
+	}
+	steps = append(steps,
+		testStep{name: "unknown link", req: testRequest{target: "/zzzzzzzz/qr"}, code: http.StatusNotFound},
+		// QR код не считается переходом, у удаленной ссылки его нет
+		testStep{name: "not a click", req: testRequest{target: "/api/v1/user/urls", cookies: cookies}, code: http.StatusOK,
+			check: func(t *testing.T, resp *http.Response) {
+				var links []storage.StorageJSON
+				decodeBody(t, resp, &links)
+				require.Len(t, links, 1)
+				assert.Zero(t, links[0].Clicks)
+			}},
+		testStep{name: "delete", req: testRequest{method: http.MethodDelete, target: "/api/v1/user/urls", body: `["` + code + `"]`, cookies: cookies},
+			code: http.StatusAccepted},
+		testStep{name: "deleted link", req: testRequest{target: "/" + code + "/qr"}, code: http.StatusGone},
+	)
+	runSteps(t, router, steps)
+}
+
+func Test_editURL(t *testing.T) {
+	cnf := testConfig(t)
+	_, router := newTestRouter(t, cnf)
+	resp := do(t, router, testRequest{method: http.MethodPost, target: "/api/shorten/batch",
+		body: `[{"correlation_id":"1","original_url":"http://ya.ru/typo"},{"correlation_id":"2","original_url":"http://mail.ru/"}]`})
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	cookies := resp.Cookies()
+	var batch []BatchResponce
+	decodeBody(t, resp, &batch)
+	code := shortCode(batch[0].ShortURL)
+	other := do(t, router, testRequest{method: http.MethodPost, target: "/api/shorten", body: `{"url":"http://other.ru/"}`}).Cookies()
+	edit := func(url string, cookies []*http.Cookie) testRequest {
+		return testRequest{method: http.MethodPatch, target: "/api/v1/user/urls/" + code, body: `{"url":"` + url + `"}`, cookies: cookies}
+	}
+
+	runSteps(t, router, []testStep{
+		// чужую ссылку изменить нельзя
+		{name: "without user", req: edit("http://ya.ru/fixed", nil), code: http.StatusUnauthorized},
+		{name: "foreign link", req: edit("http://ya.ru/fixed", other), code: http.StatusNotFound},
+		// новая ссылка уже сокращена в том же пространстве имен
+		{name: "conflict", req: edit("http://mail.ru/", cookies), code: http.StatusConflict,
+			check: func(t *testing.T, resp *http.Response) {
+				var p Problem
+				decodeBody(t, resp, &p)
+				assert.Equal(t, batch[1].ShortURL, p.ShortURL)
+			}},
+		{name: "edit", req: edit("HTTP://YA.RU/fixed", cookies), code: http.StatusOK,
+			check: func(t *testing.T, resp *http.Response) {
+				var link storage.StorageJSON
+				decodeBody(t, resp, &link)
+				assert.Equal(t, storage.StorageJSON{ShortLink: batch[0].ShortURL, OriginalLink: "http://ya.ru/fixed"}, link)
+			}},
+		{name: "redirect", req: testRequest{target: "/" + code}, code: http.StatusTemporaryRedirect,
+			check: func(t *testing.T, resp *http.Response) {
+				assert.Equal(t, "http://ya.ru/fixed", resp.Header.Get("Location"))
+			}},
+		// обратный индекс перенесен: новая ссылка дает конфликт, прежнюю можно сократить заново
+		{name: "new url", req: testRequest{method: http.MethodPost, target: "/api/shorten", body: `{"url":"http://ya.ru/fixed"}`, cookies: cookies},
+			code: http.StatusConflict},
+		{name: "previous url", req: testRequest{method: http.MethodPost, target: "/api/shorten", body: `{"url":"http://ya.ru/typo"}`, cookies: cookies},
+			code: http.StatusCreated},
+		{name: "history", req: testRequest{target: "/api/user/urls/" + code + "/history", cookies: cookies}, code: http.StatusOK,
+			check: func(t *testing.T, resp *http.Response) {
+				var h storage.LinkHistory
+				decodeBody(t, resp, &h)
+				assert.Equal(t, "http://ya.ru/fixed", h.OriginalLink)
+				require.Len(t, h.History, 1)
+				assert.Equal(t, "http://ya.ru/typo", h.History[0].OriginalLink)
+				assert.False(t, h.History[0].Replaced.IsZero())
+				// история сохраняется в файле хранилища
+				restored := storage.NewStorage()
+				restored.RestoreFromfile(cnf.FileStoragePath.Path)
+				assert.Equal(t, h.History, restored.Meta[batch[0].ShortURL].History)
+			}},
+		{name: "delete", req: testRequest{method: http.MethodDelete, target: "/api/user/urls", body: `["` + code + `"]`, cookies: cookies},
+			code: http.StatusAccepted},
+		{name: "edit deleted", req: edit("http://ya.ru/again", cookies), code: http.StatusGone},
+	})
+}
+
+func Test_editToDeletedURL(t *testing.T) {
+	_, router := newTestRouter(t, nil, func(c *Connect) { c.AdminToken = "admin" })
+	resp := do(t, router, testRequest{method: http.MethodPost, target: "/api/shorten/batch",
+		body: `[{"correlation_id":"1","original_url":"http://ya.ru/"},{"correlation_id":"2","original_url":"http://mail.ru/"}]`})
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	cookies := resp.Cookies()
+	var batch []BatchResponce
+	decodeBody(t, resp, &batch)
+	edit := testRequest{method: http.MethodPatch, target: "/api/v1/user/urls/" + shortCode(batch[0].ShortURL),
+		body: `{"url":"http://mail.ru/"}`, cookies: cookies}
+
+	// удаленная ссылка не дает конфликта при замене исходной ссылки, обратный индекс переходит к измененной ссылке
+	runSteps(t, router, []testStep{
+		{name: "conflict", req: edit, code: http.StatusConflict},
+		{name: "force delete", req: testRequest{method: http.MethodDelete, target: "/api/v1/admin/links", body: `["` + batch[1].ShortURL + `"]`,
+			token: "admin"}, code: http.StatusOK},
+		{name: "edit", req: edit, code: http.StatusOK},
+		{name: "shorten", req: testRequest{method: http.MethodPost, target: "/api/v1/shorten", body: `{"url":"http://mail.ru/"}`, cookies: cookies},
+			code: http.StatusConflict, body: batch[0].ShortURL},
+	})
+}
+
+func Test_audit(t *testing.T) {
+	_, router := newTestRouter(t, nil, func(c *Connect) {
+		c.AdminToken = "admin"
+		c.Audit = audit.NewStore()
+		c.TrustedProxies, _ = logger.ParseTrustedProxies([]string{"192.0.2.0/24"})
+	})
+	resp := do(t, router, testRequest{method: http.MethodPost, target: "/api/v1/shorten", body: `{"url":"http://ya.ru/"}`,
+		header: map[string]string{"X-Forwarded-For": "203.0.113.7"}})
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	cookies := resp.Cookies()
+	var short JsResponce
+	decodeBody(t, resp, &short)
+	code := shortCode(short.URL)
+
+	runSteps(t, router, []testStep{
+		// повторное сокращение ничего не меняет и не записывается
+		{name: "conflict", req: testRequest{method: http.MethodPost, target: "/api/v1/shorten", body: `{"url":"http://ya.ru/"}`, cookies: cookies},
+			code: http.StatusConflict},
+		{name: "edit", req: testRequest{method: http.MethodPatch, target: "/api/v1/user/urls/" + code, body: `{"url":"http://ya.ru/maps"}`,
+			cookies: cookies}, code: http.StatusOK},
+		{name: "block", req: testRequest{method: http.MethodPost, target: "/api/v1/admin/block",
+			body: `{"short_url":"` + short.URL + `","reason":"spam"}`, token: "admin"}, code: http.StatusNoContent},
+		{name: "unblock", req: testRequest{method: http.MethodPost, target: "/api/v1/admin/unblock",
+			body: `{"short_url":"` + short.URL + `"}`, token: "admin"}, code: http.StatusNoContent},
+		{name: "delete", req: testRequest{method: http.MethodDelete, target: "/api/v1/user/urls", body: `["` + code + `"]`, cookies: cookies},
+			code: http.StatusAccepted},
+	})
+	resp = do(t, router, testRequest{method: http.MethodPost, target: "/api/v1/admin/keys", body: `{"name":"backend","scopes":["shorten"]}`,
+		token: "admin"})
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var key KeyResponce
+	decodeBody(t, resp, &key)
+	require.Equal(t, http.StatusNoContent, do(t, router, testRequest{method: http.MethodDelete, target: "/api/v1/admin/keys/" + key.ID,
+		token: "admin"}).StatusCode)
+
+	query := func(params string) testRequest {
+		return testRequest{target: "/api/v1/admin/audit" + params, token: "admin"}
+	}
+	page := func(check func(t *testing.T, page AuditResponce)) func(t *testing.T, resp *http.Response) {
+		return func(t *testing.T, resp *http.Response) {
+			var page AuditResponce
+			decodeBody(t, resp, &page)
+			check(t, page)
+		}
+	}
+	runSteps(t, router, []testStep{
+		{name: "all", req: query(""), code: http.StatusOK, check: page(func(t *testing.T, page AuditResponce) {
+			assert.Equal(t, 7, page.Total)
+			var actions []string
+			for _, e := range page.Events {
+				actions = append(actions, e.Action)
+			}
+			assert.Equal(t, []string{audit.ActionKeyRevoke, audit.ActionKeyCreate, audit.ActionDelete, audit.ActionUnblock,
+				audit.ActionBlock, audit.ActionEdit, audit.ActionCreate}, actions)
+		})},
+		{name: "by target", req: query("?target=" + code), code: http.StatusOK, check: page(func(t *testing.T, page AuditResponce) {
+			require.Equal(t, 5, page.Total)
+			edit, create := page.Events[3], page.Events[4]
+			assert.Equal(t, "http://ya.ru/", edit.Before)
+			assert.Equal(t, "http://ya.ru/maps", edit.After)
+			assert.Equal(t, create.Actor, edit.Actor)
+			assert.NotEmpty(t, create.Actor)
+			assert.Equal(t, "203.0.113.7", create.IP)
+			assert.NotEmpty(t, create.RequestID)
+			assert.Equal(t, "spam", page.Events[1].Before)
+			assert.Equal(t, "admin", page.Events[1].Actor)
+			assert.Equal(t, "http://ya.ru/maps", page.Events[0].Before)
+		})},
+		{name: "by action", req: query("?action=key.create&limit=1"), code: http.StatusOK, check: page(func(t *testing.T, page AuditResponce) {
+			require.Len(t, page.Events, 1)
+			assert.Equal(t, key.ID, page.Events[0].Target)
+			assert.Equal(t, "name=backend scopes=shorten", page.Events[0].After)
+		})},
+		{name: "page", req: query("?offset=5&limit=10"), code: http.StatusOK, check: page(func(t *testing.T, page AuditResponce) {
+			assert.Equal(t, 7, page.Total)
+			assert.Len(t, page.Events, 2)
+			assert.Equal(t, 10, page.Limit)
+		})},
+		{name: "future", req: query("?from=" + time.Now().Add(time.Hour).UTC().Format(time.RFC3339)), code: http.StatusOK,
+			check: page(func(t *testing.T, page AuditResponce) {
+				assert.Equal(t, 0, page.Total)
+				assert.NotNil(t, page.Events)
+			})},
+		{name: "invalid time", req: query("?from=yesterday"), code: http.StatusBadRequest},
+		{name: "invalid limit", req: query("?limit=-1"), code: http.StatusBadRequest},
+		{name: "user", req: testRequest{target: "/api/v1/admin/audit", cookies: cookies}, code: http.StatusUnauthorized},
+	})
+}
+
+func Test_adminLinks(t *testing.T) {
+	cnf := testConfig(t)
+	var strg = storage.NewStorage()
+	r, router := newTestRouter(t, cnf, func(c *Connect) {
+		c.Storage = strg
+		c.AdminToken = "admin"
+		c.Audit = audit.NewStore()
+	})
+	start := time.Now().UTC().Add(-time.Second)
+	resp := do(t, router, testRequest{method: http.MethodPost, target: "/api/v1/shorten/batch",
+		body: `[{"correlation_id":"1","original_url":"http://ya.ru/News"},{"correlation_id":"2","original_url":"http://mail.ru/"}]`})
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	alice := resp.Cookies()
+	var batch []BatchResponce
+	decodeBody(t, resp, &batch)
+	resp = do(t, router, testRequest{method: http.MethodPost, target: "/api/v1/shorten", body: `{"url":"http://news.ya.ru/"}`})
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	bob := resp.Cookies()
+	var bobs JsResponce
+	decodeBody(t, resp, &bobs)
+	owner := strg.Meta[batch[0].ShortURL].UserID
+	code := shortCode(batch[1].ShortURL)
+
+	search := func(params string, token string) testRequest {
+		return testRequest{target: "/api/v1/admin/links" + params, token: token}
+	}
+	total := func(n int) func(t *testing.T, resp *http.Response) {
+		return func(t *testing.T, resp *http.Response) {
+			var page LinksResponce
+			decodeBody(t, resp, &page)
+			assert.Equal(t, n, page.Total)
+		}
+	}
+	runSteps(t, router, []testStep{
+		// ссылки ищутся по подстроке исходной ссылки без учета регистра, владельцу и времени создания
+		{name: "by text", req: search("?q=NEWS", "admin"), code: http.StatusOK, check: func(t *testing.T, resp *http.Response) {
+			var page LinksResponce
+			decodeBody(t, resp, &page)
+			assert.Equal(t, 2, page.Total)
+			require.Len(t, page.Links, 2)
+			require.NotNil(t, page.Links[0].Created)
+			assert.False(t, page.Links[0].Created.Before(*page.Links[1].Created))
+		}},
+		{name: "by owner", req: search("?owner="+owner, "admin"), code: http.StatusOK, check: total(2)},
+		{name: "by text and owner", req: search("?q=ya.ru&owner="+owner+"&limit=1", "admin"), code: http.StatusOK,
+			check: func(t *testing.T, resp *http.Response) {
+				var page LinksResponce
+				decodeBody(t, resp, &page)
+				assert.Equal(t, 1, page.Total)
+				require.Len(t, page.Links, 1)
+				assert.Equal(t, batch[0].ShortURL, page.Links[0].ShortLink)
+			}},
+		{name: "from", req: search("?from="+start.Format(time.RFC3339), "admin"), code: http.StatusOK, check: total(3)},
+		{name: "to", req: search("?to="+start.Format(time.RFC3339), "admin"), code: http.StatusOK, check: total(0)},
+		{name: "page", req: search("?offset=2&limit=2", "admin"), code: http.StatusOK, check: func(t *testing.T, resp *http.Response) {
+			var page LinksResponce
+			decodeBody(t, resp, &page)
+			assert.Equal(t, 3, page.Total)
+			assert.Len(t, page.Links, 1)
+		}},
+		// удаление администратором по коду на базовом домене, повторное удаление ничего не меняет
+		{name: "force delete", req: testRequest{method: http.MethodDelete, target: "/api/v1/admin/links",
+			body: `["` + code + `","http://localhoxt:8080/unknown"]`, token: "admin"}, code: http.StatusOK, body: `{"affected":1}`,
+			check: func(t *testing.T, resp *http.Response) {
+				for _, short := range strg.OutterLinks {
+					assert.NotEqual(t, batch[1].ShortURL, short)
+				}
+			}},
+		{name: "deleted link", req: testRequest{target: "/" + code}, code: http.StatusGone},
+		{name: "without deleted", req: search("", "admin"), code: http.StatusOK, check: total(2)},
+		{name: "with deleted", req: search("?deleted=true", "admin"), code: http.StatusOK, check: total(3)},
+		// ссылка Боба переходит Алисе и видна в ее списке
+		{name: "reassign", req: testRequest{method: http.MethodPost, target: "/api/v1/admin/links/owner",
+			body: `{"short_urls":["` + bobs.URL + `"],"user_id":"` + owner + `"}`, token: "admin"}, code: http.StatusOK, body: `{"affected":1}`,
+			check: func(t *testing.T, resp *http.Response) {
+				events, _ := r.Audit.Query(audit.Filter{Action: audit.ActionReassign})
+				require.Len(t, events, 1)
+				assert.Equal(t, owner, events[0].After)
+				assert.NotEqual(t, owner, events[0].Before)
+			}},
+		{name: "new owner", req: testRequest{target: "/api/v1/user/urls", cookies: alice}, code: http.StatusOK,
+			check: func(t *testing.T, resp *http.Response) {
+				var urls []storage.StorageJSON
+				decodeBody(t, resp, &urls)
+				assert.Len(t, urls, 2)
+			}},
+		{name: "previous owner", req: testRequest{target: "/api/v1/user/urls", cookies: bob}, code: http.StatusNoContent},
+		{name: "reassign without user", req: testRequest{method: http.MethodPost, target: "/api/v1/admin/links/owner",
+			body: `{"short_urls":["` + bobs.URL + `"]}`, token: "admin"}, code: http.StatusBadRequest},
+		{name: "stats", req: testRequest{target: "/api/v1/admin/stats", token: "admin"}, code: http.StatusOK,
+			check: func(t *testing.T, resp *http.Response) {
+				var stats StorageStatsResponce
+				decodeBody(t, resp, &stats)
+				assert.Equal(t, storage.Summary{Links: 3, Active: 2, Deleted: 1, Users: 1}, stats.Summary)
+				assert.Equal(t, cnf.FileStoragePath.Path, stats.File)
+				assert.Positive(t, stats.FileSize)
+				// время создания сохраняется в файле хранилища
+				restored := storage.NewStorage()
+				restored.RestoreFromfile(cnf.FileStoragePath.Path)
+				assert.False(t, restored.Meta[bobs.URL].Created.IsZero())
+			}},
+	})
+
+	// роль администратора дает ключ с областью admin, остальным ключам и пользователям маршруты недоступны
+	var ops, app KeyResponce
+	decodeBody(t, do(t, router, testRequest{method: http.MethodPost, target: "/api/v1/admin/keys", body: `{"name":"ops","scopes":["admin"]}`,
+		token: "admin"}), &ops)
+	decodeBody(t, do(t, router, testRequest{method: http.MethodPost, target: "/api/v1/admin/keys", body: `{"name":"app","scopes":["read"]}`,
+		token: "admin"}), &app)
+	runSteps(t, router, []testStep{
+		{name: "admin key", req: search("?q=news.ya.ru", ops.Key), code: http.StatusOK, check: total(1)},
+		{name: "other key", req: search("", app.Key), code: http.StatusForbidden},
+		{name: "user", req: testRequest{target: "/api/v1/admin/stats", cookies: alice}, code: http.StatusUnauthorized},
+		{name: "invalid filter", req: search("?deleted=maybe", "admin"), code: http.StatusBadRequest},
+		// исходную ссылку удаленной администратором ссылки можно сократить заново
+		{name: "shorten deleted url", req: testRequest{method: http.MethodPost, target: "/api/v1/shorten", body: `{"url":"http://mail.ru/"}`,
+			cookies: alice}, code: http.StatusCreated},
+	})
+}
+
+type denyList []string
+
+func (d denyList) Check(url string) error {
+	for _, e := range d {
+		if strings.Contains(url, e) {
+			return fmt.Errorf("%w: %s", policy.ErrBlocked, e)
+		}
+	}
+	return nil
+}
+
+func Test_blocking(t *testing.T) {
+	var strg = storage.NewStorage()
+	_, router := newTestRouter(t, nil, func(c *Connect) {
+		c.Storage = strg
+		c.AdminToken = "admin"
+	})
+	resp := do(t, router, testRequest{method: http.MethodPost, target: "/api/v1/shorten", body: `{"url":"http://ya.ru/"}`})
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var short JsResponce
+	decodeBody(t, resp, &short)
+	code := shortCode(short.URL)
+	block := func(body string, token string) testRequest {
+		return testRequest{method: http.MethodPost, target: "/api/v1/admin/block", body: body, token: token}
+	}
+
+	runSteps(t, router, []testStep{
+		// блокировать ссылки может только администратор
+		{name: "without token", req: block(`{"short_url":"`+short.URL+`"}`, ""), code: http.StatusUnauthorized},
+		{name: "wrong token", req: block(`{"short_url":"`+short.URL+`"}`, "user"), code: http.StatusUnauthorized},
+		{name: "unknown link", req: block(`{"short_url":"http://localhoxt:8080/unknown"}`, "admin"), code: http.StatusNotFound},
+		{name: "block", req: block(`{"short_url":"`+short.URL+`","reason":"phishing"}`, "admin"), code: http.StatusNoContent},
+		{name: "blocked link", req: testRequest{target: "/" + code}, code: http.StatusForbidden, body: "phishing",
+			check: func(t *testing.T, resp *http.Response) {
+				assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+				assert.Empty(t, resp.Header.Get("Location"))
+			}},
+		{name: "unblock", req: testRequest{method: http.MethodPost, target: "/api/v1/admin/unblock", body: `{"short_url":"` + short.URL + `"}`,
+			token: "admin"}, code: http.StatusNoContent},
+		{name: "unblocked link", req: testRequest{target: "/" + code}, code: http.StatusTemporaryRedirect},
+	})
+
+	// списки запрещенных адресов действуют и на новые, и на уже сокращенные ссылки
+	strg.Policy = denyList{"ya.ru"}
+	runSteps(t, router, []testStep{
+		{name: "denied link", req: testRequest{target: "/" + code}, code: http.StatusForbidden},
+		{name: "denied url", req: testRequest{method: http.MethodPost, target: "/api/v1/shorten", body: `{"url":"http://ya.ru/news"}`},
+			code: http.StatusForbidden, body: `"code":"blocked"`},
+	})
+
+	// запрещенная ссылка в середине пакета отклоняет весь пакет, ссылки перед ней не сокращаются
+	before := strg.Summary()
+	runSteps(t, router, []testStep{
+		{name: "denied batch", req: testRequest{method: http.MethodPost, target: "/api/v1/shorten/batch",
+			body: `[{"correlation_id":"1","original_url":"http://mail.ru/"},{"correlation_id":"2","original_url":"http://ya.ru/news"},` +
+				`{"correlation_id":"3","original_url":"http://vk.com/"}]`},
+			code: http.StatusForbidden, body: `correlation_id 2`, check: isProblem},
+	})
+	assert.Equal(t, before, strg.Summary())
+	_, err := strg.CreateShortURL("http://mail.ru/", "http://localhoxt:8080", "")
+	assert.NoError(t, err)
+}
+
+func Test_apiKeys(t *testing.T) {
+	_, router := newTestRouter(t, nil, func(c *Connect) { c.AdminToken = "admin" })
+	resp := do(t, router, testRequest{method: http.MethodPost, target: "/api/v1/admin/keys", body: `{"name":"backend","scopes":["shorten","read"]}`,
+		token: "admin"})
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var key KeyResponce
+	decodeBody(t, resp, &key)
+	require.NotEmpty(t, key.Key)
+
+	runSteps(t, router, []testStep{
+		{name: "unknown scope", req: testRequest{method: http.MethodPost, target: "/api/v1/admin/keys",
+			body: `{"name":"backend","scopes":["shorten","root"]}`, token: "admin"}, code: http.StatusBadRequest},
+		// по ключу не выдается cookie, ссылки принадлежат пользователю ключа
+		{name: "shorten", req: testRequest{method: http.MethodPost, target: "/api/v1/shorten", body: `{"url":"http://ya.ru/"}`, token: key.Key},
+			code: http.StatusCreated, check: func(t *testing.T, resp *http.Response) {
+				assert.Empty(t, resp.Cookies())
+			}},
+		{name: "list", req: testRequest{target: "/api/v1/user/urls", token: key.Key}, code: http.StatusOK,
+			check: func(t *testing.T, resp *http.Response) {
+				var urls []storage.StorageJSON
+				decodeBody(t, resp, &urls)
+				assert.Len(t, urls, 1)
+			}},
+		// области действия и права администратора проверяются
+		{name: "scope", req: testRequest{method: http.MethodDelete, target: "/api/v1/user/urls", body: `[]`, token: key.Key},
+			code: http.StatusForbidden},
+		{name: "admin", req: testRequest{target: "/api/v1/admin/keys", token: key.Key}, code: http.StatusForbidden},
+		{name: "keys", req: testRequest{target: "/api/v1/admin/keys", token: "admin"}, code: http.StatusOK,
+			check: func(t *testing.T, resp *http.Response) {
+				body, _ := io.ReadAll(resp.Body)
+				assert.NotContains(t, string(body), key.Key)
+				assert.NotContains(t, string(body), "hash")
+			}},
+		{name: "revoke", req: testRequest{method: http.MethodDelete, target: "/api/v1/admin/keys/" + key.ID, token: "admin"},
+			code: http.StatusNoContent},
+		{name: "revoked key", req: testRequest{target: "/api/v1/user/urls", token: key.Key}, code: http.StatusUnauthorized},
+	})
+}
+
+func Test_rateLimit(t *testing.T) {
+	_, router := newTestRouter(t, nil, func(c *Connect) {
+		c.ShortenLimiter = ratelimit.New(0.1, 2)
+		c.ExpandLimiter = ratelimit.New(0.1, 1)
+	})
+	shorten := func(url string, remote string) testRequest {
+		return testRequest{method: http.MethodPost, target: "/", body: url, contentType: "text/plain", remote: remote}
+	}
+	var short string
+	for i := 0; i < 2; i++ {
+		resp := do(t, router, shorten("http://ya.ru/"+strconv.Itoa(i), "10.0.0.1:1000"))
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		body, _ := io.ReadAll(resp.Body)
+		short = string(body)
+	}
+	code := shortCode(short)
+
+	runSteps(t, router, []testStep{
+		{name: "limited", req: shorten("http://ya.ru/2", "10.0.0.1:1001"), code: http.StatusTooManyRequests, body: `"code":"rate_limited"`,
+			check: func(t *testing.T, resp *http.Response) {
+				assert.Equal(t, "10", resp.Header.Get("Retry-After"))
+			}},
+		// ограничения раздельные для сокращения и раскрытия и для разных клиентов
+		{name: "expand", req: testRequest{target: "/" + code, remote: "10.0.0.1:1000"}, code: http.StatusTemporaryRedirect},
+		{name: "expand limited", req: testRequest{target: "/" + code, remote: "10.0.0.1:1000"}, code: http.StatusTooManyRequests},
+		{name: "other client", req: shorten("http://ya.ru/2", "10.0.0.2:1000"), code: http.StatusCreated},
+	})
+}
+
+func Test_rateLimitBypass(t *testing.T) {
+	_, router := newTestRouter(t, nil, func(c *Connect) {
+		c.ShortenLimiter = ratelimit.New(0.1, 1)
+		c.TrustedProxies, _ = logger.ParseTrustedProxies([]string{"10.0.0.0/8"})
+	})
+	shorten := func(client string, key string, cookies []*http.Cookie) testRequest {
+		return testRequest{method: http.MethodPost, target: "/", body: "http://ya.ru/" + client, contentType: "text/plain", remote: "10.0.0.1:1000",
+			header: map[string]string{"X-Forwarded-For": client, "X-API-Key": key}, cookies: cookies}
+	}
+	resp := do(t, router, shorten("192.0.2.1", "", nil))
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	cookies := resp.Cookies()
+	noCookies := func(t *testing.T, resp *http.Response) {
+		assert.Empty(t, resp.Cookies())
+	}
+
+	// случайный ключ арендатора, действительная cookie и новая cookie не дают новой корзины тому же адресу,
+	// отказ не выдает новую cookie
+	runSteps(t, router, []testStep{
+		{name: "random key", req: shorten("192.0.2.1", "random", nil), code: http.StatusTooManyRequests, check: noCookies},
+		{name: "same user", req: shorten("192.0.2.1", "", cookies), code: http.StatusTooManyRequests, check: noCookies},
+		{name: "new user", req: shorten("192.0.2.1", "", nil), code: http.StatusTooManyRequests, check: noCookies},
+		// клиенты за доверенным прокси ограничиваются по своим адресам
+		{name: "other client", req: shorten("192.0.2.2", "", nil), code: http.StatusCreated},
+	})
+}
+
+func Test_apiV1Problems(t *testing.T) {
+	var strg = TestStorage{
+		InnerLinks:  map[string]string{},
+		OutterLinks: map[string]string{},
+		Test:        test{shortCode: "12345678"},
+	}
+	_, router := newTestRouter(t, nil, func(c *Connect) { c.Storage = &strg })
+	problem := func(code string) string {
+		return `"code":"` + code + `"`
+	}
+
+	steps := []testStep{
+		{name: "shorten", req: testRequest{method: http.MethodPost, target: "/api/v1/shorten", body: `{"url": "http://ya.ru/"}`},
+			code: http.StatusCreated},
+		// прежний маршрут остается псевдонимом и отвечает конфликтом на повторное сокращение равнозначной ссылки
+		{name: "conflict", req: testRequest{method: http.MethodPost, target: "/api/shorten", body: `{"url": "HTTP://YA.RU:80\n"}`},
+			code: http.StatusConflict, body: problem(CodeConflict), check: func(t *testing.T, resp *http.Response) {
+				var p Problem
+				decodeBody(t, resp, &p)
+				assert.Equal(t, "http://localhoxt:8080/12345678", p.ShortURL)
+			}},
+		// пустая ссылка в v1 является ошибкой, а прежние маршруты отвечают на нее как раньше статусом 201 без тела
+		{name: "empty url", req: testRequest{method: http.MethodPost, target: "/api/v1/shorten", body: `{}`},
+			code: http.StatusBadRequest, body: problem(CodeInvalidURL), check: isProblem},
+		{name: "empty url legacy", req: testRequest{method: http.MethodPost, target: "/api/shorten", body: `{}`},
+			code: http.StatusCreated, check: func(t *testing.T, resp *http.Response) {
+				body, _ := io.ReadAll(resp.Body)
+				assert.Empty(t, body)
+				assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+			}},
+		{name: "empty body legacy", req: testRequest{method: http.MethodPost, target: "/", contentType: "text/plain"},
+			code: http.StatusCreated, check: func(t *testing.T, resp *http.Response) {
+				body, _ := io.ReadAll(resp.Body)
+				assert.Empty(t, body)
+			}},
+		{name: "invalid url", req: testRequest{method: http.MethodPost, target: "/api/v1/shorten", body: `{"url": "javascript:alert(1)"}`},
+			code: http.StatusBadRequest, body: problem(CodeInvalidURL), check: isProblem},
+		{name: "invalid json", req: testRequest{method: http.MethodPost, target: "/api/v1/shorten", body: `{"url": `},
+			code: http.StatusBadRequest, body: problem(CodeInvalidRequest), check: isProblem},
+		{name: "not found", req: testRequest{target: "/87654321"}, code: http.StatusNotFound, body: problem(CodeNotFound),
+			check: func(t *testing.T, resp *http.Response) {
+				var p Problem
+				decodeBody(t, resp, &p)
+				assert.Equal(t, "/87654321", p.Instance)
+			}},
+		{name: "unauthorized", req: testRequest{target: "/api/v1/user/urls"}, code: http.StatusUnauthorized, body: problem(CodeUnauthorized)},
+		{name: "method", req: testRequest{method: http.MethodPut, target: "/api/v1/shorten"}, code: http.StatusMethodNotAllowed,
+			body: problem(CodeMethodNotAllowed)},
+	}
+	// профили и переменные диагностики доступны только на отдельном адресе
+	for _, target := range []string{"/debug/pprof/", "/debug/vars"} {
+		steps = append(steps, testStep{name: target, req: testRequest{target: target}, code: http.StatusNotFound})
+	}
+	runSteps(t, router, steps)
+}
+
+func Test_bodyLimits(t *testing.T) {
+	_, router := newTestRouter(t, nil)
+	compressed := func(s string) string {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write([]byte(s))
+		gz.Close()
+		return buf.String()
+	}
+	shorten := func(target string, body string, encoding string) testRequest {
+		return testRequest{method: http.MethodPost, target: target, body: body, header: map[string]string{"Content-Encoding": encoding}}
+	}
+
+	runSteps(t, router, []testStep{
+		{name: "gzip", req: shorten("/api/v1/shorten", compressed(`{"url": "http://ya.ru/"}`), "gzip"), code: http.StatusCreated},
+		// json с длинным хвостом пробелов сжимается в тысячи раз
+		{name: "gzip bomb", req: shorten("/api/v1/shorten", compressed(`{"url": "http://ya.ru/bomb"}`+strings.Repeat(" ", 32<<20)), "gzip"),
+			code: http.StatusRequestEntityTooLarge, body: `"code":"` + CodeTooLarge + `"`},
+		{name: "large body", req: shorten("/api/v1/shorten/batch", string(make([]byte, 2<<20)), ""),
+			code: http.StatusRequestEntityTooLarge, body: `"code":"` + CodeTooLarge + `"`},
+		{name: "unsupported encoding", req: shorten("/api/v1/shorten", `{}`, "compress"),
+			code: http.StatusUnsupportedMediaType, body: `"code":"` + CodeUnsupported + `"`},
+		{name: "not compressed", req: shorten("/api/v1/shorten", `{"url": "http://ya.ru/"}`, "gzip"),
+			code: http.StatusBadRequest, body: `"code":"` + CodeInvalidRequest + `"`},
+	})
+}
+
+func Test_requestID(t *testing.T) {
+	_, router := newTestRouter(t, nil)
+	requestID := func(want string) func(t *testing.T, resp *http.Response) {
+		return func(t *testing.T, resp *http.Response) {
+			if want == "" {
+				assert.Len(t, resp.Header.Get("X-Request-ID"), 32)
+				return
+			}
+			assert.Equal(t, want, resp.Header.Get("X-Request-ID"))
+		}
+	}
+
+	runSteps(t, router, []testStep{
+		{name: "client id", req: testRequest{method: http.MethodPost, target: "/api/v1/shorten", body: `{"url": "http://ya.ru/"}`,
+			header: map[string]string{"X-Request-ID": "edge-1"}}, code: http.StatusCreated, check: requestID("edge-1")},
+		// идентификатор возвращается и в ответах с ошибкой, в том числе с ошибкой разбора тела до хандлера
+		{name: "generated id", req: testRequest{method: http.MethodPost, target: "/api/v1/shorten", body: `{"url": `},
+			code: http.StatusBadRequest, check: requestID("")},
+		{name: "before handler", req: testRequest{method: http.MethodPost, target: "/api/v1/shorten", body: `{}`,
+			header: map[string]string{"X-Request-ID": "edge-2", "Content-Encoding": "compress"}},
+			code: http.StatusUnsupportedMediaType, check: requestID("edge-2")},
+	})
+}
+
+func Test_tracing(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTracerProvider(prev)
+
+	cnf := testConfig(t)
+	_, router := newTestRouter(t, cnf)
+	resp := do(t, router, testRequest{method: http.MethodPost, target: "/api/v1/shorten", body: `{"url": "http://ya.ru/"}`,
+		header: map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}})
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("traceparent"), "4bf92f3577b34da6a3ce929d0e0e4736")
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range rec.Ended() {
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", s.SpanContext().TraceID().String())
+		spans[s.Name()] = s
+	}
+	require.Contains(t, spans, "POST /api/v1/shorten/")
+	require.Contains(t, spans, "Shorten")
+	require.Contains(t, spans, "storage.CreateShortURL")
+	require.Contains(t, spans, "storage.SaveToFile")
+	// время сохранения в файл видно отдельным спаном внутри сокращения
+	assert.Equal(t, spans["POST /api/v1/shorten/"].SpanContext().SpanID(), spans["Shorten"].Parent().SpanID())
+	assert.Equal(t, spans["Shorten"].SpanContext().SpanID(), spans["storage.SaveToFile"].Parent().SpanID())
+	assert.Equal(t, cnf.FileStoragePath.Path, spans["storage.SaveToFile"].Attributes()[0].Value.AsString())
+}
@@ -1,25 +1,50 @@
 package netservice
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"io"
-	"log"
+	"net"
 	"net/http"
+	"path"
 	"strings"
 
 	"github.com/go-chi/chi"
 	"go.uber.org/zap"
 
+	"github.com/h1067675/shortUrl/cmd/storage"
+	"github.com/h1067675/shortUrl/internal/apikey"
+	"github.com/h1067675/shortUrl/internal/audit"
+	"github.com/h1067675/shortUrl/internal/auth"
 	"github.com/h1067675/shortUrl/internal/compress"
 	"github.com/h1067675/shortUrl/internal/logger"
+	"github.com/h1067675/shortUrl/internal/qr"
+	"github.com/h1067675/shortUrl/internal/ratelimit"
+	"github.com/h1067675/shortUrl/internal/tenant"
+	"github.com/h1067675/shortUrl/internal/tracing"
+	"github.com/h1067675/shortUrl/internal/urlnorm"
 )
 
 // Интерфейс для Storage
 type MemStorager interface {
-	CreateShortURL(url string, adr string) string
+	CreateShortURL(url string, adr string, userID string) (string, error)
+	CheckURL(url string) error
 	GetURL(url string) (l string, e error)
+	CountClick(short string)
+	GetUserURLs(userID string) []storage.StorageJSON
+	DeleteUserURLs(userID string, codes []string) []storage.StorageJSON
+	UpdateURL(userID string, code string, url string) (string, string, error)
+	GetURLHistory(userID string, code string) (storage.LinkHistory, error)
+	Stats() (urls int, users int)
+	BlockURL(short string, reason string) (string, error)
+	UnblockURL(short string) (string, error)
+	SearchURLs(f storage.Filter) ([]storage.StorageJSON, int)
+	ForceDeleteURLs(shorts []string) []storage.StorageJSON
+	ReassignURLs(shorts []string, userID string) []storage.StorageJSON
+	Summary() storage.Summary
 	SaveToFile(file string)
+	SaveClicks(file string) bool
 }
 
 // Интерфейс для Config
@@ -27,118 +52,494 @@ type Configurer interface {
 	GetConfig() struct {
 		ServerAddress   string
 		OuterAddress    string
+		BasePath        string
+		AcceptedHosts   []string
 		FileStoragePath string
 	}
 }
 
+// Интерфейс для реестра арендаторов
+type TenantRegistrar interface {
+	ByDomain(host string) (*tenant.Tenant, bool)
+	ByName(name string) (*tenant.Tenant, bool)
+}
+
 // Структура с сетевыми методами
 type Connect struct {
-	Router  chi.Router
-	Storage MemStorager
-	Config  Configurer
+	Router     chi.Router
+	Storage    MemStorager
+	Config     Configurer
+	Tenants    TenantRegistrar
+	Auth       *auth.Authenticator
+	Normalizer *urlnorm.Normalizer
+	AdminToken string
+	// Ключи api и файл, в котором они сохраняются
+	Keys         *apikey.Store
+	KeysFilePath string
+	// Ограничители частоты запросов на сокращение и раскрытие ссылок, пустой ограничитель ничего не ограничивает
+	ShortenLimiter *ratelimit.Limiter
+	ExpandLimiter  *ratelimit.Limiter
+	// Сжатие ответов, распаковка и ограничение размера тел запросов
+	Compressor *compress.Compressor
+	// Журнал доступа, если он не задан, то запросы в него не пишутся
+	AccessLog *logger.AccessLog
+	// Доверенные прокси, за которыми адрес клиента берется из заголовков запроса
+	TrustedProxies []*net.IPNet
+	// Журнал аудита изменяющих операций, если он не задан, то операции в него не пишутся
+	Audit *audit.Store
 }
 
 // Функция создания коннектора
 func NewConnect(i MemStorager, c Configurer) *Connect {
 	var r = Connect{
-		Router:  chi.NewRouter(),
-		Storage: i,
-		Config:  c,
+		Router:     chi.NewRouter(),
+		Storage:    i,
+		Config:     c,
+		Auth:       auth.New(""),
+		Normalizer: urlnorm.New(),
+		Keys:       apikey.NewStore(),
+		Compressor: compress.New(),
 	}
+	r.Compressor.OnError = writeProblem
 	return &r
 }
 
 // shortenHandler - хандлер сокращения URL, принимает text/plain, проверят Content-type, присваивает правильный Content-type ответу,
 // записывает правильный статус в ответ, получает тело запроса и если оно не пустое, то запрашивает сокращенную ссылку
-// и возвращает ответ. Во всех иных случаях возвращает в ответе Bad request
+// и возвращает ответ. На пустое тело, как и раньше, отвечает статусом 201 без тела. Во всех иных случаях возвращает
+// описание ошибки в формате application/problem+json
+//
+// @Summary      Сокращение ссылки
+// @Tags         shorten
+// @Accept       plain
+// @Produce      plain
+// @Param        X-API-Key  header  string  false  "Ключ арендатора"
+// @Param        url        body    string  true   "Исходная ссылка"
+// @Success      201  {string}  string   "Короткая ссылка"
+// @Failure      400  {object}  Problem  "Некорректный запрос или ссылка"
+// @Failure      401  {object}  Problem  "Неизвестный ключ арендатора"
+// @Failure      409  {object}  Problem  "Ссылка уже сокращена, короткая ссылка в поле short_url"
+// @Failure      429  {object}  Problem  "Превышено ограничение частоты запросов"
+// @Failure      413  {object}  Problem  "Тело запроса превышает ограничение размера"
+// @Security     BearerAuth
+// @Router       / [post]
 func (c *Connect) ShortenHandler(responce http.ResponseWriter, request *http.Request) {
 	// проверяем на content-type
-	if strings.Contains(request.Header.Get("Content-Type"), "text/plain") || strings.Contains(request.Header.Get("Content-type"), "application/x-gzip") {
-		var body string
-		// если прошли то присваиваем значение content-type: "text/plain" и статус 201
-		responce.Header().Add("Content-Type", "text/plain")
-		responce.WriteHeader(http.StatusCreated)
-		// получаем тело запроса
-		url, err := io.ReadAll(request.Body)
-		if err != nil {
-			log.Fatal(err)
-			responce.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		logger.Log.Debug("Body", zap.String("type json", string(url)))
-		// если тело запроса не пустое, то создаем сокращенный url и выводим в тело ответа
-		if len(url) > 0 {
-			body = c.Storage.CreateShortURL(string(url), c.Config.GetConfig().OuterAddress)
-			c.Storage.SaveToFile(c.Config.GetConfig().FileStoragePath)
-			responce.Write([]byte(body))
-		}
+	if !strings.Contains(request.Header.Get("Content-Type"), "text/plain") && !strings.Contains(request.Header.Get("Content-type"), "application/x-gzip") {
+		writeProblem(responce, request, ErrInvalidRequest)
 		return
 	}
-	responce.WriteHeader(http.StatusBadRequest)
+	// определяем домен арендатора по ключу из заголовка
+	adr, err := c.TenantAddress(request.Header.Get("X-API-Key"), "")
+	if err != nil {
+		writeProblem(responce, request, err)
+		return
+	}
+	// получаем тело запроса
+	url, err := io.ReadAll(request.Body)
+	if err != nil {
+		logger.FromContext(request.Context()).Error("Request wihtout body", zap.Error(err))
+		writeProblem(responce, request, bodyError(err))
+		return
+	}
+	logger.FromContext(request.Context()).Debug("Body", zap.String("type json", string(url)))
+	if len(url) == 0 && legacyEmptyURL(responce, request, "text/plain") {
+		return
+	}
+	// создаем сокращенный url и выводим в тело ответа
+	body, err := c.Shorten(request.Context(), string(url), adr, userID(request))
+	if err != nil {
+		writeProblem(responce, request, err)
+		return
+	}
+	// если прошли то присваиваем значение content-type: "text/plain" и статус 201
+	responce.Header().Add("Content-Type", "text/plain")
+	responce.WriteHeader(http.StatusCreated)
+	responce.Write([]byte(body))
 }
 
-// Структура разбора json запроса
+// Структура разбора json запроса, домен указывается для сокращения ссылки на домене арендатора, QRCode запрашивает
+// в ответе QR код короткой ссылки
 type JsRequest struct {
-	URL string `json:"url"`
+	URL    string `json:"url"`
+	Domain string `json:"domain,omitempty"`
+	QRCode bool   `json:"qr_code,omitempty"`
 }
 
-// Структура разбора json ответа
+// Структура разбора json ответа, QRCode содержит QR код короткой ссылки в виде data: ссылки на изображение PNG,
+// если он был запрошен
 type JsResponce struct {
-	URL string `json:"result"`
+	URL    string `json:"result"`
+	QRCode string `json:"qr_code,omitempty"`
+}
+
+// apiV1Key - ключ контекста запроса к маршрутам версии v1
+type apiV1Key struct{}
+
+// markV1 - отмечает запросы к маршрутам версии v1, прежние маршруты сохраняют поведение для старых клиентов
+func markV1(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(responce http.ResponseWriter, request *http.Request) {
+		next.ServeHTTP(responce, request.WithContext(context.WithValue(request.Context(), apiV1Key{}, true)))
+	})
+}
+
+// legacyEmptyURL - на прежних маршрутах отвечает на пустую ссылку статусом 201 без тела, как до появления версии v1,
+// и возвращает true. На маршрутах v1 ничего не записывает и возвращает false
+func legacyEmptyURL(responce http.ResponseWriter, request *http.Request, contentType string) bool {
+	if v1, _ := request.Context().Value(apiV1Key{}).(bool); v1 {
+		return false
+	}
+	responce.Header().Add("Content-Type", contentType)
+	responce.WriteHeader(http.StatusCreated)
+	return true
+}
+
+// writeJSON - записывает в ответ значение в формате json с указанным статусом
+func writeJSON(responce http.ResponseWriter, request *http.Request, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		writeProblem(responce, request, err)
+		return
+	}
+	responce.Header().Add("Content-Type", "application/json")
+	responce.WriteHeader(status)
+	responce.Write(body)
 }
 
 // ShortenJSONHandler - хандлер сокращения URL, юпринимает application/json, проверят Content-type, присваивает правильный Content-type ответу,
-// записывает правильный статус в ответ, получает тело запроса и если оно не пустое, то запрашивает сокращенную ссылку
-// и возвращает ответ. Во всех иных случаях возвращает в ответе Bad request
+// записывает правильный статус в ответ, получает тело запроса и если ссылка в нем не пустая, то запрашивает сокращенную ссылку
+// и возвращает ответ. На пустую ссылку прежний маршрут /api/shorten отвечает статусом 201 без тела, а /api/v1/shorten
+// описанием ошибки. Во всех иных случаях возвращает описание ошибки в формате application/problem+json
+//
+// @Summary      Сокращение ссылки в формате json
+// @Tags         shorten
+// @Accept       json
+// @Produce      json
+// @Param        X-API-Key  header  string     false  "Ключ арендатора"
+// @Param        request    body    JsRequest  true   "Исходная ссылка, домен арендатора и запрос QR кода"
+// @Success      201  {object}  JsResponce
+// @Failure      400  {object}  Problem  "Некорректный запрос, ссылка или домен"
+// @Failure      401  {object}  Problem  "Неизвестный ключ арендатора"
+// @Failure      403  {object}  Problem  "Домен не принадлежит арендатору"
+// @Failure      409  {object}  Problem  "Ссылка уже сокращена, короткая ссылка в поле short_url"
+// @Failure      429  {object}  Problem  "Превышено ограничение частоты запросов"
+// @Failure      413  {object}  Problem  "Тело запроса превышает ограничение размера"
+// @Security     BearerAuth
+// @Router       /api/v1/shorten [post]
+// @Router       /api/shorten [post]
 func (c *Connect) ShortenJSONHandler(responce http.ResponseWriter, request *http.Request) {
 	// проверяем на content-type
-	if strings.Contains(request.Header.Get("Content-Type"), "application/json") || strings.Contains(request.Header.Get("Content-type"), "application/x-gzip") {
-		// если прошли то присваиваем значение content-type: "application/json" и статус 201
-		responce.Header().Add("Content-Type", "application/json")
-		responce.WriteHeader(http.StatusCreated)
-		// получаем тело запроса
-		js, err := io.ReadAll(request.Body)
+	if !strings.Contains(request.Header.Get("Content-Type"), "application/json") && !strings.Contains(request.Header.Get("Content-type"), "application/x-gzip") {
+		writeProblem(responce, request, ErrInvalidRequest)
+		return
+	}
+	// получаем и разбираем тело запроса
+	js, err := io.ReadAll(request.Body)
+	if err != nil {
+		logger.FromContext(request.Context()).Error("Request wihtout body", zap.Error(err))
+		writeProblem(responce, request, bodyError(err))
+		return
+	}
+	logger.FromContext(request.Context()).Debug("Body", zap.String("type json", string(js)))
+	if len(js) == 0 && legacyEmptyURL(responce, request, "application/json") {
+		return
+	}
+	var url JsRequest
+	if err := json.Unmarshal(js, &url); err != nil {
+		logger.FromContext(request.Context()).Error("Error json parsing", zap.String("request body", string(js)))
+		writeProblem(responce, request, ErrInvalidRequest)
+		return
+	}
+	if url.URL == "" && legacyEmptyURL(responce, request, "application/json") {
+		return
+	}
+	// определяем домен арендатора по полю запроса или по ключу из заголовка
+	adr, err := c.TenantAddress(request.Header.Get("X-API-Key"), url.Domain)
+	if err != nil {
+		writeProblem(responce, request, err)
+		return
+	}
+	// создаем сокращенный url и выводим в тело ответа
+	extURL, err := c.Shorten(request.Context(), url.URL, adr, userID(request))
+	if err != nil {
+		writeProblem(responce, request, err)
+		return
+	}
+	r := JsResponce{URL: extURL}
+	if url.QRCode {
+		img, err := qr.Encode(extURL, qr.DefaultOptions())
 		if err != nil {
-			logger.Log.Error("Request wihtout body", zap.Error(err))
-			responce.WriteHeader(http.StatusBadRequest)
+			writeProblem(responce, request, err)
 			return
 		}
-		logger.Log.Debug("Body", zap.String("type json", string(js)))
-		// если тело запроса не пустое, то создаем сокращенный url и выводим в тело ответа
-		if len(js) > 0 {
-			var url JsRequest
-			if err := json.Unmarshal(js, &url); err != nil {
-				logger.Log.Error("Error json parsing", zap.String("request body", string(js)))
-			}
-			if url.URL == "" {
-				return
-			}
-			extURL := c.Storage.CreateShortURL(url.URL, c.Config.GetConfig().OuterAddress)
-			c.Storage.SaveToFile(c.Config.GetConfig().FileStoragePath)
-			result := JsResponce{URL: extURL}
-			body, err := json.Marshal(result)
-			if err != nil {
-				logger.Log.Error("Error json serialization", zap.String("var", fmt.Sprint(result)))
-			}
-			responce.Write(body)
-		}
+		r.QRCode = img.DataURI()
+	}
+	writeJSON(responce, request, http.StatusCreated, r)
+}
+
+// ShortenBatchHandler - хандлер пакетного сокращения URL, принимает application/json со списком ссылок с идентификаторами
+// корреляции и возвращает список коротких ссылок с теми же идентификаторами. На пустой или некорректный список
+// возвращает описание ошибки
+//
+// @Summary      Пакетное сокращение ссылок
+// @Tags         shorten
+// @Accept       json
+// @Produce      json
+// @Param        X-API-Key  header  string          false  "Ключ арендатора"
+// @Param        request    body    []BatchRequest  true   "Список исходных ссылок"
+// @Success      201  {array}   BatchResponce
+// @Failure      400  {object}  Problem  "Некорректный запрос или ссылка"
+// @Failure      401  {object}  Problem  "Неизвестный ключ арендатора"
+// @Failure      429  {object}  Problem  "Превышено ограничение частоты запросов"
+// @Failure      413  {object}  Problem  "Тело запроса превышает ограничение размера"
+// @Security     BearerAuth
+// @Router       /api/v1/shorten/batch [post]
+// @Router       /api/shorten/batch [post]
+func (c *Connect) ShortenBatchHandler(responce http.ResponseWriter, request *http.Request) {
+	if !strings.Contains(request.Header.Get("Content-Type"), "application/json") && !strings.Contains(request.Header.Get("Content-type"), "application/x-gzip") {
+		writeProblem(responce, request, ErrInvalidRequest)
+		return
+	}
+	var batch []BatchRequest
+	if err := json.NewDecoder(request.Body).Decode(&batch); err != nil || len(batch) == 0 {
+		logger.FromContext(request.Context()).Error("Error json parsing", zap.Error(err))
+		writeProblem(responce, request, bodyError(err))
+		return
+	}
+	adr, err := c.TenantAddress(request.Header.Get("X-API-Key"), "")
+	if err != nil {
+		writeProblem(responce, request, err)
+		return
+	}
+	result, err := c.ShortenBatch(request.Context(), batch, adr, userID(request))
+	if err != nil {
+		writeProblem(responce, request, err)
+		return
+	}
+	writeJSON(responce, request, http.StatusCreated, result)
+}
+
+// UserURLsHandler - хандлер получения ссылок пользователя. Пользователю без действительной cookie возвращает
+// Unauthorized, если ссылок нет, то No content
+//
+// @Summary      Ссылки пользователя
+// @Tags         user
+// @Produce      json
+// @Success      200  {array}   storage.StorageJSON
+// @Success      204  "У пользователя нет ссылок"
+// @Failure      401  {object}  Problem  "Пользователь не определен"
+// @Failure      403  {object}  Problem  "Ключ api без области read"
+// @Security     BearerAuth
+// @Router       /api/v1/user/urls [get]
+// @Router       /api/user/urls [get]
+func (c *Connect) UserURLsHandler(responce http.ResponseWriter, request *http.Request) {
+	id, ok := auth.FromContext(request.Context())
+	if !ok || id.Issued {
+		writeProblem(responce, request, ErrUnauthorized)
+		return
+	}
+	urls := c.UserURLs(request.Context(), id.UserID)
+	if len(urls) == 0 {
+		responce.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(responce, request, http.StatusOK, urls)
+}
+
+// DeleteUserURLsHandler - хандлер удаления ссылок пользователя, принимает json список кодов коротких ссылок.
+// Удаляются только ссылки самого пользователя, в ответ возвращается Accepted
+//
+// @Summary      Удаление ссылок пользователя
+// @Tags         user
+// @Accept       json
+// @Param        codes  body  []string  true  "Коды коротких ссылок"
+// @Success      202  "Ссылки удалены"
+// @Failure      400  {object}  Problem  "Некорректный запрос"
+// @Failure      401  {object}  Problem  "Пользователь не определен"
+// @Failure      403  {object}  Problem  "Ключ api без области delete"
+// @Failure      413  {object}  Problem  "Тело запроса превышает ограничение размера"
+// @Security     BearerAuth
+// @Router       /api/v1/user/urls [delete]
+// @Router       /api/user/urls [delete]
+func (c *Connect) DeleteUserURLsHandler(responce http.ResponseWriter, request *http.Request) {
+	id, ok := auth.FromContext(request.Context())
+	if !ok || id.Issued {
+		writeProblem(responce, request, ErrUnauthorized)
+		return
+	}
+	var codes []string
+	if err := json.NewDecoder(request.Body).Decode(&codes); err != nil {
+		logger.FromContext(request.Context()).Error("Error json parsing", zap.Error(err))
+		writeProblem(responce, request, bodyError(err))
+		return
+	}
+	c.DeleteUserURLs(request.Context(), id.UserID, codes)
+	responce.WriteHeader(http.StatusAccepted)
+}
+
+// Структура запроса на изменение исходной ссылки
+type UpdateRequest struct {
+	URL string `json:"url"`
+}
+
+// UpdateUserURLHandler - хандлер изменения исходной ссылки у короткой ссылки пользователя, принимает json с новой
+// исходной ссылкой и возвращает короткую ссылку с новой исходной. Прежняя исходная ссылка сохраняется в истории,
+// изменить можно только свою неудаленную и незаблокированную ссылку
+//
+// @Summary      Изменение исходной ссылки
+// @Tags         user
+// @Accept       json
+// @Produce      json
+// @Param        id       path  string         true  "Код короткой ссылки"
+// @Param        request  body  UpdateRequest  true  "Новая исходная ссылка"
+// @Success      200  {object}  storage.StorageJSON
+// @Failure      400  {object}  Problem  "Некорректный запрос или ссылка"
+// @Failure      401  {object}  Problem  "Пользователь не определен"
+// @Failure      403  {object}  Problem  "Ключ api без области edit или ссылка заблокирована"
+// @Failure      404  {object}  Problem  "Ссылка не найдена"
+// @Failure      409  {object}  Problem  "Новая ссылка уже сокращена, короткая ссылка в поле short_url"
+// @Failure      410  {object}  Problem  "Ссылка удалена"
+// @Failure      413  {object}  Problem  "Тело запроса превышает ограничение размера"
+// @Security     BearerAuth
+// @Router       /api/v1/user/urls/{id} [patch]
+// @Router       /api/user/urls/{id} [patch]
+func (c *Connect) UpdateUserURLHandler(responce http.ResponseWriter, request *http.Request) {
+	id, ok := auth.FromContext(request.Context())
+	if !ok || id.Issued {
+		writeProblem(responce, request, ErrUnauthorized)
+		return
+	}
+	var req UpdateRequest
+	if err := json.NewDecoder(request.Body).Decode(&req); err != nil {
+		logger.FromContext(request.Context()).Error("Error json parsing", zap.Error(err))
+		writeProblem(responce, request, bodyError(err))
+		return
+	}
+	short, url, err := c.UpdateUserURL(request.Context(), id.UserID, chi.URLParam(request, "id"), req.URL)
+	if err != nil {
+		writeProblem(responce, request, err)
+		return
+	}
+	writeJSON(responce, request, http.StatusOK, storage.StorageJSON{ShortLink: short, OriginalLink: url})
+}
+
+// URLHistoryHandler - хандлер получения истории исходных ссылок у короткой ссылки пользователя
+//
+// @Summary      История исходных ссылок
+// @Tags         user
+// @Produce      json
+// @Param        id  path  string  true  "Код короткой ссылки"
+// @Success      200  {object}  storage.LinkHistory
+// @Failure      401  {object}  Problem  "Пользователь не определен"
+// @Failure      403  {object}  Problem  "Ключ api без области read"
+// @Failure      404  {object}  Problem  "Ссылка не найдена"
+// @Failure      410  {object}  Problem  "Ссылка удалена"
+// @Security     BearerAuth
+// @Router       /api/v1/user/urls/{id}/history [get]
+// @Router       /api/user/urls/{id}/history [get]
+func (c *Connect) URLHistoryHandler(responce http.ResponseWriter, request *http.Request) {
+	id, ok := auth.FromContext(request.Context())
+	if !ok || id.Issued {
+		writeProblem(responce, request, ErrUnauthorized)
 		return
 	}
-	responce.WriteHeader(http.StatusBadRequest)
+	h, err := c.URLHistory(request.Context(), id.UserID, chi.URLParam(request, "id"))
+	if err != nil {
+		writeProblem(responce, request, err)
+		return
+	}
+	writeJSON(responce, request, http.StatusOK, h)
+}
+
+// StatsHandler - хандлер получения статистики сервиса: количество ссылок и пользователей
+//
+// @Summary      Статистика сервиса
+// @Tags         service
+// @Produce      json
+// @Success      200  {object}  StatsResponce
+// @Failure      403  {object}  Problem  "Пользователь с cookie или ключ api без области stats"
+// @Security     BearerAuth
+// @Router       /api/v1/internal/stats [get]
+// @Router       /api/internal/stats [get]
+func (c *Connect) StatsHandler(responce http.ResponseWriter, request *http.Request) {
+	writeJSON(responce, request, http.StatusOK, c.Stats(request.Context()))
 }
 
-// expandHundler - хандлер получения адреса по короткой ссылке. Получаем короткую ссылку из GET запроса
+// expandHundler - хандлер получения адреса по короткой ссылке. Получаем короткую ссылку из GET запроса, если хост
+// запроса принадлежит арендатору, то ищем ссылку в пространстве имен его домена, иначе проверяем что запрос пришел
+// на один из разрешенных хостов и ищем ссылку по базовому адресу, так что ссылка раскрывается на любом из доменов-псевдонимов.
+// На неизвестную ссылку возвращает Not found, на удаленную Gone, вместо перехода по заблокированной ссылке
+// показывает страницу с предупреждением
+//
+// @Summary      Переход по короткой ссылке
+// @Tags         expand
+// @Param        id  path  string  true  "Код короткой ссылки"
+// @Success      307  "Перенаправление на исходную ссылку в заголовке Location"
+// @Failure      403  {string}  string   "Страница с предупреждением о заблокированной ссылке"
+// @Failure      404  {object}  Problem  "Ссылка не найдена"
+// @Failure      410  {object}  Problem  "Ссылка удалена"
+// @Failure      429  {object}  Problem  "Превышено ограничение частоты запросов"
+// @Router       /{id} [get]
 func (c *Connect) ExpandHandler(responce http.ResponseWriter, request *http.Request) {
-	if request.Method == http.MethodGet {
-		outURL, err := c.Storage.GetURL("http://" + c.Config.GetConfig().ServerAddress + request.URL.Path)
-		if err != nil {
-			logger.Log.Error("Can't to get URL", zap.Error(err))
-			responce.WriteHeader(http.StatusBadRequest)
-		}
-		responce.Header().Add("Location", outURL)
-		responce.WriteHeader(http.StatusTemporaryRedirect)
+	if request.Method != http.MethodGet {
+		writeProblem(responce, request, ErrInvalidRequest)
+		return
 	}
-	responce.WriteHeader(http.StatusBadRequest)
+	outURL, err := c.ExpandURL(request.Context(), request.Host, request.URL.Path)
+	var blocked *storage.BlockedError
+	if errors.As(err, &blocked) {
+		writeBlockedPage(responce, request, blocked.Reason)
+		return
+	}
+	if err != nil {
+		logger.FromContext(request.Context()).Error("Can't to get URL", zap.Error(err))
+		writeProblem(responce, request, err)
+		return
+	}
+	responce.Header().Add("Location", outURL)
+	responce.WriteHeader(http.StatusTemporaryRedirect)
+}
+
+// notFoundHandler - хандлер для неизвестных маршрутов
+func notFoundHandler(responce http.ResponseWriter, request *http.Request) {
+	writeProblem(responce, request, ErrRouteNotFound)
+}
+
+// methodNotAllowedHandler - хандлер для неподдерживаемых методов известных маршрутов
+func methodNotAllowedHandler(responce http.ResponseWriter, request *http.Request) {
+	writeProblem(responce, request, ErrMethodNotAllowed)
+}
+
+// apiRoutes - маршруты api, монтируются под /api/v1 и под /api для совместимости со старыми клиентами
+func (c *Connect) apiRoutes(r chi.Router) {
+	r.Route("/shorten", func(r chi.Router) {
+		r.Use(requireScope(apikey.ScopeShorten), c.rateLimit(c.ShortenLimiter))
+		r.Post("/", c.ShortenJSONHandler)       // POST запрос с json направляем на сокращение ссылки
+		r.Post("/batch", c.ShortenBatchHandler) // POST запрос со списком ссылок направляем на пакетное сокращение
+	})
+	r.Route("/user/urls", func(r chi.Router) {
+		r.With(requireScope(apikey.ScopeRead)).Get("/", c.UserURLsHandler)               // GET запрос возвращает ссылки пользователя
+		r.With(requireScope(apikey.ScopeDelete)).Delete("/", c.DeleteUserURLsHandler)    // DELETE запрос удаляет ссылки пользователя
+		r.With(requireScope(apikey.ScopeEdit)).Patch("/{id}", c.UpdateUserURLHandler)    // PATCH запрос меняет исходную ссылку
+		r.With(requireScope(apikey.ScopeRead)).Get("/{id}/history", c.URLHistoryHandler) // GET запрос возвращает историю исходных ссылок
+	})
+	r.With(requireScope(apikey.ScopeStats)).Get("/internal/stats", c.StatsHandler) // GET запрос возвращает статистику сервиса
+	r.Get("/openapi.json", c.OpenAPIHandler)                                       // GET запрос возвращает спецификацию OpenAPI
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(c.adminOnly)
+		r.Post("/block", c.BlockHandler)           // POST запрос блокирует короткую ссылку
+		r.Post("/unblock", c.UnblockHandler)       // POST запрос снимает блокировку с короткой ссылки
+		r.Post("/keys", c.CreateKeyHandler)        // POST запрос создает ключ api
+		r.Get("/keys", c.ListKeysHandler)          // GET запрос возвращает список ключей api
+		r.Delete("/keys/{id}", c.RevokeKeyHandler) // DELETE запрос отзывает ключ api
+		r.Get("/log/level", c.LogLevelHandler)     // GET запрос возвращает уровень логгера
+		r.Put("/log/level", c.SetLogLevelHandler)  // PUT запрос меняет уровень логгера
+		r.Get("/audit", c.AuditHandler)            // GET запрос возвращает записи журнала аудита
+		r.Get("/links", c.SearchLinksHandler)      // GET запрос ищет ссылки по исходной ссылке, владельцу и дате
+		r.Delete("/links", c.DeleteLinksHandler)   // DELETE запрос удаляет ссылки независимо от владельца
+		r.Post("/links/owner", c.ReassignHandler)  // POST запрос передает ссылки другому пользователю
+		r.Get("/stats", c.StorageStatsHandler)     // GET запрос возвращает статистику хранилища
+	})
 }
 
 // routerFunc - создает роутер chi и делает маршрутизацию к хандлерам
@@ -146,26 +547,53 @@ func (c *Connect) RouterFunc() chi.Router {
 	// Создаем chi роутер
 	c.Router = chi.NewRouter()
 	// Добавляем все функции middleware
-	c.Router.Use(compress.CompressHandle)
+	c.Router.Use(logger.RequestID)
+	c.Router.Use(c.auditSource)
+	c.Router.Use(tracing.Middleware)
+	if c.AccessLog != nil {
+		c.Router.Use(c.AccessLog.Handler)
+	}
+	c.Router.Use(c.Compressor.Handler)
 	c.Router.Use(logger.RequestLogger)
+	c.Router.Use(c.authenticate)
+
+	// Неизвестные маршруты и методы тоже отвечают описанием ошибки
+	c.Router.NotFound(notFoundHandler)
+	c.Router.MethodNotAllowed(methodNotAllowedHandler)
 
-	// Делаем маршрутизацию
-	c.Router.Route("/", func(r chi.Router) {
-		r.Post("/", c.ShortenHandler) // POST запрос отправляем на сокращение ссылки
+	// Делаем маршрутизацию, все маршруты монтируются под путь из базового адреса коротких ссылок
+	c.Router.Route(path.Join("/", c.Config.GetConfig().BasePath), func(r chi.Router) {
+		r.NotFound(notFoundHandler)
+		r.MethodNotAllowed(methodNotAllowedHandler)
+		r.With(requireScope(apikey.ScopeShorten), c.rateLimit(c.ShortenLimiter)).Post("/", c.ShortenHandler) // POST запрос отправляем на сокращение ссылки
 		r.Route("/{id}", func(r chi.Router) {
+			r.Use(c.rateLimit(c.ExpandLimiter))
 			r.Get("/", c.ExpandHandler) // GET запрос с id направляем на извлечение ссылки
+			r.Get("/qr", c.QRHandler)   // GET запрос возвращает QR код короткой ссылки
 		})
-		r.Route("/api/shorten", func(r chi.Router) {
-			r.Post("/", c.ShortenJSONHandler) // GET запрос с id направляем на извлечение ссылки
+		r.Route("/api/v1", func(r chi.Router) { // версионированное api
+			r.Use(markV1)
+			c.apiRoutes(r)
 		})
+		r.Route("/api", c.apiRoutes)                   // прежние маршруты api как псевдонимы версии v1
+		r.Get("/swagger", c.SwaggerHandler)            // GET запрос возвращает Swagger UI
+		r.Get("/swagger/{file}", c.SwaggerFileHandler) // GET запрос возвращает скрипты и стили Swagger UI
+		r.Get("/ui", c.UIHandler)                      // GET запрос возвращает страницу веб-интерфейса
+		r.Get("/ui/{file}", c.UIFileHandler)           // GET запрос возвращает скрипты и стили веб-интерфейса
 	})
 	logger.Log.Debug("Server is running", zap.String("server address", c.Config.GetConfig().ServerAddress))
 	return c.Router
 }
 
-// Функция запуска сервера
-func (c *Connect) StartServer() {
-	if err := http.ListenAndServe(c.Config.GetConfig().ServerAddress, c.RouterFunc()); err != nil {
+// Функция запуска сервера. После отмены ctx сервер перестает принимать соединения, дожидается завершения текущих
+// запросов и возвращает управление
+func (c *Connect) StartServer(ctx context.Context) {
+	server := &http.Server{Addr: c.Config.GetConfig().ServerAddress, Handler: c.RouterFunc()}
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		logger.Log.Fatal(err.Error(), zap.String("server address", c.Config.GetConfig().ServerAddress))
 	}
 }
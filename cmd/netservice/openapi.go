@@ -0,0 +1,98 @@
+// @title        Shortener API
+// @version      1.0
+// @description  Сервис сокращения ссылок. Пользователь определяется по подписанной cookie user_id или по ключу api
+// @description  в заголовке Authorization: Bearer, административные маршруты требуют токена администратора.
+// @BasePath     /
+
+// @securityDefinitions.apikey  BearerAuth
+// @in                          header
+// @name                        Authorization
+// @description                 Ключ api или токен администратора в виде Bearer <token>
+
+package netservice
+
+import (
+	_ "embed"
+	"html/template"
+	"io/fs"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	swaggerui "github.com/swaggo/files/v2"
+	"go.uber.org/zap"
+
+	"github.com/h1067675/shortUrl/internal/logger"
+)
+
+// Спецификация генерируется из аннотаций хандлеров командой go generate ./cmd/netservice, версия swag
+// закреплена в go.mod через tools.go
+//go:generate go run github.com/swaggo/swag/v2/cmd/swag init --v3.1 -g openapi.go -d ./,../storage,../../internal/audit -o docs --ot json
+
+//go:embed docs/swagger.json
+var openAPISpec []byte
+
+// Страница Swagger UI, пути к файлам и спецификации строятся от пути базового адреса
+var swaggerPage = template.Must(template.ParseFS(webFiles, "web/swagger.html"))
+
+// swaggerFile - встроенный файл Swagger UI: файловая система и путь к файлу в ней
+type swaggerFile struct {
+	fsys fs.FS
+	name string
+}
+
+// Файлы Swagger UI, которые отдаются по пути /swagger/{file}. Сборка swagger-ui-dist встроена в бинарный файл
+// через модуль github.com/swaggo/files/v2, поэтому страница работает без доступа к внешним сайтам
+var swaggerFiles = map[string]swaggerFile{
+	"swagger-ui.css":       {swaggerui.FS, "swagger-ui.css"},
+	"swagger-ui-bundle.js": {swaggerui.FS, "swagger-ui-bundle.js"},
+	"swagger.js":           {webFiles, "web/swagger.js"},
+}
+
+// OpenAPIHandler - хандлер возвращает спецификацию OpenAPI http api сервиса
+//
+// @Summary      Спецификация OpenAPI
+// @Tags         docs
+// @Produce      json
+// @Success      200  {object}  object
+// @Router       /api/v1/openapi.json [get]
+// @Router       /api/openapi.json [get]
+func (c *Connect) OpenAPIHandler(responce http.ResponseWriter, request *http.Request) {
+	responce.Header().Add("Content-Type", "application/json")
+	responce.WriteHeader(http.StatusOK)
+	responce.Write(openAPISpec)
+}
+
+// SwaggerHandler - хандлер возвращает страницу Swagger UI для спецификации сервиса
+//
+// @Summary      Swagger UI
+// @Tags         docs
+// @Produce      html
+// @Success      200  {string}  string  "Страница Swagger UI"
+// @Router       /swagger [get]
+func (c *Connect) SwaggerHandler(responce http.ResponseWriter, request *http.Request) {
+	uiHeaders(responce)
+	responce.Header().Set("Content-Type", "text/html; charset=utf-8")
+	responce.WriteHeader(http.StatusOK)
+	if err := swaggerPage.ExecuteTemplate(responce, "swagger.html", c.uiBase()); err != nil {
+		logger.FromContext(request.Context()).Error("Can't render swagger page", zap.Error(err))
+	}
+}
+
+// SwaggerFileHandler - хандлер возвращает скрипты и стили Swagger UI
+//
+// @Summary      Файлы Swagger UI
+// @Tags         docs
+// @Produce      plain
+// @Param        file  path  string  true  "Имя файла: swagger-ui.css, swagger-ui-bundle.js, swagger.js"
+// @Success      200  {string}  string  "Содержимое файла"
+// @Failure      404  {object}  Problem  "Файл не найден"
+// @Router       /swagger/{file} [get]
+func (c *Connect) SwaggerFileHandler(responce http.ResponseWriter, request *http.Request) {
+	f, ok := swaggerFiles[chi.URLParam(request, "file")]
+	if !ok {
+		writeProblem(responce, request, ErrRouteNotFound)
+		return
+	}
+	uiHeaders(responce)
+	http.ServeFileFS(responce, request, f.fsys, f.name)
+}
@@ -0,0 +1,161 @@
+package netservice
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test_openAPIRoutes - спецификация должна описывать ровно те маршруты, которые зарегистрированы в роутере,
+// при расхождении нужно обновить аннотации хандлеров и выполнить go generate ./cmd/netservice
+func Test_openAPIRoutes(t *testing.T) {
+	var spec struct {
+		OpenAPI string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	require.NoError(t, json.Unmarshal(openAPISpec, &spec))
+	assert.True(t, strings.HasPrefix(spec.OpenAPI, "3."))
+	var documented []string
+	for p, methods := range spec.Paths {
+		for m := range methods {
+			documented = append(documented, strings.ToUpper(m)+" "+p)
+		}
+	}
+
+	_, router := newTestRouter(t, nil, func(c *Connect) { c.Storage = &TestStorage{} })
+	var registered []string
+	err := chi.Walk(router.(chi.Routes), func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		if route != "/" {
+			route = strings.TrimSuffix(route, "/")
+		}
+		registered = append(registered, method+" "+route)
+		return nil
+	})
+	require.NoError(t, err)
+
+	sort.Strings(documented)
+	sort.Strings(registered)
+	assert.Equal(t, registered, documented)
+}
+
+// Структура операции спецификации для сравнения с аннотациями: параметры в виде "место:имя", тело запроса
+// в виде "body" и коды ответов
+type openAPIOperation struct {
+	Params []string
+	Codes  []string
+}
+
+// Функция собирает операции из спецификации
+func specOperations(t *testing.T) map[string]openAPIOperation {
+	var spec struct {
+		Paths map[string]map[string]struct {
+			Parameters []struct {
+				Name string `json:"name"`
+				In   string `json:"in"`
+			} `json:"parameters"`
+			RequestBody json.RawMessage            `json:"requestBody"`
+			Responses   map[string]json.RawMessage `json:"responses"`
+		} `json:"paths"`
+	}
+	require.NoError(t, json.Unmarshal(openAPISpec, &spec))
+	r := map[string]openAPIOperation{}
+	for p, methods := range spec.Paths {
+		for m, op := range methods {
+			var o openAPIOperation
+			for _, param := range op.Parameters {
+				o.Params = append(o.Params, param.In+":"+param.Name)
+			}
+			if op.RequestBody != nil {
+				o.Params = append(o.Params, "body")
+			}
+			for code := range op.Responses {
+				o.Codes = append(o.Codes, code)
+			}
+			sort.Strings(o.Params)
+			sort.Strings(o.Codes)
+			r[strings.ToUpper(m)+" "+p] = o
+		}
+	}
+	return r
+}
+
+// Функция собирает операции из аннотаций хандлеров в исходных файлах пакета
+func annotatedOperations(t *testing.T) map[string]openAPIOperation {
+	pkgs, err := parser.ParseDir(token.NewFileSet(), ".", func(fi fs.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, parser.ParseComments)
+	require.NoError(t, err)
+	r := map[string]openAPIOperation{}
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Files {
+			for _, decl := range file.Decls {
+				fn, ok := decl.(*ast.FuncDecl)
+				if !ok || fn.Doc == nil {
+					continue
+				}
+				var o openAPIOperation
+				var routes []string
+				for _, c := range fn.Doc.List {
+					fields := strings.Fields(strings.TrimPrefix(c.Text, "//"))
+					if len(fields) < 2 {
+						continue
+					}
+					switch fields[0] {
+					case "@Param":
+						if fields[2] == "body" {
+							o.Params = append(o.Params, "body")
+						} else {
+							o.Params = append(o.Params, fields[2]+":"+fields[1])
+						}
+					case "@Success", "@Failure":
+						o.Codes = append(o.Codes, fields[1])
+					case "@Router":
+						routes = append(routes, strings.ToUpper(strings.Trim(fields[2], "[]"))+" "+fields[1])
+					}
+				}
+				sort.Strings(o.Params)
+				sort.Strings(o.Codes)
+				for _, route := range routes {
+					r[route] = o
+				}
+			}
+		}
+	}
+	return r
+}
+
+// Test_openAPIOperations - параметры и коды ответов в спецификации должны совпадать с аннотациями хандлеров,
+// а параметры пути - с параметрами маршрута, иначе спецификация устарела и ее нужно сгенерировать заново
+func Test_openAPIOperations(t *testing.T) {
+	spec := specOperations(t)
+	annotated := annotatedOperations(t)
+	require.NotEmpty(t, annotated)
+	assert.Equal(t, annotated, spec)
+
+	placeholder := regexp.MustCompile(`\{([^}]+)\}`)
+	for route, op := range spec {
+		var want []string
+		for _, m := range placeholder.FindAllStringSubmatch(route, -1) {
+			want = append(want, "path:"+m[1])
+		}
+		var got []string
+		for _, p := range op.Params {
+			if strings.HasPrefix(p, "path:") {
+				got = append(got, p)
+			}
+		}
+		sort.Strings(want)
+		assert.Equal(t, want, got, route)
+	}
+}
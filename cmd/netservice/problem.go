@@ -0,0 +1,146 @@
+package netservice
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/h1067675/shortUrl/cmd/storage"
+	"github.com/h1067675/shortUrl/internal/apikey"
+	"github.com/h1067675/shortUrl/internal/compress"
+	"github.com/h1067675/shortUrl/internal/logger"
+	"github.com/h1067675/shortUrl/internal/policy"
+	"github.com/h1067675/shortUrl/internal/qr"
+	"github.com/h1067675/shortUrl/internal/urlnorm"
+)
+
+// Стабильные коды ошибок api, на них могут опираться клиенты
+const (
+	CodeInvalidRequest   = "invalid_request"
+	CodeInvalidURL       = "invalid_url"
+	CodeInvalidDomain    = "invalid_domain"
+	CodeUnauthorized     = "unauthorized"
+	CodeForbidden        = "forbidden"
+	CodeBlocked          = "blocked"
+	CodeNotFound         = "not_found"
+	CodeGone             = "gone"
+	CodeConflict         = "conflict"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeRateLimited      = "rate_limited"
+	CodeTooLarge         = "payload_too_large"
+	CodeUnsupported      = "unsupported_media_type"
+	CodeInternal         = "internal"
+)
+
+// Тип содержимого ответа с описанием ошибки по RFC 7807
+const problemContentType = "application/problem+json"
+
+// APIError - ошибка api с http статусом и стабильным кодом
+type APIError struct {
+	Status int
+	Code   string
+	Detail string
+}
+
+// Error - реализует интерфейс error
+func (e *APIError) Error() string {
+	return e.Detail
+}
+
+// Ошибки api
+var (
+	ErrInvalidRequest   = &APIError{Status: http.StatusBadRequest, Code: CodeInvalidRequest, Detail: "request is malformed"}
+	ErrInvalidURL       = &APIError{Status: http.StatusBadRequest, Code: CodeInvalidURL, Detail: "url is empty or incorrect"}
+	ErrUnknownDomain    = &APIError{Status: http.StatusBadRequest, Code: CodeInvalidDomain, Detail: "domain is not registered"}
+	ErrUnknownTenant    = &APIError{Status: http.StatusBadRequest, Code: CodeInvalidRequest, Detail: "tenant is not registered"}
+	ErrUnauthorized     = &APIError{Status: http.StatusUnauthorized, Code: CodeUnauthorized, Detail: "credentials are missing or invalid"}
+	ErrForeignDomain    = &APIError{Status: http.StatusForbidden, Code: CodeForbidden, Detail: "domain belongs to another tenant"}
+	ErrAdminOnly        = &APIError{Status: http.StatusForbidden, Code: CodeForbidden, Detail: "admin token required"}
+	ErrRouteNotFound    = &APIError{Status: http.StatusNotFound, Code: CodeNotFound, Detail: "route not found"}
+	ErrMethodNotAllowed = &APIError{Status: http.StatusMethodNotAllowed, Code: CodeMethodNotAllowed, Detail: "method not allowed"}
+	ErrRateLimited      = &APIError{Status: http.StatusTooManyRequests, Code: CodeRateLimited, Detail: "too many requests, retry later"}
+)
+
+// ErrScope - ошибка ключа api без нужной области действия
+func ErrScope(scope string) *APIError {
+	return &APIError{Status: http.StatusForbidden, Code: CodeForbidden, Detail: "no " + scope + " scope"}
+}
+
+// bodyError - возвращает ошибку чтения тела запроса для ответа: превышение ограничения размера тела передается
+// как есть, остальные ошибки означают некорректный запрос
+func bodyError(err error) error {
+	if errors.Is(err, compress.ErrTooLarge) {
+		return err
+	}
+	return ErrInvalidRequest
+}
+
+// Problem - тело ответа с описанием ошибки по RFC 7807, ShortURL заполняется при конфликте
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Code     string `json:"code"`
+	ShortURL string `json:"short_url,omitempty"`
+}
+
+// ProblemFor - общий для всех хандлеров преобразователь ошибок хранилища и api в описание ошибки
+func ProblemFor(err error) Problem {
+	var apiErr *APIError
+	var conflict *storage.ConflictError
+	var blocked *storage.BlockedError
+	switch {
+	case errors.As(err, &apiErr):
+		return newProblem(apiErr.Status, apiErr.Code, apiErr.Detail)
+	case errors.Is(err, urlnorm.ErrInvalidURL):
+		return newProblem(http.StatusBadRequest, CodeInvalidURL, err.Error())
+	case errors.As(err, &conflict):
+		p := newProblem(http.StatusConflict, CodeConflict, "url is already shortened")
+		p.ShortURL = conflict.ShortURL
+		return p
+	case errors.Is(err, compress.ErrTooLarge):
+		return newProblem(http.StatusRequestEntityTooLarge, CodeTooLarge, err.Error())
+	case errors.Is(err, compress.ErrUnsupportedEncoding):
+		return newProblem(http.StatusUnsupportedMediaType, CodeUnsupported, err.Error())
+	case errors.Is(err, compress.ErrMalformed):
+		return newProblem(http.StatusBadRequest, CodeInvalidRequest, err.Error())
+	case errors.Is(err, qr.ErrInvalidOptions):
+		return newProblem(http.StatusBadRequest, CodeInvalidRequest, err.Error())
+	case errors.Is(err, apikey.ErrUnknownScope):
+		return newProblem(http.StatusBadRequest, CodeInvalidRequest, err.Error())
+	case errors.Is(err, apikey.ErrNotFound):
+		return newProblem(http.StatusNotFound, CodeNotFound, err.Error())
+	case errors.Is(err, policy.ErrBlocked):
+		return newProblem(http.StatusForbidden, CodeBlocked, err.Error())
+	case errors.As(err, &blocked):
+		return newProblem(http.StatusForbidden, CodeBlocked, err.Error())
+	case errors.Is(err, storage.ErrNotFound), errors.Is(err, ErrHostNotAccepted):
+		return newProblem(http.StatusNotFound, CodeNotFound, storage.ErrNotFound.Error())
+	case errors.Is(err, storage.ErrDeleted):
+		return newProblem(http.StatusGone, CodeGone, err.Error())
+	default:
+		return newProblem(http.StatusInternalServerError, CodeInternal, "internal error")
+	}
+}
+
+// Функция создает описание ошибки, тип ошибки строится из ее кода
+func newProblem(status int, code string, detail string) Problem {
+	return Problem{Type: "urn:shortener:problem:" + code, Title: http.StatusText(status), Status: status, Detail: detail, Code: code}
+}
+
+// writeProblem - записывает в ответ описание ошибки в формате application/problem+json
+func writeProblem(responce http.ResponseWriter, request *http.Request, err error) {
+	p := ProblemFor(err)
+	p.Instance = request.URL.Path
+	if p.Status == http.StatusInternalServerError {
+		logger.FromContext(request.Context()).Error("Internal error", zap.Error(err))
+	}
+	body, _ := json.Marshal(p)
+	responce.Header().Set("Content-Type", problemContentType)
+	responce.WriteHeader(p.Status)
+	responce.Write(body)
+}
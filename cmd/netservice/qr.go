@@ -0,0 +1,67 @@
+package netservice
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/h1067675/shortUrl/internal/qr"
+)
+
+// Функция разбирает параметры изображения QR кода из параметров запроса, отсутствующие параметры берутся по умолчанию
+func qrOptions(request *http.Request) (qr.Options, error) {
+	q := request.URL.Query()
+	o := qr.DefaultOptions()
+	if v := q.Get("format"); v != "" {
+		o.Format = strings.ToLower(v)
+	}
+	if v := q.Get("level"); v != "" {
+		o.Level = v
+	}
+	for name, p := range map[string]*int{"size": &o.Size, "margin": &o.Margin} {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.Atoi(strings.TrimSpace(v))
+			if err != nil {
+				return o, &APIError{Status: http.StatusBadRequest, Code: CodeInvalidRequest, Detail: name + " must be an integer"}
+			}
+			*p = n
+		}
+	}
+	return o, nil
+}
+
+// QRHandler - хандлер возвращает изображение QR кода полной короткой ссылки в формате PNG или SVG. Ссылка ищется
+// так же, как при переходе по ней, но переход не учитывается. Размер изображения задается в пикселях, поле вокруг
+// кода - в модулях
+//
+// @Summary      QR код короткой ссылки
+// @Tags         expand
+// @Produce      image/png,image/svg+xml
+// @Param        id      path   string  true   "Код короткой ссылки"
+// @Param        format  query  string  false  "Формат изображения: png или svg, по умолчанию png"
+// @Param        size    query  int     false  "Ширина и высота изображения в пикселях, по умолчанию 256, не больше 2048"
+// @Param        margin  query  int     false  "Ширина поля вокруг кода в модулях, по умолчанию 4, не больше 16"
+// @Param        level   query  string  false  "Уровень коррекции ошибок: L, M, Q или H, по умолчанию M"
+// @Success      200  {string}  string   "Изображение QR кода"
+// @Failure      400  {object}  Problem  "Некорректные параметры изображения"
+// @Failure      403  {object}  Problem  "Ссылка заблокирована"
+// @Failure      404  {object}  Problem  "Ссылка не найдена"
+// @Failure      410  {object}  Problem  "Ссылка удалена"
+// @Failure      429  {object}  Problem  "Превышено ограничение частоты запросов"
+// @Router       /{id}/qr [get]
+func (c *Connect) QRHandler(responce http.ResponseWriter, request *http.Request) {
+	o, err := qrOptions(request)
+	if err != nil {
+		writeProblem(responce, request, err)
+		return
+	}
+	img, err := c.LinkQR(request.Context(), request.Host, strings.TrimSuffix(request.URL.Path, "/qr"), o)
+	if err != nil {
+		writeProblem(responce, request, err)
+		return
+	}
+	responce.Header().Set("Content-Type", img.ContentType)
+	responce.Header().Set("X-Content-Type-Options", "nosniff")
+	responce.WriteHeader(http.StatusOK)
+	responce.Write(img.Data)
+}
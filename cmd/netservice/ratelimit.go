@@ -0,0 +1,62 @@
+package netservice
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/h1067675/shortUrl/internal/auth"
+	"github.com/h1067675/shortUrl/internal/logger"
+	"github.com/h1067675/shortUrl/internal/ratelimit"
+)
+
+// ClientKeys - возвращает ключи клиента для ограничения частоты запросов, общие для http и gRPC. Адрес клиента ip
+// ограничивается всегда, чтобы новые cookie и случайные ключи не давали новых корзин. Дополнительно ограничивается
+// арендатор по ключу tenantKey, ключ api или пользователь с действительным токеном из контекста. Только что выданный
+// идентификатор не годится, потому что клиент без cookie получает новый идентификатор на каждый запрос
+func (c *Connect) ClientKeys(ctx context.Context, ip string, tenantKey string) []string {
+	keys := []string{"ip:" + ip}
+	if tenantKey != "" {
+		if t, ok := c.tenantByKey(tenantKey); ok {
+			return append(keys, "tenant:"+t.Name)
+		}
+	}
+	if id, ok := auth.FromContext(ctx); ok && id.KeyID != "" {
+		keys = append(keys, "apikey:"+id.KeyID)
+	} else if ok && !id.Issued {
+		keys = append(keys, "user:"+id.UserID)
+	}
+	return keys
+}
+
+// clientKeys - возвращает ключи клиента http запроса, адрес клиента за доверенными прокси берется из заголовков
+func (c *Connect) clientKeys(request *http.Request) []string {
+	return c.ClientKeys(request.Context(), logger.ClientIP(request, c.TrustedProxies), request.Header.Get("X-API-Key"))
+}
+
+// RetryAfter - возвращает значение Retry-After в целых секундах, округленных вверх
+func RetryAfter(wait time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(wait.Seconds())))
+}
+
+// rateLimit - middleware ограничения частоты запросов клиента. На превышение ограничения возвращает Too many requests
+// с заголовком Retry-After и без новой cookie пользователя, пустой ограничитель пропускает все запросы
+func (c *Connect) rateLimit(l *ratelimit.Limiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if l == nil {
+			return next
+		}
+		return http.HandlerFunc(func(responce http.ResponseWriter, request *http.Request) {
+			ok, wait := l.Allow(c.clientKeys(request)...)
+			if !ok {
+				responce.Header().Del("Set-Cookie")
+				responce.Header().Set("Retry-After", RetryAfter(wait))
+				writeProblem(responce, request, ErrRateLimited)
+				return
+			}
+			next.ServeHTTP(responce, request)
+		})
+	}
+}
@@ -0,0 +1,419 @@
+package netservice
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/h1067675/shortUrl/cmd/storage"
+	"github.com/h1067675/shortUrl/internal/apikey"
+	"github.com/h1067675/shortUrl/internal/audit"
+	"github.com/h1067675/shortUrl/internal/auth"
+	"github.com/h1067675/shortUrl/internal/qr"
+	"github.com/h1067675/shortUrl/internal/tenant"
+	"github.com/h1067675/shortUrl/internal/tracing"
+)
+
+// ErrHostNotAccepted - запрос на раскрытие ссылки пришел на хост, не входящий в список разрешенных
+var ErrHostNotAccepted = errors.New("host not accepted")
+
+// Структура элемента пакетного запроса на сокращение
+type BatchRequest struct {
+	CorrelationID string `json:"correlation_id"`
+	OriginalURL   string `json:"original_url"`
+}
+
+// Структура элемента ответа на пакетный запрос
+type BatchResponce struct {
+	CorrelationID string `json:"correlation_id"`
+	ShortURL      string `json:"short_url"`
+}
+
+// Структура ответа со статистикой сервиса
+type StatsResponce struct {
+	URLs  int `json:"urls"`
+	Users int `json:"users"`
+}
+
+// userID - возвращает идентификатор пользователя запроса, если пользователь не определен, то пустую строку
+func userID(request *http.Request) string {
+	id, _ := auth.FromContext(request.Context())
+	return id.UserID
+}
+
+// tenantByKey - возвращает арендатора, к которому привязан действующий ключ api
+func (c *Connect) tenantByKey(key string) (*tenant.Tenant, bool) {
+	if c.Tenants == nil {
+		return nil, false
+	}
+	k, err := c.Keys.Verify(key)
+	if err != nil || k.Tenant == "" {
+		return nil, false
+	}
+	return c.Tenants.ByName(k.Tenant)
+}
+
+// TenantAddress - возвращает базовый адрес коротких ссылок для запроса на сокращение. Домен арендатора выбирается
+// по полю запроса или по ключу арендатора, без них используется базовый адрес из настроек
+func (c *Connect) TenantAddress(key string, domain string) (string, error) {
+	conf := c.Config.GetConfig()
+	if key == "" && domain == "" {
+		return conf.OuterAddress, nil
+	}
+	if c.Tenants == nil {
+		return "", ErrUnknownDomain
+	}
+	if key != "" {
+		t, ok := c.tenantByKey(key)
+		if !ok {
+			return "", ErrUnauthorized
+		}
+		if domain == "" {
+			domain = t.Domains[0]
+		} else if !t.HasDomain(domain) {
+			return "", ErrForeignDomain
+		}
+	} else {
+		t, ok := c.Tenants.ByDomain(domain)
+		if !ok {
+			return "", ErrUnknownDomain
+		}
+		// домены закрытого арендатора доступны только по ключу, отзыв ключей их не открывает
+		if t.Private {
+			return "", ErrForeignDomain
+		}
+	}
+	return domainAddress(conf.OuterAddress, domain, conf.BasePath), nil
+}
+
+// domainAddress - собирает базовый адрес домена арендатора со схемой и путем из базового адреса настроек
+func domainAddress(outer string, domain string, basePath string) string {
+	scheme := "http"
+	if i := strings.Index(outer, "://"); i > 0 {
+		scheme = outer[:i]
+	}
+	if h, _, err := net.SplitHostPort(domain); err == nil {
+		domain = h
+	}
+	return scheme + "://" + strings.ToLower(domain) + basePath
+}
+
+// acceptedHost - проверяет, что имя хоста из запроса входит в список разрешенных, пустой список разрешает любой хост
+func acceptedHost(host string, hosts []string) bool {
+	if len(hosts) == 0 {
+		return true
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for _, e := range hosts {
+		if strings.EqualFold(host, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve - возвращает короткую ссылку и ее исходную ссылку по хосту и пути короткой ссылки. Если хост принадлежит
+// арендатору, то код ищется в пространстве имен его домена, иначе хост должен входить в список разрешенных и код
+// ищется по базовому адресу
+func (c *Connect) resolve(ctx context.Context, host string, urlPath string) (string, string, error) {
+	conf := c.Config.GetConfig()
+	adr := conf.OuterAddress
+	if c.Tenants != nil {
+		if _, ok := c.Tenants.ByDomain(host); ok {
+			adr = domainAddress(conf.OuterAddress, host, conf.BasePath)
+		}
+	}
+	if adr == conf.OuterAddress && !acceptedHost(host, conf.AcceptedHosts) {
+		return "", "", ErrHostNotAccepted
+	}
+	short := adr + strings.TrimPrefix(urlPath, conf.BasePath)
+	_, st := tracing.Start(ctx, "storage.GetURL")
+	defer st.End()
+	url, err := c.Storage.GetURL(short)
+	return short, url, err
+}
+
+// ExpandURL - возвращает исходную ссылку по хосту и пути короткой ссылки и учитывает раскрытие в счетчике ссылки
+func (c *Connect) ExpandURL(ctx context.Context, host string, urlPath string) (string, error) {
+	ctx, span := tracing.Start(ctx, "ExpandURL")
+	defer span.End()
+	short, url, err := c.resolve(ctx, host, urlPath)
+	if err != nil {
+		return "", err
+	}
+	_, st := tracing.Start(ctx, "storage.CountClick")
+	c.Storage.CountClick(short)
+	st.End()
+	return url, nil
+}
+
+// LinkQR - возвращает изображение QR кода полной короткой ссылки по хосту и пути короткой ссылки. Код строится только
+// для ссылки, которую можно раскрыть, раскрытие при этом не учитывается
+func (c *Connect) LinkQR(ctx context.Context, host string, urlPath string, o qr.Options) (qr.Image, error) {
+	ctx, span := tracing.Start(ctx, "LinkQR")
+	defer span.End()
+	short, _, err := c.resolve(ctx, host, urlPath)
+	if err != nil {
+		return qr.Image{}, err
+	}
+	return qr.Encode(short, o)
+}
+
+// Shorten - проверяет и нормализует ссылку, сокращает ее в пространстве имен базового адреса от имени пользователя
+// и сохраняет хранилище в файл. Если ссылка уже была сокращена, то возвращает существующую короткую ссылку вместе
+// с ошибкой storage.ConflictError
+func (c *Connect) Shorten(ctx context.Context, url string, adr string, userID string) (string, error) {
+	ctx, span := tracing.Start(ctx, "Shorten")
+	defer span.End()
+	url, err := c.Normalizer.Normalize(url)
+	if err != nil {
+		return "", err
+	}
+	_, st := tracing.Start(ctx, "storage.CreateShortURL")
+	short, err := c.Storage.CreateShortURL(url, adr, userID)
+	st.End()
+	if err != nil {
+		return short, err
+	}
+	c.save(ctx)
+	c.record(ctx, audit.ActionCreate, short, "", url)
+	return short, nil
+}
+
+// ShortenBatch - проверяет и нормализует пакет ссылок, сокращает их от имени пользователя и один раз сохраняет хранилище
+// в файл, для уже сокращенных ссылок возвращаются существующие короткие ссылки. Если хотя бы одна ссылка некорректна
+// или запрещена политикой, то не сокращается ни одна, ошибка содержит correlation_id этой ссылки
+func (c *Connect) ShortenBatch(ctx context.Context, batch []BatchRequest, adr string, userID string) ([]BatchResponce, error) {
+	ctx, span := tracing.Start(ctx, "ShortenBatch", attribute.Int("batch.size", len(batch)))
+	defer span.End()
+	urls := make([]string, len(batch))
+	for i, e := range batch {
+		url, err := c.Normalizer.Normalize(e.OriginalURL)
+		if err != nil {
+			return nil, fmt.Errorf("correlation_id %s: %w", e.CorrelationID, err)
+		}
+		// политика проверяется до сокращения первой ссылки, иначе ссылки перед запрещенной остались бы в хранилище
+		if err := c.Storage.CheckURL(url); err != nil {
+			return nil, fmt.Errorf("correlation_id %s: %w", e.CorrelationID, err)
+		}
+		urls[i] = url
+	}
+	var conflict *storage.ConflictError
+	result := make([]BatchResponce, 0, len(batch))
+	created := make([]bool, len(batch))
+	_, st := tracing.Start(ctx, "storage.CreateShortURL", attribute.Int("batch.size", len(batch)))
+	for i, e := range batch {
+		short, err := c.Storage.CreateShortURL(urls[i], adr, userID)
+		if err != nil && !errors.As(err, &conflict) {
+			st.End()
+			return nil, err
+		}
+		created[i] = err == nil
+		result = append(result, BatchResponce{CorrelationID: e.CorrelationID, ShortURL: short})
+	}
+	st.End()
+	c.save(ctx)
+	for i, e := range result {
+		if created[i] {
+			c.record(ctx, audit.ActionCreate, e.ShortURL, "", urls[i])
+		}
+	}
+	return result, nil
+}
+
+// IdentifyBearer - возвращает пользователя по токену из заголовка Authorization: Bearer. Токен администратора
+// из настроек дает права администратора, ключ api дает права пользователя ключа в пределах его областей действия,
+// а ключ с областью admin еще и права администратора
+func (c *Connect) IdentifyBearer(token string) (auth.Identity, error) {
+	if c.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(c.AdminToken)) == 1 {
+		return auth.Identity{UserID: "admin", Admin: true}, nil
+	}
+	k, err := c.Keys.Verify(token)
+	if err != nil {
+		return auth.Identity{}, ErrUnauthorized
+	}
+	return auth.Identity{UserID: k.UserID, KeyID: k.ID, Scopes: k.Scopes, Admin: k.HasScope(apikey.ScopeAdmin)}, nil
+}
+
+// UserURLs - возвращает ссылки пользователя
+func (c *Connect) UserURLs(ctx context.Context, userID string) []storage.StorageJSON {
+	_, span := tracing.Start(ctx, "storage.GetUserURLs")
+	defer span.End()
+	return c.Storage.GetUserURLs(userID)
+}
+
+// DeleteUserURLs - удаляет ссылки пользователя по кодам и сохраняет хранилище в файл, если что-то было удалено.
+// Возвращает количество удаленных ссылок
+func (c *Connect) DeleteUserURLs(ctx context.Context, userID string, codes []string) int {
+	ctx, span := tracing.Start(ctx, "DeleteUserURLs")
+	defer span.End()
+	_, st := tracing.Start(ctx, "storage.DeleteUserURLs", attribute.Int("codes", len(codes)))
+	deleted := c.Storage.DeleteUserURLs(userID, codes)
+	st.End()
+	if len(deleted) > 0 {
+		c.save(ctx)
+	}
+	for _, e := range deleted {
+		c.record(ctx, audit.ActionDelete, e.ShortLink, e.OriginalLink, "")
+	}
+	return len(deleted)
+}
+
+// UpdateUserURL - проверяет и нормализует новую исходную ссылку, меняет на нее исходную ссылку у ссылки пользователя
+// с кодом code и сохраняет хранилище в файл. Возвращает короткую ссылку и новую исходную ссылку
+func (c *Connect) UpdateUserURL(ctx context.Context, userID string, code string, url string) (string, string, error) {
+	ctx, span := tracing.Start(ctx, "UpdateUserURL")
+	defer span.End()
+	url, err := c.Normalizer.Normalize(url)
+	if err != nil {
+		return "", "", err
+	}
+	_, st := tracing.Start(ctx, "storage.UpdateURL")
+	short, old, err := c.Storage.UpdateURL(userID, code, url)
+	st.End()
+	if err != nil {
+		return short, "", err
+	}
+	if old != url {
+		c.save(ctx)
+		c.record(ctx, audit.ActionEdit, short, old, url)
+	}
+	return short, url, nil
+}
+
+// URLHistory - возвращает историю исходных ссылок у ссылки пользователя с кодом code
+func (c *Connect) URLHistory(ctx context.Context, userID string, code string) (storage.LinkHistory, error) {
+	_, span := tracing.Start(ctx, "storage.GetURLHistory")
+	defer span.End()
+	return c.Storage.GetURLHistory(userID, code)
+}
+
+// Stats - возвращает статистику сервиса
+func (c *Connect) Stats(ctx context.Context) StatsResponce {
+	_, span := tracing.Start(ctx, "storage.Stats")
+	defer span.End()
+	urls, users := c.Storage.Stats()
+	return StatsResponce{URLs: urls, Users: users}
+}
+
+// BlockURL - блокирует короткую ссылку с указанием причины и сохраняет хранилище в файл
+func (c *Connect) BlockURL(ctx context.Context, short string, reason string) error {
+	ctx, span := tracing.Start(ctx, "BlockURL")
+	defer span.End()
+	prev, err := c.Storage.BlockURL(short, reason)
+	if err != nil {
+		return err
+	}
+	c.save(ctx)
+	c.record(ctx, audit.ActionBlock, short, prev, reason)
+	return nil
+}
+
+// UnblockURL - снимает блокировку с короткой ссылки и сохраняет хранилище в файл
+func (c *Connect) UnblockURL(ctx context.Context, short string) error {
+	ctx, span := tracing.Start(ctx, "UnblockURL")
+	defer span.End()
+	prev, err := c.Storage.UnblockURL(short)
+	if err != nil {
+		return err
+	}
+	c.save(ctx)
+	c.record(ctx, audit.ActionUnblock, short, prev, "")
+	return nil
+}
+
+// ShortURL - возвращает короткую ссылку по ссылке или ее коду, код относится к базовому адресу из настроек
+func (c *Connect) ShortURL(s string) string {
+	if strings.Contains(s, "://") {
+		return s
+	}
+	return c.Config.GetConfig().OuterAddress + "/" + strings.TrimPrefix(s, "/")
+}
+
+// SearchURLs - ищет ссылки по условиям и возвращает страницу найденных ссылок и общее количество найденных
+func (c *Connect) SearchURLs(ctx context.Context, f storage.Filter) ([]storage.StorageJSON, int) {
+	_, span := tracing.Start(ctx, "storage.SearchURLs")
+	defer span.End()
+	return c.Storage.SearchURLs(f)
+}
+
+// ForceDeleteURLs - удаляет ссылки независимо от владельца, сохраняет хранилище в файл, если что-то было удалено,
+// и возвращает количество удаленных ссылок
+func (c *Connect) ForceDeleteURLs(ctx context.Context, shorts []string) int {
+	ctx, span := tracing.Start(ctx, "ForceDeleteURLs")
+	defer span.End()
+	_, st := tracing.Start(ctx, "storage.ForceDeleteURLs", attribute.Int("links", len(shorts)))
+	deleted := c.Storage.ForceDeleteURLs(shorts)
+	st.End()
+	if len(deleted) > 0 {
+		c.save(ctx)
+	}
+	for _, e := range deleted {
+		c.record(ctx, audit.ActionDelete, e.ShortLink, e.OriginalLink, "")
+	}
+	return len(deleted)
+}
+
+// ReassignURLs - передает ссылки пользователю userID, сохраняет хранилище в файл, если что-то было передано,
+// и возвращает количество переданных ссылок
+func (c *Connect) ReassignURLs(ctx context.Context, shorts []string, userID string) int {
+	ctx, span := tracing.Start(ctx, "ReassignURLs")
+	defer span.End()
+	_, st := tracing.Start(ctx, "storage.ReassignURLs", attribute.Int("links", len(shorts)))
+	moved := c.Storage.ReassignURLs(shorts, userID)
+	st.End()
+	if len(moved) > 0 {
+		c.save(ctx)
+	}
+	for _, e := range moved {
+		c.record(ctx, audit.ActionReassign, e.ShortLink, e.UserID, userID)
+	}
+	return len(moved)
+}
+
+// Summary - возвращает статистику хранилища для администратора
+func (c *Connect) Summary(ctx context.Context) storage.Summary {
+	_, span := tracing.Start(ctx, "storage.Summary")
+	defer span.End()
+	return c.Storage.Summary()
+}
+
+// save - сохраняет хранилище в файл из настроек, сохранение видно в трассе отдельным спаном
+func (c *Connect) save(ctx context.Context) {
+	file := c.Config.GetConfig().FileStoragePath
+	_, span := tracing.Start(ctx, "storage.SaveToFile", attribute.String("file", file))
+	defer span.End()
+	c.Storage.SaveToFile(file)
+}
+
+// SaveClicks - сохраняет хранилище в файл из настроек, если после последнего сохранения ссылки раскрывались.
+// Раскрытие не сохраняет хранилище, поэтому несохраненные счетчики раскрытий нужно сохранить при остановке сервиса
+func (c *Connect) SaveClicks(ctx context.Context) {
+	file := c.Config.GetConfig().FileStoragePath
+	_, span := tracing.Start(ctx, "storage.SaveClicks", attribute.String("file", file))
+	defer span.End()
+	c.Storage.SaveClicks(file)
+}
+
+// FlushClicks - раз в interval сохраняет счетчики раскрытий ссылок, пока не закрыт stop
+func (c *Connect) FlushClicks(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			c.SaveClicks(context.Background())
+		}
+	}
+}
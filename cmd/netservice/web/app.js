@@ -0,0 +1,130 @@
+'use strict';
+
+// Веб-интерфейс работает с api сервиса от имени пользователя из cookie, которую сервис выдает при первом запросе
+(function () {
+  const app = document.getElementById('app');
+  const api = app.dataset.base + 'api/v1/';
+  const form = document.getElementById('shorten');
+  const input = document.getElementById('url');
+  const message = document.getElementById('message');
+  const table = document.getElementById('links');
+  const empty = document.getElementById('empty');
+
+  function show(text, isError) {
+    message.textContent = text;
+    message.className = isError ? 'error' : '';
+  }
+
+  // описание ошибки в формате problem+json превращается в текст для пользователя
+  async function problem(responce) {
+    try {
+      const p = await responce.json();
+      return p.detail || p.title || responce.statusText;
+    } catch (e) {
+      return responce.statusText;
+    }
+  }
+
+  function cell(row, className, content) {
+    const td = row.insertCell();
+    td.className = className;
+    if (content instanceof Node) {
+      td.appendChild(content);
+    } else {
+      td.textContent = content;
+    }
+    return td;
+  }
+
+  function link(href) {
+    const a = document.createElement('a');
+    a.textContent = href;
+    // ссылками делаются только адреса http и https
+    if (/^https?:\/\//i.test(href)) {
+      a.href = href;
+      a.target = '_blank';
+      a.rel = 'noopener noreferrer';
+    }
+    return a;
+  }
+
+  function button(text, onClick) {
+    const b = document.createElement('button');
+    b.type = 'button';
+    b.textContent = text;
+    b.addEventListener('click', onClick);
+    return b;
+  }
+
+  async function copy(text) {
+    try {
+      await navigator.clipboard.writeText(text);
+      show('Скопировано: ' + text, false);
+    } catch (e) {
+      show('Не удалось скопировать ссылку', true);
+    }
+  }
+
+  async function remove(short) {
+    if (!confirm('Удалить ссылку ' + short + '?')) {
+      return;
+    }
+    const code = short.substring(short.lastIndexOf('/') + 1);
+    const responce = await fetch(api + 'user/urls', {
+      method: 'DELETE',
+      headers: {'Content-Type': 'application/json'},
+      body: JSON.stringify([code]),
+    });
+    if (!responce.ok) {
+      show(await problem(responce), true);
+      return;
+    }
+    show('Ссылка удалена', false);
+    await load();
+  }
+
+  async function load() {
+    const responce = await fetch(api + 'user/urls');
+    let links = [];
+    if (responce.status === 200) {
+      links = await responce.json();
+    } else if (responce.status !== 204 && responce.status !== 401) {
+      show(await problem(responce), true);
+    }
+    const body = table.tBodies[0];
+    body.replaceChildren();
+    for (const l of links) {
+      const row = body.insertRow();
+      cell(row, 'short', link(l.short_url));
+      cell(row, 'original', link(l.original_url));
+      cell(row, 'clicks', String(l.clicks || 0));
+      const actions = cell(row, 'actions', button('Копировать', () => copy(l.short_url)));
+      actions.appendChild(button('Удалить', () => remove(l.short_url)));
+    }
+    table.hidden = links.length === 0;
+    empty.hidden = links.length !== 0;
+  }
+
+  form.addEventListener('submit', async (event) => {
+    event.preventDefault();
+    const responce = await fetch(api + 'shorten', {
+      method: 'POST',
+      headers: {'Content-Type': 'application/json'},
+      body: JSON.stringify({url: input.value}),
+    });
+    if (responce.status === 201) {
+      const r = await responce.json();
+      show('Короткая ссылка: ' + r.result, false);
+      input.value = '';
+    } else if (responce.status === 409) {
+      const p = await responce.json();
+      show('Ссылка уже сокращена: ' + p.short_url, false);
+    } else {
+      show(await problem(responce), true);
+      return;
+    }
+    await load();
+  });
+
+  load();
+})();
@@ -0,0 +1,12 @@
+'use strict';
+
+// Swagger UI загружает спецификацию с /api/openapi.json с учетом пути базового адреса. Проверка спецификации
+// внешним валидатором отключена, страница не обращается к другим сайтам
+(function () {
+  const root = document.getElementById('swagger-ui');
+  window.ui = SwaggerUIBundle({
+    url: root.dataset.base + 'api/openapi.json',
+    dom_id: '#swagger-ui',
+    validatorUrl: null,
+  });
+})();
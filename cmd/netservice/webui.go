@@ -0,0 +1,81 @@
+package netservice
+
+import (
+	"embed"
+	"html/template"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+
+	"github.com/h1067675/shortUrl/internal/logger"
+)
+
+// Файлы веб-интерфейса встраиваются в бинарный файл сервиса
+//
+//go:embed web
+var webFiles embed.FS
+
+// Страница веб-интерфейса, пути к файлам и api строятся от пути базового адреса
+var uiPage = template.Must(template.ParseFS(webFiles, "web/index.html"))
+
+// Файлы веб-интерфейса, которые отдаются по пути /ui/{file}, остальные встроенные файлы по этому пути недоступны
+var uiFiles = map[string]bool{"app.js": true, "style.css": true}
+
+// Политика безопасности страниц веб-интерфейса и Swagger UI: скрипты, стили и запросы только к самому сервису, страницу
+// нельзя встроить в чужой сайт
+const uiSecurityPolicy = "default-src 'self'; img-src 'self' data:; frame-ancestors 'none'; base-uri 'none'; form-action 'self'"
+
+// Функция добавляет в ответ заголовки безопасности веб-интерфейса и Swagger UI
+func uiHeaders(responce http.ResponseWriter) {
+	responce.Header().Set("Content-Security-Policy", uiSecurityPolicy)
+	responce.Header().Set("X-Content-Type-Options", "nosniff")
+	responce.Header().Set("X-Frame-Options", "DENY")
+}
+
+// uiBase - возвращает путь базового адреса с завершающей косой чертой, от него строятся пути страниц к файлам и api
+func (c *Connect) uiBase() string {
+	base := path.Join("/", c.Config.GetConfig().BasePath)
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	return base
+}
+
+// UIHandler - хандлер возвращает страницу веб-интерфейса для сокращения ссылок и управления своими ссылками.
+// Пользователь определяется по cookie, как и в api
+//
+// @Summary      Веб-интерфейс
+// @Tags         ui
+// @Produce      html
+// @Success      200  {string}  string  "Страница веб-интерфейса"
+// @Router       /ui [get]
+func (c *Connect) UIHandler(responce http.ResponseWriter, request *http.Request) {
+	uiHeaders(responce)
+	responce.Header().Set("Content-Type", "text/html; charset=utf-8")
+	responce.WriteHeader(http.StatusOK)
+	if err := uiPage.ExecuteTemplate(responce, "index.html", c.uiBase()); err != nil {
+		logger.FromContext(request.Context()).Error("Can't render ui page", zap.Error(err))
+	}
+}
+
+// UIFileHandler - хандлер возвращает скрипты и стили веб-интерфейса
+//
+// @Summary      Файлы веб-интерфейса
+// @Tags         ui
+// @Produce      plain
+// @Param        file  path  string  true  "Имя файла: app.js, style.css"
+// @Success      200  {string}  string  "Содержимое файла"
+// @Failure      404  {object}  Problem  "Файл не найден"
+// @Router       /ui/{file} [get]
+func (c *Connect) UIFileHandler(responce http.ResponseWriter, request *http.Request) {
+	name := chi.URLParam(request, "file")
+	if !uiFiles[name] {
+		writeProblem(responce, request, ErrRouteNotFound)
+		return
+	}
+	uiHeaders(responce)
+	http.ServeFileFS(responce, request, webFiles, path.Join("web", name))
+}
@@ -1,24 +1,162 @@
 package main
 
 import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
 	"github.com/h1067675/shortUrl/cmd/configsurl"
+	"github.com/h1067675/shortUrl/cmd/debugservice"
+	"github.com/h1067675/shortUrl/cmd/grpcservice"
 	"github.com/h1067675/shortUrl/cmd/netservice"
 	"github.com/h1067675/shortUrl/cmd/storage"
+	"github.com/h1067675/shortUrl/internal/audit"
+	"github.com/h1067675/shortUrl/internal/auth"
 	"github.com/h1067675/shortUrl/internal/logger"
+	"github.com/h1067675/shortUrl/internal/policy"
+	"github.com/h1067675/shortUrl/internal/ratelimit"
+	"github.com/h1067675/shortUrl/internal/tenant"
+	"github.com/h1067675/shortUrl/internal/tracing"
+	"github.com/h1067675/shortUrl/internal/urlnorm"
 )
 
 func main() {
 	// Устанавливаем настройки приложения по умолчанию
-	var conf = configsurl.NewConfig("localhost:8080", "localhost:8080", "/storage.json")
+	var conf = configsurl.NewConfig("localhost:8080", "http://localhost:8080", "/storage.json")
 	// Устанавливаем конфигурацию из параметров запуска или из переменных окружения
 	conf.Set()
+	// Инициализируем логгер по настройкам, без логгера продолжать работу нельзя
+	err := logger.Setup(logger.Config{
+		Level:    conf.LogLevel.String(),
+		Encoding: conf.LogEncoding.String(),
+		Outputs:  conf.LogOutputs,
+		Sampling: logger.Sampling{Initial: conf.LogSampling.Initial, Thereafter: conf.LogSampling.Thereafter},
+		Rotate: logger.Rotate{
+			MaxSize:    conf.LogRotate.MaxSizeMB(),
+			MaxAge:     conf.LogRotate.MaxAge,
+			MaxBackups: conf.LogRotate.MaxBackups,
+			Compress:   conf.LogRotate.Compress,
+		},
+		// заголовки, параметры запроса и учетные данные в адресах скрываются во всех записях лога
+		Redact: logger.Redaction{
+			Headers:         conf.LogRedactHeaders,
+			Params:          conf.LogRedactParams,
+			KeepCredentials: !bool(conf.LogStripCredentials),
+		},
+	})
+	if err != nil {
+		log.Fatal("Can't initialize logger: " + err.Error())
+	}
+	defer logger.Log.Sync()
 	// Создаем хранилище данных
 	var storage = storage.NewStorage()
 	storage.RestoreFromfile(conf.FileStoragePath.Path)
 	// Создаем соединение и помещвем в него переменные хранения и конфигурации
 	var conn = netservice.NewConnect(storage, conf)
-	// Инициализируем логгер
-	logger.Initialize("debug")
-	// Запускаем сервер
-	conn.StartServer()
+	// Настраиваем трассировку, при остановке сервиса оставшиеся спаны выгружаются
+	shutdown, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter:    conf.TraceExporter.String(),
+		Endpoint:    conf.TraceEndpoint.String(),
+		ServiceName: "shortener",
+		SampleRatio: float64(conf.TraceSampleRatio),
+	})
+	if err != nil {
+		logger.Log.Fatal("Can't initialize tracing: " + err.Error())
+	}
+	defer shutdown(context.Background())
+	// Токены пользователей подписываются ключом из настроек
+	conn.Auth = auth.New(conf.SecretKey.Key)
+	// Ссылки перед сокращением проверяются и нормализуются, разрешенные схемы берутся из настроек
+	conn.Normalizer = urlnorm.New(conf.AllowedSchemes...)
+	// Загружаем списки запрещенных адресов и перечитываем их при изменении файла
+	var pol = policy.New()
+	if err := pol.LoadFromFile(conf.PolicyFilePath.Path); err != nil {
+		logger.Log.Fatal("Can't load policy: " + err.Error())
+	}
+	go pol.Watch(conf.PolicyFilePath.Path, 10*time.Second, nil)
+	storage.Policy = pol
+	// Административные маршруты доступны только с токеном из настроек
+	conn.AdminToken = conf.AdminToken.Key
+	// Загружаем ключи api, в файле хранятся только их хеши
+	conn.KeysFilePath = conf.KeysFilePath.Path
+	if err := conn.Keys.RestoreFromFile(conn.KeysFilePath); err != nil {
+		logger.Log.Fatal("Can't load api keys: " + err.Error())
+	}
+	// Загружаем реестр арендаторов с их собственными доменами, ключи арендаторов переносятся в хранилище ключей api
+	var tenants = tenant.NewRegistry()
+	if err := tenants.LoadFromFile(conf.TenantsFilePath.Path, conn.Keys); err != nil {
+		logger.Log.Fatal("Can't load tenants: " + err.Error())
+	}
+	conn.Tenants = tenants
+	if err := conn.Keys.SaveToFile(conn.KeysFilePath); err != nil {
+		logger.Log.Fatal("Can't save api keys: " + err.Error())
+	}
+	// Журнал аудита изменяющих операций дописывается в файл и не переписывается
+	audits, err := audit.Open(conf.AuditFilePath.Path)
+	if err != nil {
+		logger.Log.Fatal("Can't open audit log: " + err.Error())
+	}
+	defer audits.Close()
+	conn.Audit = audits
+	// Ограничиваем частоту запросов каждого клиента отдельно для сокращения и раскрытия ссылок
+	if conf.ShortenRateLimit.Count > 0 {
+		conn.ShortenLimiter = ratelimit.New(conf.ShortenRateLimit.PerSecond(), conf.ShortenRateLimit.Burst)
+	}
+	if conf.ExpandRateLimit.Count > 0 {
+		conn.ExpandLimiter = ratelimit.New(conf.ExpandRateLimit.PerSecond(), conf.ExpandRateLimit.Burst)
+	}
+	// Ограничиваем размер тел запросов до и после распаковки и степень их сжатия
+	conn.Compressor.MaxBodySize = int64(conf.MaxBodySize)
+	conn.Compressor.MaxDecodedSize = int64(conf.MaxDecodedSize)
+	conn.Compressor.MaxRatio = int64(conf.MaxCompressionRatio)
+	// Адрес клиента за доверенными прокси берется из заголовков запроса
+	conn.TrustedProxies, err = logger.ParseTrustedProxies(conf.TrustedProxies)
+	if err != nil {
+		logger.Log.Fatal("Can't parse trusted proxies: " + err.Error())
+	}
+	// Журнал доступа пишется в отдельный файл в формате, который понимают средства анализа логов
+	if conf.AccessLogPath.Path != "" {
+		access, err := logger.NewAccessLog(logger.AccessConfig{
+			Output: conf.AccessLogPath.Path,
+			Format: conf.AccessLogFormat.String(),
+			Rotate: logger.Rotate{
+				MaxSize:    conf.AccessLogRotate.MaxSizeMB(),
+				MaxAge:     conf.AccessLogRotate.MaxAge,
+				MaxBackups: conf.AccessLogRotate.MaxBackups,
+				Compress:   conf.AccessLogRotate.Compress,
+			},
+			TrustedProxies: conf.TrustedProxies,
+		})
+		if err != nil {
+			logger.Log.Fatal("Can't open access log: " + err.Error())
+		}
+		defer access.Close()
+		conn.AccessLog = access
+	}
+	// Сервис останавливается по сигналу, счетчики раскрытий сохраняются периодически и еще раз при остановке
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go conn.FlushClicks(10*time.Second, ctx.Done())
+	// Сервер диагностики слушает отдельный адрес, он запускается, только если адрес задан
+	if conf.DebugAddress.Host != "" {
+		go debugservice.NewServer(storage).StartServer(conf.DebugAddress.String())
+	}
+	// gRPC сервер запускается рядом с http сервером, только если адрес задан, они используют общие хранилище
+	// и аутентификацию
+	var servers sync.WaitGroup
+	if conf.NetAddressServerGRPC.Host != "" {
+		servers.Add(1)
+		go func() {
+			defer servers.Done()
+			grpcservice.NewServer(conn).StartServer(ctx, conf.NetAddressServerGRPC.String())
+		}()
+	}
+	// Запускаем сервер, после остановки дожидаемся gRPC сервера, чтобы сохранить все раскрытия
+	conn.StartServer(ctx)
+	servers.Wait()
+	conn.SaveClicks(context.Background())
 }
@@ -1,17 +1,87 @@
 package storage
 
 import (
-	"bufio"
 	"encoding/json"
 	"errors"
+	"io"
 	"math/rand"
 	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-// Структура для лхранения ссылок
+// Ошибки хранилища
+var (
+	ErrNotFound = errors.New("link not found")
+	ErrDeleted  = errors.New("link deleted")
+)
+
+// ConflictError - ссылка уже была сокращена в этом пространстве имен, ShortURL содержит существующую короткую ссылку
+type ConflictError struct {
+	ShortURL string
+}
+
+// Error - реализует интерфейс error
+func (e *ConflictError) Error() string {
+	return "url already shortened: " + e.ShortURL
+}
+
+// BlockedError - ссылка заблокирована администратором или политикой, Reason содержит причину блокировки
+type BlockedError struct {
+	Reason string
+}
+
+// Error - реализует интерфейс error
+func (e *BlockedError) Error() string {
+	return "link blocked: " + e.Reason
+}
+
+// Checker - проверка исходной ссылки по политике запрещенных адресов, возвращает ошибку для запрещенной ссылки
+type Checker interface {
+	Check(url string) error
+}
+
+// Структура для лхранения ссылок. Ключом InnerLinks является полная короткая ссылка, поэтому коды ссылок
+// уникальны только в пределах своего домена, ключом OutterLinks является пара базовый адрес и исходная ссылка.
+// В Meta по короткой ссылке хранятся владелец ссылки, признак удаления, причина блокировки и прежние исходные
+// ссылки. Если задана Policy, то по ней проверяются исходные ссылки при сокращении и раскрытии. clicked отмечает
+// раскрытия, которые еще не сохранены в файл
 type Storage struct {
+	mu          sync.RWMutex
+	fileMu      sync.Mutex
+	clicked     atomic.Bool
 	InnerLinks  map[string]string
 	OutterLinks map[string]string
+	Meta        map[string]*LinkMeta
+	Policy      Checker
+}
+
+// Структура сведений о короткой ссылке, непустой Blocked означает, что ссылка заблокирована администратором.
+// History содержит прежние исходные ссылки в порядке их замены, у ссылок из старых файлов хранилища Created пустое.
+// Clicks - количество раскрытий ссылки, оно увеличивается без блокировки хранилища на запись
+type LinkMeta struct {
+	UserID  string
+	Created time.Time
+	Deleted bool
+	Blocked string
+	Clicks  atomic.Int64
+	History []Revision
+}
+
+// Структура прежней исходной ссылки и времени, когда ее заменили
+type Revision struct {
+	OriginalLink string    `json:"original_url"`
+	Replaced     time.Time `json:"replaced_at"`
+}
+
+// Структура истории короткой ссылки: текущая исходная ссылка и прежние в порядке их замены
+type LinkHistory struct {
+	ShortLink    string     `json:"short_url"`
+	OriginalLink string     `json:"original_url"`
+	History      []Revision `json:"history"`
 }
 
 // Функция создает новое хранилище
@@ -19,14 +89,21 @@ func NewStorage() *Storage {
 	var r = Storage{
 		InnerLinks:  map[string]string{},
 		OutterLinks: map[string]string{},
+		Meta:        map[string]*LinkMeta{},
 	}
 	return &r
 }
 
 // структура описывает формат json для хранения данных в файле
 type StorageJSON struct {
-	ShortLink    string `json:"short_url"`
-	OriginalLink string `json:"original_url"`
+	ShortLink    string     `json:"short_url"`
+	OriginalLink string     `json:"original_url"`
+	UserID       string     `json:"user_id,omitempty"`
+	Created      *time.Time `json:"created,omitempty"`
+	Deleted      bool       `json:"is_deleted,omitempty"`
+	Blocked      string     `json:"blocked,omitempty"`
+	Clicks       int64      `json:"clicks,omitempty"`
+	History      []Revision `json:"history,omitempty"`
 }
 
 // Функция генерирует случайный символ из набора a-z,A-Z,0-9 и возвращает его байтовое представление
@@ -40,9 +117,10 @@ func randChar() int {
 }
 
 // Функция генерирует новую короткую ссылку и проверяет на совпадение в "базе данных" если такая
-// строка уже есть то делает рекурсию на саму себя пока не найдет уникальную ссылку
+// строка уже есть то делает рекурсию на саму себя пока не найдет уникальную ссылку. adr - базовый адрес коротких
+// ссылок вида scheme://host[:port][/path]
 func (s *Storage) createShortCode(adr string) string {
-	shortURL := []byte("http://" + adr + "/")
+	shortURL := []byte(adr + "/")
 	for i := 0; i < 8; i++ {
 		shortURL = append(shortURL, byte(randChar()))
 	}
@@ -54,35 +132,488 @@ func (s *Storage) createShortCode(adr string) string {
 	return result
 }
 
-// Функция получает ссылку которую необходимо сократить и проверяет на наличие ее в "базе данных",
-// если  есть, то возвращает уже готовый короткий URL, если нет то запрашивает новую случайную коротную ссылку
-func (s *Storage) CreateShortURL(url string, adr string) string {
-	val, ok := s.OutterLinks[url]
-	if ok {
-		return val
+// Функция возвращает ключ обратного индекса: исходная ссылка в пространстве имен базового адреса. Пробел не может
+// встречаться в url, поэтому используется как разделитель
+func outerKey(adr string, url string) string {
+	return adr + " " + url
+}
+
+// Функция возвращает короткую ссылку из обратного индекса, если она не удалена. Удаленные ссылки убираются
+// из индекса, проверка защищает от записей, оставшихся в хранилище от прежних версий. Вызывается под блокировкой
+// хранилища
+func (s *Storage) liveLink(adr string, url string) (string, bool) {
+	short, ok := s.OutterLinks[outerKey(adr, url)]
+	if !ok {
+		return "", false
+	}
+	if m, ok := s.Meta[short]; ok && m.Deleted {
+		return "", false
+	}
+	return short, true
+}
+
+// Функция убирает короткую ссылку из обратного индекса, чтобы ее исходную ссылку можно было сократить заново.
+// Вызывается под блокировкой хранилища
+func (s *Storage) unindex(short string) {
+	key := outerKey(baseOf(short), s.InnerLinks[short])
+	if s.OutterLinks[key] == short {
+		delete(s.OutterLinks, key)
+	}
+}
+
+// Функция возвращает базовый адрес короткой ссылки, отбрасывая код
+func baseOf(short string) string {
+	if i := strings.LastIndex(short, "/"); i >= 0 {
+		return short[:i]
+	}
+	return short
+}
+
+// Функция возвращает код короткой ссылки, отбрасывая базовый адрес
+func codeOf(short string) string {
+	return short[strings.LastIndex(short, "/")+1:]
+}
+
+// Функция проверяет исходную ссылку по политике хранилища, без политики разрешена любая ссылка
+func (s *Storage) CheckURL(url string) error {
+	if s.Policy == nil {
+		return nil
+	}
+	return s.Policy.Check(url)
+}
+
+// Функция получает ссылку которую необходимо сократить и проверяет на наличие ее в "базе данных" в пространстве имен
+// базового адреса, если  есть, то возвращает уже готовый короткий URL вместе с ошибкой ConflictError, если нет то
+// запрашивает новую случайную коротную ссылку и запоминает пользователя, создавшего ссылку. Запрещенная политикой
+// ссылка не сокращается
+func (s *Storage) CreateShortURL(url string, adr string, userID string) (string, error) {
+	if err := s.CheckURL(url); err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if val, ok := s.liveLink(adr, url); ok {
+		return val, &ConflictError{ShortURL: val}
 	}
 	result := s.createShortCode(adr)
-	s.OutterLinks[url] = result
+	s.OutterLinks[outerKey(adr, url)] = result
 	s.InnerLinks[result] = url
-	return result
+	s.Meta[result] = &LinkMeta{UserID: userID, Created: time.Now().UTC()}
+	return result, nil
 }
 
 // Функция получает коротную ссылку и проверяет наличие ее в "базе данных" если существует, то возвращяет ее
-// если нет, то возвращает ошибку
+// если нет или ссылка удалена, то возвращает ошибку. Для заблокированной ссылки, в том числе запрещенной политикой
+// после сокращения, возвращает BlockedError
 func (s *Storage) GetURL(url string) (l string, e error) {
+	s.mu.RLock()
 	l, ok := s.InnerLinks[url]
+	var deleted bool
+	var blocked string
+	if m, ok := s.Meta[url]; ok {
+		deleted, blocked = m.Deleted, m.Blocked
+	}
+	s.mu.RUnlock()
+	if !ok {
+		return "", ErrNotFound
+	}
+	if deleted {
+		return "", ErrDeleted
+	}
+	if blocked != "" {
+		return "", &BlockedError{Reason: blocked}
+	}
+	if s.Policy != nil {
+		if err := s.Policy.Check(l); err != nil {
+			return "", &BlockedError{Reason: err.Error()}
+		}
+	}
+	return l, nil
+}
+
+// Функция увеличивает счетчик раскрытий короткой ссылки. Раскрытия не мешают друг другу и чтению хранилища, поэтому
+// счетчик увеличивается под блокировкой на чтение, на запись хранилище блокируется только для ссылки без сведений.
+// Счетчик сохраняется в файл при следующем сохранении хранилища, в том числе вызовом SaveClicks
+func (s *Storage) CountClick(short string) {
+	s.mu.RLock()
+	m, ok := s.Meta[short]
 	if ok {
-		return l, nil
+		m.Clicks.Add(1)
+	}
+	s.mu.RUnlock()
+	if !ok {
+		s.mu.Lock()
+		if _, ok := s.InnerLinks[short]; !ok {
+			s.mu.Unlock()
+			return
+		}
+		m, ok = s.Meta[short]
+		if !ok {
+			m = &LinkMeta{}
+			s.Meta[short] = m
+		}
+		m.Clicks.Add(1)
+		s.mu.Unlock()
+	}
+	s.clicked.Store(true)
+}
+
+// Функция сохраняет хранилище в файл, если после последнего сохранения ссылки раскрывались, и сообщает, было ли
+// сохранение
+func (s *Storage) SaveClicks(file string) bool {
+	if !s.clicked.Load() {
+		return false
+	}
+	s.SaveToFile(file)
+	return true
+}
+
+// Функция блокирует короткую ссылку с указанием причины, ссылка остается в хранилище, но не раскрывается.
+// Возвращает прежнюю причину блокировки
+func (s *Storage) BlockURL(short string, reason string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.InnerLinks[short]; !ok {
+		return "", ErrNotFound
+	}
+	m, ok := s.Meta[short]
+	if !ok {
+		m = &LinkMeta{}
+		s.Meta[short] = m
+	}
+	prev := m.Blocked
+	m.Blocked = reason
+	return prev, nil
+}
+
+// Функция снимает блокировку администратора с короткой ссылки и возвращает прежнюю причину блокировки
+func (s *Storage) UnblockURL(short string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.InnerLinks[short]; !ok {
+		return "", ErrNotFound
+	}
+	var prev string
+	if m, ok := s.Meta[short]; ok {
+		prev = m.Blocked
+		m.Blocked = ""
+	}
+	return prev, nil
+}
+
+// Функция возвращает неудаленные ссылки пользователя с количеством их раскрытий, отсортированные по короткой ссылке
+func (s *Storage) GetUserURLs(userID string) []StorageJSON {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var r []StorageJSON
+	for short, m := range s.Meta {
+		if m.UserID == userID && !m.Deleted {
+			r = append(r, StorageJSON{ShortLink: short, OriginalLink: s.InnerLinks[short], Clicks: m.Clicks.Load()})
+		}
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].ShortLink < r[j].ShortLink })
+	return r
+}
+
+// Функция помечает удаленными ссылки пользователя с указанными кодами, убирает их из обратного индекса и возвращает
+// удаленные ссылки, отсортированные по короткой ссылке, чужие и несуществующие коды пропускаются
+func (s *Storage) DeleteUserURLs(userID string, codes []string) []StorageJSON {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	del := map[string]bool{}
+	for _, c := range codes {
+		del[c] = true
+	}
+	var r []StorageJSON
+	for short, m := range s.Meta {
+		if m.UserID == userID && !m.Deleted && del[codeOf(short)] {
+			m.Deleted = true
+			s.unindex(short)
+			r = append(r, StorageJSON{ShortLink: short, OriginalLink: s.InnerLinks[short]})
+		}
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].ShortLink < r[j].ShortLink })
+	return r
+}
+
+// Функция находит ссылку пользователя по коду. Коды уникальны только в пределах домена, поэтому среди одинаковых
+// кодов пользователя на разных доменах выбирается первая по порядку короткая ссылка. Для чужого и несуществующего
+// кода возвращает ErrNotFound, для удаленной ссылки ErrDeleted. Вызывается под блокировкой хранилища
+func (s *Storage) userLink(userID string, code string) (string, *LinkMeta, error) {
+	var short string
+	for k, m := range s.Meta {
+		if m.UserID == userID && codeOf(k) == code && (short == "" || k < short) {
+			short = k
+		}
+	}
+	if short == "" {
+		return "", nil, ErrNotFound
+	}
+	m := s.Meta[short]
+	if m.Deleted {
+		return "", nil, ErrDeleted
+	}
+	return short, m, nil
+}
+
+// Функция меняет исходную ссылку у ссылки пользователя с кодом code и возвращает короткую ссылку и прежнюю исходную
+// ссылку. Прежняя исходная ссылка вместе со временем замены добавляется в историю, обратный индекс переносится
+// на новую исходную ссылку. Если новая ссылка уже сокращена в пространстве имен того же домена и не удалена, то
+// возвращает ConflictError с существующей короткой ссылкой, заблокированную ссылку изменить нельзя
+func (s *Storage) UpdateURL(userID string, code string, url string) (string, string, error) {
+	if s.Policy != nil {
+		if err := s.Policy.Check(url); err != nil {
+			return "", "", err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	short, m, err := s.userLink(userID, code)
+	if err != nil {
+		return "", "", err
+	}
+	if m.Blocked != "" {
+		return "", "", &BlockedError{Reason: m.Blocked}
+	}
+	old := s.InnerLinks[short]
+	if old == url {
+		return short, old, nil
+	}
+	adr := baseOf(short)
+	if val, ok := s.liveLink(adr, url); ok {
+		return val, "", &ConflictError{ShortURL: val}
+	}
+	if s.OutterLinks[outerKey(adr, old)] == short {
+		delete(s.OutterLinks, outerKey(adr, old))
+	}
+	s.OutterLinks[outerKey(adr, url)] = short
+	s.InnerLinks[short] = url
+	m.History = append(m.History, Revision{OriginalLink: old, Replaced: time.Now().UTC()})
+	return short, old, nil
+}
+
+// Функция возвращает историю исходных ссылок у ссылки пользователя с кодом code
+func (s *Storage) GetURLHistory(userID string, code string) (LinkHistory, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	short, m, err := s.userLink(userID, code)
+	if err != nil {
+		return LinkHistory{}, err
+	}
+	return LinkHistory{
+		ShortLink:    short,
+		OriginalLink: s.InnerLinks[short],
+		History:      append([]Revision{}, m.History...),
+	}, nil
+}
+
+// Функция возвращает количество неудаленных ссылок и количество пользователей, создавших ссылки
+func (s *Storage) Stats() (urls int, users int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u := map[string]bool{}
+	for short := range s.InnerLinks {
+		m, ok := s.Meta[short]
+		if ok && m.Deleted {
+			continue
+		}
+		urls++
+		if ok && m.UserID != "" {
+			u[m.UserID] = true
+		}
+	}
+	return urls, len(u)
+}
+
+// Структура условий поиска ссылок. Destination ищется в исходной ссылке как подстрока без учета регистра, From и To
+// задают полуинтервал времени создания [From, To), удаленные ссылки попадают в выборку только с Deleted. Пустые
+// условия не ограничивают выборку, Offset и Limit задают страницу
+type Filter struct {
+	Destination string
+	UserID      string
+	From        time.Time
+	To          time.Time
+	Deleted     bool
+	Offset      int
+	Limit       int
+}
+
+// Функция проверяет, что ссылка подходит под условия поиска
+func (f Filter) match(url string, m *LinkMeta) bool {
+	return (f.Deleted || !m.Deleted) &&
+		(f.UserID == "" || m.UserID == f.UserID) &&
+		(f.Destination == "" || strings.Contains(strings.ToLower(url), strings.ToLower(f.Destination))) &&
+		(f.From.IsZero() || !m.Created.IsZero() && !m.Created.Before(f.From)) &&
+		(f.To.IsZero() || !m.Created.IsZero() && m.Created.Before(f.To))
+}
+
+// Функция возвращает время создания ссылки для записи в json, неизвестное время не записывается
+func createdOf(m *LinkMeta) *time.Time {
+	if m.Created.IsZero() {
+		return nil
+	}
+	t := m.Created
+	return &t
+}
+
+// Функция ищет ссылки по условиям и возвращает страницу найденных ссылок, сначала новые, и общее количество найденных
+func (s *Storage) SearchURLs(f Filter) ([]StorageJSON, int) {
+	s.mu.RLock()
+	var found []StorageJSON
+	for short, url := range s.InnerLinks {
+		m, ok := s.Meta[short]
+		if !ok {
+			m = &LinkMeta{}
+		}
+		if f.match(url, m) {
+			found = append(found, StorageJSON{ShortLink: short, OriginalLink: url, UserID: m.UserID, Created: createdOf(m), Deleted: m.Deleted, Blocked: m.Blocked, Clicks: m.Clicks.Load()})
+		}
+	}
+	s.mu.RUnlock()
+	sort.Slice(found, func(i, j int) bool {
+		ci, cj := found[i].Created, found[j].Created
+		if ci != nil && cj != nil && !ci.Equal(*cj) {
+			return ci.After(*cj)
+		}
+		if (ci == nil) != (cj == nil) {
+			return cj == nil
+		}
+		return found[i].ShortLink < found[j].ShortLink
+	})
+	total := len(found)
+	start := min(max(f.Offset, 0), total)
+	end := total
+	if f.Limit > 0 {
+		end = min(start+f.Limit, total)
+	}
+	return append([]StorageJSON{}, found[start:end]...), total
+}
+
+// Функция помечает удаленными ссылки независимо от владельца, убирает их из обратного индекса и возвращает удаленные
+// ссылки, отсортированные по короткой ссылке, несуществующие и уже удаленные ссылки пропускаются
+func (s *Storage) ForceDeleteURLs(shorts []string) []StorageJSON {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var r []StorageJSON
+	for _, short := range shorts {
+		url, ok := s.InnerLinks[short]
+		if !ok {
+			continue
+		}
+		m, ok := s.Meta[short]
+		if !ok {
+			m = &LinkMeta{}
+			s.Meta[short] = m
+		}
+		if m.Deleted {
+			continue
+		}
+		m.Deleted = true
+		s.unindex(short)
+		r = append(r, StorageJSON{ShortLink: short, OriginalLink: url, UserID: m.UserID})
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].ShortLink < r[j].ShortLink })
+	return r
+}
+
+// Функция передает ссылки пользователю userID и возвращает переданные ссылки с прежними владельцами, отсортированные
+// по короткой ссылке. Несуществующие ссылки и ссылки, которые уже принадлежат пользователю, пропускаются
+func (s *Storage) ReassignURLs(shorts []string, userID string) []StorageJSON {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var r []StorageJSON
+	for _, short := range shorts {
+		url, ok := s.InnerLinks[short]
+		if !ok {
+			continue
+		}
+		m, ok := s.Meta[short]
+		if !ok {
+			m = &LinkMeta{}
+			s.Meta[short] = m
+		}
+		if m.UserID == userID {
+			continue
+		}
+		r = append(r, StorageJSON{ShortLink: short, OriginalLink: url, UserID: m.UserID})
+		m.UserID = userID
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].ShortLink < r[j].ShortLink })
+	return r
+}
+
+// Структура статистики хранилища для администратора
+type Summary struct {
+	Links   int `json:"links"`
+	Active  int `json:"active"`
+	Deleted int `json:"deleted"`
+	Blocked int `json:"blocked"`
+	Edited  int `json:"edited"`
+	Users   int `json:"users"`
+}
+
+// Функция возвращает статистику хранилища: количество ссылок всего, действующих, удаленных, заблокированных
+// администратором и измененных владельцами, а также количество пользователей с неудаленными ссылками
+func (s *Storage) Summary() Summary {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var r Summary
+	users := map[string]bool{}
+	for short := range s.InnerLinks {
+		r.Links++
+		m, ok := s.Meta[short]
+		if !ok {
+			r.Active++
+			continue
+		}
+		switch {
+		case m.Deleted:
+			r.Deleted++
+		case m.Blocked != "":
+			r.Blocked++
+		default:
+			r.Active++
+		}
+		if len(m.History) > 0 {
+			r.Edited++
+		}
+		if !m.Deleted && m.UserID != "" {
+			users[m.UserID] = true
+		}
+	}
+	r.Users = len(users)
+	return r
+}
+
+// Функция возвращает количество записей в картах хранилища, в отличие от Stats не перебирает ссылки и подходит для
+// частого опроса при наблюдении за ростом памяти
+func (s *Storage) Sizes() map[string]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return map[string]int{
+		"inner_links":  len(s.InnerLinks),
+		"outter_links": len(s.OutterLinks),
+		"meta":         len(s.Meta),
 	}
-	return "", errors.New("link not found")
 }
 
 // Функция сохранения хранилища в файл
 func (s *Storage) SaveToFile(file string) {
+	s.fileMu.Lock()
+	defer s.fileMu.Unlock()
+	// раскрытия после снимка хранилища снова отметят его несохраненным
+	s.clicked.Store(false)
+	s.mu.RLock()
 	st := []StorageJSON{}
 	for i, e := range s.InnerLinks {
-		st = append(st, StorageJSON{i, e})
+		r := StorageJSON{ShortLink: i, OriginalLink: e}
+		if m, ok := s.Meta[i]; ok {
+			r.UserID, r.Deleted, r.Blocked, r.Clicks, r.History = m.UserID, m.Deleted, m.Blocked, m.Clicks.Load(), m.History
+			r.Created = createdOf(m)
+		}
+		st = append(st, r)
 	}
+	s.mu.RUnlock()
 	tf, err := json.Marshal(st)
 	if err != nil {
 		panic(err)
@@ -106,14 +637,22 @@ func (s *Storage) RestoreFromfile(file string) {
 	}
 	defer fl.Close()
 	st := []StorageJSON{}
-	r := bufio.NewScanner(fl)
-	r.Scan()
-	bt := r.Bytes()
-	if err := json.Unmarshal(bt, &st); err != nil {
+	if err := json.NewDecoder(fl).Decode(&st); err != nil && err != io.EOF {
 		panic(err)
 	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	for _, e := range st {
-		s.OutterLinks[e.OriginalLink] = e.ShortLink
+		// удаленные ссылки не попадают в обратный индекс, их исходные ссылки можно сократить заново
+		if !e.Deleted {
+			s.OutterLinks[outerKey(baseOf(e.ShortLink), e.OriginalLink)] = e.ShortLink
+		}
 		s.InnerLinks[e.ShortLink] = e.OriginalLink
+		m := &LinkMeta{UserID: e.UserID, Deleted: e.Deleted, Blocked: e.Blocked, History: e.History}
+		m.Clicks.Store(e.Clicks)
+		if e.Created != nil {
+			m.Created = *e.Created
+		}
+		s.Meta[e.ShortLink] = m
 	}
 }
@@ -0,0 +1,262 @@
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Области действия ключей api
+const (
+	ScopeShorten = "shorten"
+	ScopeRead    = "read"
+	ScopeDelete  = "delete"
+	ScopeStats   = "stats"
+	ScopeEdit    = "edit"
+	ScopeAdmin   = "admin"
+)
+
+// Scopes - все допустимые области действия ключей. Область admin дает ключу роль администратора
+var Scopes = []string{ScopeShorten, ScopeRead, ScopeDelete, ScopeStats, ScopeEdit, ScopeAdmin}
+
+// CookieScopes - области действия пользователей с cookie: работа только со своими ссылками, статистика сервиса
+// доступна ключам с областью stats и администратору
+var CookieScopes = []string{ScopeShorten, ScopeRead, ScopeDelete, ScopeEdit}
+
+// Префикс ключей api, по нему ключ легко узнать в конфигурации клиента
+const tokenPrefix = "sk_"
+
+// Ошибки хранилища ключей
+var (
+	ErrInvalidKey   = errors.New("api key is unknown or revoked")
+	ErrUnknownScope = errors.New("unknown api key scope")
+	ErrNotFound     = errors.New("api key not found")
+)
+
+// Структура ключа api. Сам ключ не хранится, хранится только его хеш, поэтому ключ показывается клиенту один раз при
+// создании. Ссылки, созданные по ключу, принадлежат пользователю с идентификатором UserID. Ключ, привязанный
+// к арендатору, в заголовке X-API-Key открывает домены арендатора
+type Key struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Hash    string    `json:"hash"`
+	Scopes  []string  `json:"scopes"`
+	UserID  string    `json:"user_id"`
+	Tenant  string    `json:"tenant,omitempty"`
+	Created time.Time `json:"created"`
+	Revoked bool      `json:"revoked,omitempty"`
+}
+
+// HasScope - проверяет, что ключ выдан на указанную область действия
+func (k *Key) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Функция проверяет, что область действия входит в список допустимых
+func validScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Структура хранилища ключей api, ключи индексируются по идентификатору и по хешу
+type Store struct {
+	mu     sync.RWMutex
+	fileMu sync.Mutex
+	byID   map[string]*Key
+	byHash map[string]*Key
+}
+
+// Функция создает пустое хранилище ключей
+func NewStore() *Store {
+	var r = Store{
+		byID:   map[string]*Key{},
+		byHash: map[string]*Key{},
+	}
+	return &r
+}
+
+// Функция возвращает случайную строку из n байт в шестнадцатеричном виде
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Функция возвращает хеш ключа. Ключи случайные и длинные, поэтому медленный хеш для паролей не нужен
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// TenantUserID - возвращает пользователя ключей арендатора. Он не меняется между перезапусками и при замене ключей,
+// поэтому арендатор сохраняет доступ к своим ссылкам
+func TenantUserID(tenant string) string {
+	return "tenant:" + tenant
+}
+
+// Create - создает ключ с названием и областями действия, возвращает сведения о ключе и сам ключ
+func (s *Store) Create(name string, scopes []string) (Key, string, error) {
+	return s.CreateForTenant(name, "", scopes)
+}
+
+// CreateForTenant - создает ключ, привязанный к арендатору, пустое имя арендатора создает обычный ключ. Все ключи
+// арендатора принадлежат пользователю TenantUserID
+func (s *Store) CreateForTenant(name string, tenant string, scopes []string) (Key, string, error) {
+	if len(scopes) == 0 {
+		return Key{}, "", ErrUnknownScope
+	}
+	for _, sc := range scopes {
+		if !validScope(sc) {
+			return Key{}, "", ErrUnknownScope
+		}
+	}
+	token := tokenPrefix + randomHex(24)
+	k := &Key{
+		ID:      randomHex(8),
+		Name:    name,
+		Hash:    hashToken(token),
+		Scopes:  append([]string(nil), scopes...),
+		UserID:  randomHex(16),
+		Tenant:  tenant,
+		Created: time.Now().UTC().Truncate(time.Second),
+	}
+	if tenant != "" {
+		k.UserID = TenantUserID(tenant)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.byID[k.ID] = k
+	s.byHash[k.Hash] = k
+	return *k, token, nil
+}
+
+// ImportTenantKey - добавляет ключ арендатора, заданный в настройках, с областью shorten. Хранится только хеш ключа,
+// уже добавленный ключ не дублируется. Ключ принадлежит пользователю TenantUserID, поэтому без файла ключей ссылки
+// арендатора остаются ему доступны после перезапуска
+func (s *Store) ImportTenantKey(tenant string, token string) (Key, error) {
+	if tenant == "" || token == "" {
+		return Key{}, ErrInvalidKey
+	}
+	hash := hashToken(token)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if k, ok := s.byHash[hash]; ok {
+		return *k, nil
+	}
+	k := &Key{
+		ID:      randomHex(8),
+		Name:    tenant,
+		Hash:    hash,
+		Scopes:  []string{ScopeShorten},
+		UserID:  TenantUserID(tenant),
+		Tenant:  tenant,
+		Created: time.Now().UTC().Truncate(time.Second),
+	}
+	s.byID[k.ID] = k
+	s.byHash[k.Hash] = k
+	return *k, nil
+}
+
+// Verify - возвращает действующий ключ по его значению. Ключ ищется по хешу, поэтому время поиска не зависит
+// от совпадения ключа с известными, найденный хеш дополнительно сравнивается за постоянное время
+func (s *Store) Verify(token string) (Key, error) {
+	if token == "" {
+		return Key{}, ErrInvalidKey
+	}
+	hash := hashToken(token)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	k, ok := s.byHash[hash]
+	if !ok || k.Revoked || subtle.ConstantTimeCompare([]byte(k.Hash), []byte(hash)) != 1 {
+		return Key{}, ErrInvalidKey
+	}
+	return *k, nil
+}
+
+// Revoke - отзывает ключ, отозванный ключ остается в списке, но перестает действовать
+func (s *Store) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.byID[id]
+	if !ok {
+		return ErrNotFound
+	}
+	k.Revoked = true
+	return nil
+}
+
+// List - возвращает все ключи, отсортированные по времени создания
+func (s *Store) List() []Key {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r := make([]Key, 0, len(s.byID))
+	for _, k := range s.byID {
+		r = append(r, *k)
+	}
+	sort.Slice(r, func(i, j int) bool {
+		if !r[i].Created.Equal(r[j].Created) {
+			return r[i].Created.Before(r[j].Created)
+		}
+		return r[i].ID < r[j].ID
+	})
+	return r
+}
+
+// SaveToFile - сохраняет ключи в файл, если путь не задан, то ключи хранятся только в памяти
+func (s *Store) SaveToFile(file string) error {
+	if file == "" {
+		return nil
+	}
+	s.fileMu.Lock()
+	defer s.fileMu.Unlock()
+	bt, err := json.Marshal(s.List())
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, bt, 0o600)
+}
+
+// RestoreFromFile - загружает ключи из файла, отсутствие файла не является ошибкой
+func (s *Store) RestoreFromFile(file string) error {
+	if file == "" {
+		return nil
+	}
+	fl, err := os.Open(file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer fl.Close()
+	var keys []*Key
+	if err := json.NewDecoder(fl).Decode(&keys); err != nil && err != io.EOF {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range keys {
+		s.byID[k.ID] = k
+		s.byHash[k.Hash] = k
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package apikey
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	s := NewStore()
+	_, _, err := s.Create("backend", []string{ScopeShorten, "root"})
+	assert.ErrorIs(t, err, ErrUnknownScope)
+
+	k, token, err := s.Create("backend", []string{ScopeShorten, ScopeRead})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(token, "sk_"))
+	assert.NotContains(t, k.Hash, token)
+
+	got, err := s.Verify(token)
+	require.NoError(t, err)
+	assert.Equal(t, k.ID, got.ID)
+	assert.True(t, got.HasScope(ScopeRead))
+	assert.False(t, got.HasScope(ScopeDelete))
+	_, err = s.Verify(token + "0")
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	// ключи переживают перезапуск, в файле хранится только хеш
+	file := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, s.SaveToFile(file))
+	restored := NewStore()
+	require.NoError(t, restored.RestoreFromFile(file))
+	_, err = restored.Verify(token)
+	require.NoError(t, err)
+
+	require.NoError(t, restored.Revoke(k.ID))
+	_, err = restored.Verify(token)
+	assert.ErrorIs(t, err, ErrInvalidKey)
+	assert.ErrorIs(t, restored.Revoke("unknown"), ErrNotFound)
+	assert.True(t, restored.List()[0].Revoked)
+}
+
+func TestTenantKeys(t *testing.T) {
+	s := NewStore()
+	_, err := s.ImportTenantKey("brand", "")
+	assert.ErrorIs(t, err, ErrInvalidKey)
+	k, err := s.ImportTenantKey("brand", "brand-key")
+	require.NoError(t, err)
+	assert.Equal(t, []string{ScopeShorten}, k.Scopes)
+	assert.NotContains(t, k.Hash, "brand-key")
+	// повторная загрузка того же ключа не создает новый ключ
+	again, err := s.ImportTenantKey("brand", "brand-key")
+	require.NoError(t, err)
+	assert.Equal(t, k.ID, again.ID)
+	assert.Len(t, s.List(), 1)
+	// пользователь ключа арендатора не зависит от запуска, в новом хранилище он тот же
+	fresh, err := NewStore().ImportTenantKey("brand", "brand-key")
+	require.NoError(t, err)
+	assert.Equal(t, k.UserID, fresh.UserID)
+
+	got, err := s.Verify("brand-key")
+	require.NoError(t, err)
+	assert.Equal(t, "brand", got.Tenant)
+
+	issued, token, err := s.CreateForTenant("ci", "open", []string{ScopeShorten})
+	require.NoError(t, err)
+	got, err = s.Verify(token)
+	require.NoError(t, err)
+	assert.Equal(t, "open", got.Tenant)
+	assert.Equal(t, TenantUserID("open"), issued.UserID)
+	require.NoError(t, s.Revoke(issued.ID))
+	_, err = s.Verify(token)
+	assert.ErrorIs(t, err, ErrInvalidKey)
+}
@@ -0,0 +1,188 @@
+// Package audit - журнал аудита изменяющих операций сервиса: кто, когда и откуда изменил ссылку или ключ api
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Действия, которые записываются в журнал аудита
+const (
+	ActionCreate    = "create"
+	ActionEdit      = "edit"
+	ActionDelete    = "delete"
+	ActionBlock     = "block"
+	ActionUnblock   = "unblock"
+	ActionReassign  = "reassign"
+	ActionKeyCreate = "key.create"
+	ActionKeyRevoke = "key.revoke"
+)
+
+// Ограничения размера страницы выборки из журнала
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+// Структура записи журнала аудита. Actor - пользователь, выполнивший действие, KeyID - ключ api, если действие
+// выполнено по ключу, Target - короткая ссылка или идентификатор ключа, Before и After - значения до и после действия
+type Event struct {
+	ID        int64     `json:"id"`
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"`
+	Actor     string    `json:"actor"`
+	KeyID     string    `json:"key_id,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	Target    string    `json:"target"`
+	Before    string    `json:"before,omitempty"`
+	After     string    `json:"after,omitempty"`
+}
+
+// Структура условий выборки из журнала. Пустые условия не ограничивают выборку, Target совпадает по подстроке,
+// From и To задают полуинтервал времени [From, To). Offset и Limit задают страницу выборки
+type Filter struct {
+	Action string
+	Actor  string
+	IP     string
+	Target string
+	From   time.Time
+	To     time.Time
+	Offset int
+	Limit  int
+}
+
+// Функция проверяет, что запись подходит под условия выборки
+func (f Filter) match(e Event) bool {
+	return (f.Action == "" || e.Action == f.Action) &&
+		(f.Actor == "" || e.Actor == f.Actor) &&
+		(f.IP == "" || e.IP == f.IP) &&
+		(f.Target == "" || strings.Contains(e.Target, f.Target)) &&
+		(f.From.IsZero() || !e.Time.Before(f.From)) &&
+		(f.To.IsZero() || e.Time.Before(f.To))
+}
+
+// Структура журнала аудита. Записи только добавляются: каждая запись дописывается строкой json в конец файла
+// и хранится в памяти для выборок. Без файла журнал хранится только в памяти
+type Store struct {
+	mu     sync.RWMutex
+	events []Event
+	file   *os.File
+	now    func() time.Time
+}
+
+// Функция создает журнал аудита в памяти
+func NewStore() *Store {
+	var r = Store{now: time.Now}
+	return &r
+}
+
+// Open - загружает записи журнала из файла и открывает его на дозапись, отсутствующий файл создается. Пустой путь
+// означает журнал в памяти
+func Open(file string) (*Store, error) {
+	s := NewStore()
+	if file == "" {
+		return s, nil
+	}
+	fl, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	sc := bufio.NewScanner(fl)
+	sc.Buffer(make([]byte, 64*1024), 16<<20)
+	for sc.Scan() {
+		if len(strings.TrimSpace(sc.Text())) == 0 {
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			fl.Close()
+			return nil, errors.New("audit log is corrupted: " + err.Error())
+		}
+		s.events = append(s.events, e)
+	}
+	if err := sc.Err(); err != nil {
+		fl.Close()
+		return nil, err
+	}
+	s.file = fl
+	return s, nil
+}
+
+// Close - закрывает файл журнала
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// Record - добавляет запись в журнал, присваивает ей номер и время. Запись сначала дописывается в файл, так что
+// в памяти не бывает записей, которых нет в файле
+func (s *Store) Record(e Event) (Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e.ID = 1
+	if len(s.events) > 0 {
+		e.ID = s.events[len(s.events)-1].ID + 1
+	}
+	e.Time = s.now().UTC()
+	if s.file != nil {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return Event{}, err
+		}
+		if _, err := s.file.Write(append(line, '\n')); err != nil {
+			return Event{}, err
+		}
+	}
+	s.events = append(s.events, e)
+	return e, nil
+}
+
+// Query - возвращает страницу записей, подходящих под условия, от новых к старым, и общее количество подходящих записей
+func (s *Store) Query(f Filter) ([]Event, int) {
+	if f.Limit <= 0 {
+		f.Limit = DefaultLimit
+	}
+	f.Limit = min(f.Limit, MaxLimit)
+	f.Offset = max(f.Offset, 0)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r := []Event{}
+	total := 0
+	for i := len(s.events) - 1; i >= 0; i-- {
+		if !f.match(s.events[i]) {
+			continue
+		}
+		if total >= f.Offset && len(r) < f.Limit {
+			r = append(r, s.events[i])
+		}
+		total++
+	}
+	return r, total
+}
+
+// Ключ адреса клиента в контексте
+type sourceKey struct{}
+
+// WithSource - возвращает контекст с адресом клиента, который выполняет действие
+func WithSource(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, sourceKey{}, ip)
+}
+
+// SourceFrom - возвращает адрес клиента из контекста
+func SourceFrom(ctx context.Context) string {
+	ip, _ := ctx.Value(sourceKey{}).(string)
+	return ip
+}
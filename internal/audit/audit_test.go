@@ -0,0 +1,83 @@
+package audit
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	file := t.TempDir() + "/audit.log"
+	s, err := Open(file)
+	require.NoError(t, err)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	e, err := s.Record(Event{Action: ActionCreate, Actor: "u1", IP: "10.0.0.1", Target: "http://localhost:8080/aaaaaaaa", After: "http://ya.ru/"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), e.ID)
+	assert.Equal(t, now, e.Time)
+	now = now.Add(time.Hour)
+	_, err = s.Record(Event{Action: ActionEdit, Actor: "u1", Target: "http://localhost:8080/aaaaaaaa", Before: "http://ya.ru/", After: "http://ya.ru/maps"})
+	require.NoError(t, err)
+	now = now.Add(time.Hour)
+	_, err = s.Record(Event{Action: ActionBlock, Actor: "admin", Target: "http://localhost:8080/bbbbbbbb", After: "spam"})
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	// записи переживают перезапуск, нумерация продолжается
+	s, err = Open(file)
+	require.NoError(t, err)
+	defer s.Close()
+	e, err = s.Record(Event{Action: ActionDelete, Actor: "u1", Target: "http://localhost:8080/aaaaaaaa", Before: "http://ya.ru/maps"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), e.ID)
+
+	all, total := s.Query(Filter{})
+	assert.Equal(t, 4, total)
+	require.Len(t, all, 4)
+	assert.Equal(t, ActionDelete, all[0].Action)
+	assert.Equal(t, ActionCreate, all[3].Action)
+	assert.Equal(t, "10.0.0.1", all[3].IP)
+
+	events, total := s.Query(Filter{Actor: "u1", Target: "aaaaaaaa"})
+	assert.Equal(t, 3, total)
+	assert.Len(t, events, 3)
+
+	events, total = s.Query(Filter{From: time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC), To: time.Date(2024, 5, 1, 14, 0, 0, 0, time.UTC)})
+	assert.Equal(t, 1, total)
+	require.Len(t, events, 1)
+	assert.Equal(t, ActionEdit, events[0].Action)
+
+	events, total = s.Query(Filter{Offset: 1, Limit: 2})
+	assert.Equal(t, 4, total)
+	require.Len(t, events, 2)
+	assert.Equal(t, []int64{3, 2}, []int64{events[0].ID, events[1].ID})
+
+	events, total = s.Query(Filter{Action: ActionKeyCreate})
+	assert.Equal(t, 0, total)
+	assert.NotNil(t, events)
+}
+
+func TestOpenCorrupted(t *testing.T) {
+	file := t.TempDir() + "/audit.log"
+	require.NoError(t, os.WriteFile(file, []byte("{\"id\":1}\nnot json\n"), 0o600))
+	_, err := Open(file)
+	assert.Error(t, err)
+
+	s, err := Open("")
+	require.NoError(t, err)
+	_, err = s.Record(Event{Action: ActionCreate})
+	assert.NoError(t, err)
+	assert.NoError(t, s.Close())
+}
+
+func TestSource(t *testing.T) {
+	ctx := WithSource(context.Background(), "192.0.2.1")
+	assert.Equal(t, "192.0.2.1", SourceFrom(ctx))
+	assert.Empty(t, SourceFrom(context.Background()))
+}
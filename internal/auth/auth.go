@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/h1067675/shortUrl/internal/apikey"
+)
+
+// CookieName - имя cookie, в которой передается подписанный идентификатор пользователя
+const CookieName = "user_id"
+
+// ErrInvalidToken - токен пользователя поврежден или подписан другим ключом
+var ErrInvalidToken = errors.New("invalid user token")
+
+// Структура описывающая пользователя запроса. Issued означает, что идентификатор выдан в этом запросе,
+// то есть у пользователя еще не было действительного токена. Для пользователя, вошедшего по ключу api, KeyID
+// содержит идентификатор ключа, а Scopes области его действия, Admin означает вход по токену администратора или
+// по ключу с областью admin
+type Identity struct {
+	UserID string
+	Issued bool
+	KeyID  string
+	Scopes []string
+	Admin  bool
+}
+
+// HasScope - проверяет право пользователя на область действия. Администратору доступны все области, пользователю
+// с ключом api - области ключа, пользователю с cookie - apikey.CookieScopes
+func (id Identity) HasScope(scope string) bool {
+	if id.Admin {
+		return true
+	}
+	scopes := id.Scopes
+	if id.KeyID == "" {
+		scopes = apikey.CookieScopes
+	}
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type ctxKey struct{}
+
+// Структура выдающая и проверяющая подписанные идентификаторы пользователей
+type Authenticator struct {
+	secret []byte
+}
+
+// Функция создания аутентификатора, если секрет не задан, то генерируется случайный и токены
+// перестают действовать после перезапуска сервиса
+func New(secret string) *Authenticator {
+	var r = Authenticator{secret: []byte(secret)}
+	if secret == "" {
+		r.secret = []byte(randomHex(32))
+	}
+	return &r
+}
+
+// Функция возвращает случайную строку из n байт в шестнадцатеричном виде
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// NewUserID - генерирует новый идентификатор пользователя
+func (a *Authenticator) NewUserID() string {
+	return randomHex(16)
+}
+
+// Функция вычисляет подпись идентификатора пользователя
+func (a *Authenticator) sign(userID string) string {
+	h := hmac.New(sha256.New, a.secret)
+	h.Write([]byte(userID))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Token - возвращает токен вида <идентификатор>.<подпись>
+func (a *Authenticator) Token(userID string) string {
+	return userID + "." + a.sign(userID)
+}
+
+// Verify - проверяет подпись токена и возвращает идентификатор пользователя
+func (a *Authenticator) Verify(token string) (string, error) {
+	id, sig, ok := strings.Cut(token, ".")
+	if !ok || id == "" || !hmac.Equal([]byte(sig), []byte(a.sign(id))) {
+		return "", ErrInvalidToken
+	}
+	return id, nil
+}
+
+// Identify - возвращает пользователя по токену, если токен отсутствует или недействителен, то выдает новый
+// идентификатор и возвращает токен, который нужно передать клиенту
+func (a *Authenticator) Identify(token string) (Identity, string) {
+	if id, err := a.Verify(token); err == nil {
+		return Identity{UserID: id}, ""
+	}
+	id := a.NewUserID()
+	return Identity{UserID: id, Issued: true}, a.Token(id)
+}
+
+// Middleware - читает cookie с токеном пользователя, при отсутствии или повреждении выдает новую cookie,
+// и помещает пользователя в контекст запроса
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var token string
+		if c, err := r.Cookie(CookieName); err == nil {
+			token = c.Value
+		}
+		id, issued := a.Identify(token)
+		if issued != "" {
+			http.SetCookie(w, &http.Cookie{Name: CookieName, Value: issued, Path: "/", HttpOnly: true})
+		}
+		next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
+	})
+}
+
+// WithIdentity - возвращает контекст с пользователем
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext - возвращает пользователя из контекста
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(ctxKey{}).(Identity)
+	return id, ok
+}
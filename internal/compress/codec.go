@@ -0,0 +1,173 @@
+package compress
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec - кодек сжатия для значения Content-Encoding. Писатели кодеков берутся из пула, поэтому писатель
+// нужно обязательно закрыть, закрытие возвращает его в пул
+type Codec interface {
+	Name() string
+	NewReader(r io.Reader) (io.ReadCloser, error)
+	NewWriter(w io.Writer) io.WriteCloser
+}
+
+// LimitedCodec - кодек, который может заранее ограничить память распаковки размером распакованного тела.
+// Такой кодек отказывается распаковывать поток, которому нужно больше памяти, и возвращает ErrTooLarge
+type LimitedCodec interface {
+	NewLimitedReader(r io.Reader, max int64) (io.ReadCloser, error)
+}
+
+// Интерфейс писателя, который можно переиспользовать для другого потока
+type resetWriter interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// Структура писателя из пула, после закрытия писатель возвращается в пул и больше не используется
+type pooledWriter struct {
+	resetWriter
+	pool *sync.Pool
+}
+
+// Close - завершает сжатый поток и возвращает писатель в пул
+func (w *pooledWriter) Close() error {
+	if w.resetWriter == nil {
+		return nil
+	}
+	err := w.resetWriter.Close()
+	w.resetWriter.Reset(nil)
+	w.pool.Put(w.resetWriter)
+	w.resetWriter = nil
+	return err
+}
+
+// Структура кодека с пулом писателей
+type codec struct {
+	name      string
+	pool      sync.Pool
+	newReader func(r io.Reader, max int64) (io.ReadCloser, error)
+}
+
+// Name - возвращает значение Content-Encoding кодека
+func (c *codec) Name() string {
+	return c.name
+}
+
+// NewReader - возвращает распаковывающий читатель
+func (c *codec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return c.newReader(r, 0)
+}
+
+// NewLimitedReader - возвращает распаковывающий читатель, которому нужно не больше max байт памяти
+func (c *codec) NewLimitedReader(r io.Reader, max int64) (io.ReadCloser, error) {
+	return c.newReader(r, max)
+}
+
+// NewWriter - возвращает сжимающий писатель из пула
+func (c *codec) NewWriter(w io.Writer) io.WriteCloser {
+	enc := c.pool.Get().(resetWriter)
+	enc.Reset(w)
+	return &pooledWriter{resetWriter: enc, pool: &c.pool}
+}
+
+// Функция создает кодек с пулом писателей, которые создаются функцией newWriter
+func newCodec(name string, newWriter func() resetWriter, newReader func(r io.Reader, max int64) (io.ReadCloser, error)) *codec {
+	var r = codec{name: name, newReader: newReader}
+	r.pool.New = func() interface{} { return newWriter() }
+	return &r
+}
+
+// Структура читателя zstd, закрытие освобождает ресурсы декодера
+type zstdReader struct {
+	*zstd.Decoder
+}
+
+// Read - читает распакованный поток, превышение ограничений декодера возвращается как ErrTooLarge
+func (r zstdReader) Read(p []byte) (int, error) {
+	n, err := r.Decoder.Read(p)
+	return n, zstdError(err)
+}
+
+// Close - освобождает декодер
+func (r zstdReader) Close() error {
+	r.Decoder.Close()
+	return nil
+}
+
+// Функция заменяет ошибки превышения окна и памяти декодера zstd на ErrTooLarge
+func zstdError(err error) error {
+	if errors.Is(err, zstd.ErrWindowSizeExceeded) || errors.Is(err, zstd.ErrDecoderSizeExceeded) {
+		return fmt.Errorf("%w: %v", ErrTooLarge, err)
+	}
+	return err
+}
+
+// Функция создает декодер zstd. Окно и память декодера ограничиваются размером распакованного тела limit,
+// иначе заголовок кадра может потребовать окно в сотни мегабайт при любом размере тела
+func newZstdReader(r io.Reader, limit int64) (io.ReadCloser, error) {
+	opts := []zstd.DOption{zstd.WithDecoderConcurrency(1)}
+	if limit > 0 {
+		window := min(max(uint64(limit), zstd.MinWindowSize), zstd.MaxWindowSize)
+		opts = append(opts, zstd.WithDecoderMaxWindow(window), zstd.WithDecoderMaxMemory(uint64(limit)))
+	}
+	d, err := zstd.NewReader(r, opts...)
+	if err != nil {
+		return nil, zstdError(err)
+	}
+	return zstdReader{d}, nil
+}
+
+// Реестр кодеков по значению Content-Encoding
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Codec{}
+)
+
+// Register - добавляет кодек в реестр, кодек с тем же именем заменяется
+func Register(c Codec) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[strings.ToLower(c.Name())] = c
+}
+
+// Lookup - возвращает кодек по значению Content-Encoding, x-gzip считается синонимом gzip
+func Lookup(name string) (Codec, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "x-gzip" {
+		name = "gzip"
+	}
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	c, ok := registry[name]
+	return c, ok
+}
+
+// Встроенные кодеки. Уровни сжатия выбраны в пользу скорости, ответы сервиса небольшие
+func init() {
+	Register(newCodec("gzip",
+		func() resetWriter { w, _ := gzip.NewWriterLevel(nil, gzip.BestSpeed); return w },
+		func(r io.Reader, _ int64) (io.ReadCloser, error) { return gzip.NewReader(r) }))
+	// в http кодировка deflate означает поток zlib
+	Register(newCodec("deflate",
+		func() resetWriter { w, _ := zlib.NewWriterLevel(nil, zlib.BestSpeed); return w },
+		func(r io.Reader, _ int64) (io.ReadCloser, error) { return zlib.NewReader(r) }))
+	Register(newCodec("br",
+		func() resetWriter { return brotli.NewWriterLevel(nil, 4) },
+		func(r io.Reader, _ int64) (io.ReadCloser, error) { return io.NopCloser(brotli.NewReader(r)), nil }))
+	Register(newCodec("zstd",
+		func() resetWriter {
+			w, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderConcurrency(1))
+			return w
+		},
+		newZstdReader))
+}
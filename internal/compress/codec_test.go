@@ -0,0 +1,83 @@
+package compress
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Функция сжимает данные кодеком из реестра
+func encode(t *testing.T, name string, data string) []byte {
+	codec, ok := Lookup(name)
+	require.True(t, ok)
+	var buf bytes.Buffer
+	w := codec.NewWriter(&buf)
+	_, err := io.WriteString(w, data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+// Функция распаковывает данные кодеком из реестра
+func decode(t *testing.T, name string, data []byte) string {
+	codec, ok := Lookup(name)
+	require.True(t, ok)
+	r, err := codec.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(got)
+}
+
+func TestCodecs(t *testing.T) {
+	data := strings.Repeat(`{"short_url":"http://localhost:8080/abcdefgh"}`, 100)
+	for _, name := range []string{"gzip", "x-gzip", "deflate", "br", "zstd"} {
+		t.Run(name, func(t *testing.T) {
+			// писатели берутся из пула повторно, поэтому сжимаем несколько раз
+			for i := 0; i < 3; i++ {
+				enc := encode(t, name, data)
+				assert.Less(t, len(enc), len(data))
+				assert.Equal(t, data, decode(t, name, enc))
+			}
+		})
+	}
+	_, ok := Lookup("compress")
+	assert.False(t, ok)
+}
+
+func TestHandlerCodecs(t *testing.T) {
+	data := strings.Repeat("a", 2*DefaultMinSize)
+	h := CompressHandle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write(body)
+	}))
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{accept: "gzip, deflate, br, zstd", want: "br"},
+		{accept: "gzip;q=1, br;q=0.5", want: "gzip"},
+		{accept: "zstd", want: "zstd"},
+		{accept: "deflate", want: "deflate"},
+	}
+	for _, test := range tests {
+		t.Run(test.accept, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(encode(t, test.want, data)))
+			request.Header.Set("Content-Encoding", test.want)
+			request.Header.Set("Accept-Encoding", test.accept)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, request)
+			require.Equal(t, http.StatusOK, w.Code)
+			require.Equal(t, test.want, w.Header().Get("Content-Encoding"))
+			assert.Equal(t, data, decode(t, test.want, w.Body.Bytes()))
+		})
+	}
+}
@@ -1,44 +1,347 @@
 package compress
 
 import (
-	"compress/gzip"
+	"bytes"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
-type compressWriter struct {
-	http.ResponseWriter
-	Writer io.Writer
+// Минимальный размер ответа по умолчанию, меньшие ответы сжимать невыгодно
+const DefaultMinSize = 1024
+
+// Типы содержимого ответов, которые сжимаются по умолчанию. Тип text/ сжимается целиком
+var DefaultTypes = []string{
+	"text/",
+	"application/json",
+	"application/problem+json",
+	"application/javascript",
+	"application/xml",
+	"image/svg+xml",
 }
 
-func (w compressWriter) Write(b []byte) (int, error) {
-	return w.Writer.Write(b)
+// Ограничения тел запросов по умолчанию
+const (
+	DefaultMaxBodySize    = 1 << 20
+	DefaultMaxDecodedSize = 8 << 20
+	DefaultMaxRatio       = 100
+)
+
+// Размер распакованного тела, после которого начинает действовать ограничение степени сжатия. Маленькие
+// однообразные тела сжимаются очень сильно и без всякого злого умысла
+const ratioFloor = 64 << 10
+
+// Ошибки разбора тела запроса
+var (
+	ErrTooLarge            = errors.New("request body too large")
+	ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+	ErrMalformed           = errors.New("malformed compressed body")
+)
+
+// Кодировки ответов по умолчанию в порядке предпочтения сервиса
+var DefaultEncodings = []string{"br", "zstd", "gzip", "deflate"}
+
+// Структура middleware сжатия. Ответ сжимается, если клиент принимает одну из кодировок Encodings, тип содержимого
+// ответа входит в Types и тело ответа не меньше MinSize байт. Сжатые тела запросов распаковываются любым кодеком
+// из реестра независимо от их типа. Тело запроса ограничено MaxBodySize байт в том виде, в котором оно пришло,
+// и MaxDecodedSize байт после распаковки, а распакованное тело не может быть больше сжатого в MaxRatio раз.
+// Кодекам LimitedCodec MaxDecodedSize передается до распаковки как ограничение памяти. Нулевое ограничение
+// не действует. При превышении ограничения чтение тела возвращает ErrTooLarge. Ошибки,
+// обнаруженные до вызова хандлера, записываются в ответ функцией OnError
+type Compressor struct {
+	MinSize        int
+	Types          []string
+	Encodings      []string
+	MaxBodySize    int64
+	MaxDecodedSize int64
+	MaxRatio       int64
+	OnError        func(w http.ResponseWriter, r *http.Request, err error)
 }
 
+// Функция создания middleware сжатия с настройками по умолчанию
+func New() *Compressor {
+	var r = Compressor{
+		MinSize:        DefaultMinSize,
+		Types:          DefaultTypes,
+		Encodings:      DefaultEncodings,
+		MaxBodySize:    DefaultMaxBodySize,
+		MaxDecodedSize: DefaultMaxDecodedSize,
+		MaxRatio:       DefaultMaxRatio,
+		OnError:        writeError,
+	}
+	return &r
+}
+
+// Функция записывает ошибку разбора тела запроса в ответ в виде текста
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	http.Error(w, err.Error(), StatusFor(err))
+}
+
+// StatusFor - возвращает http статус ошибки разбора тела запроса
+func StatusFor(err error) int {
+	switch {
+	case errors.Is(err, ErrTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrUnsupportedEncoding):
+		return http.StatusUnsupportedMediaType
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// CompressHandle - middleware сжатия с настройками по умолчанию
 func CompressHandle(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
-			if strings.Contains(r.Header.Get("Content-type"), "application/json") || strings.Contains(r.Header.Get("Content-type"), "text/html") {
-				gz, err := gzip.NewWriterLevel(w, gzip.BestSpeed)
-				if err != nil {
-					io.WriteString(w, err.Error())
-					return
-				}
-				defer gz.Close()
-				w.Header().Set("Content-Encoding", "gzip")
-				w = compressWriter{ResponseWriter: w, Writer: gz}
+	return New().Handler(next)
+}
+
+// Negotiate - выбирает кодировку ответа из supported по заголовку Accept-Encoding с учетом q-значений. Кодировка
+// с q=0 запрещена, * задает q для неперечисленных кодировок, при равных q предпочтение отдается порядку supported.
+// Пустая строка означает ответ без сжатия
+func Negotiate(acceptEncoding string, supported []string) string {
+	q := map[string]float64{}
+	wildcard := -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		v := 1.0
+		if k, val, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(k) == "q" {
+			f, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+			if err != nil {
+				continue
 			}
+			v = f
+		}
+		if name == "*" {
+			wildcard = v
+			continue
+		}
+		q[name] = v
+	}
+	best, bestQ := "", 0.0
+	for _, s := range supported {
+		v, ok := q[s]
+		if !ok {
+			v = wildcard
+		}
+		if v > bestQ {
+			best, bestQ = s, v
+		}
+	}
+	return best
+}
+
+// Функция проверяет, что тип содержимого ответа входит в список сжимаемых
+func (c *Compressor) compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if mediaType == "" {
+		return false
+	}
+	for _, t := range c.Types {
+		if strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t) || mediaType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler - возвращает middleware, который распаковывает сжатые тела запросов и сжимает подходящие ответы
+func (c *Compressor) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.MaxBodySize > 0 && r.ContentLength > c.MaxBodySize {
+			c.OnError(w, r, ErrTooLarge)
+			return
 		}
-		if strings.Contains(r.Header.Get("Content-Encoding"), "gzip") && strings.Contains(r.Header.Get("Content-type"), "application/x-gzip") {
-			cr, err := gzip.NewReader(r.Body)
+		wire := &limitReader{r: r.Body, max: c.MaxBodySize}
+		body := io.ReadCloser(struct {
+			io.Reader
+			io.Closer
+		}{wire, r.Body})
+		if enc := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); enc != "" && enc != "identity" {
+			codec, ok := Lookup(enc)
+			if !ok {
+				c.OnError(w, r, fmt.Errorf("%w: %s", ErrUnsupportedEncoding, enc))
+				return
+			}
+			var cr io.ReadCloser
+			var err error
+			if lc, ok := codec.(LimitedCodec); ok && c.MaxDecodedSize > 0 {
+				cr, err = lc.NewLimitedReader(wire, c.MaxDecodedSize)
+			} else {
+				cr, err = codec.NewReader(wire)
+			}
 			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
+				if !errors.Is(err, ErrTooLarge) {
+					err = fmt.Errorf("%w: %s: %v", ErrMalformed, enc, err)
+				}
+				c.OnError(w, r, err)
 				return
 			}
-			r.Body = cr
 			defer cr.Close()
+			body = struct {
+				io.Reader
+				io.Closer
+			}{&guardReader{r: cr, wire: wire, max: c.MaxDecodedSize, ratio: c.MaxRatio}, r.Body}
+			r.Header.Del("Content-Encoding")
+			r.Header.Del("Content-Length")
+			r.ContentLength = -1
 		}
-		next.ServeHTTP(w, r)
+		r.Body = body
+		w.Header().Add("Vary", "Accept-Encoding")
+		codec, ok := Lookup(Negotiate(r.Header.Get("Accept-Encoding"), c.Encodings))
+		if r.Method == http.MethodHead || !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, c: c, codec: codec}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
 	})
 }
+
+// Структура читателя тела запроса в том виде, в котором оно пришло, считает прочитанные байты и ограничивает их
+// количество
+type limitReader struct {
+	r   io.Reader
+	n   int64
+	max int64
+}
+
+// Read - читает тело, но не больше max байт
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.max > 0 && l.n >= l.max {
+		// тело может закончиться ровно на ограничении, это проверяется чтением одного байта
+		var b [1]byte
+		if k, _ := l.r.Read(b[:]); k > 0 {
+			return 0, ErrTooLarge
+		}
+		return 0, io.EOF
+	}
+	if l.max > 0 && int64(len(p)) > l.max-l.n {
+		p = p[:l.max-l.n]
+	}
+	k, err := l.r.Read(p)
+	l.n += int64(k)
+	return k, err
+}
+
+// Структура читателя распакованного тела, ограничивает его размер и степень сжатия
+type guardReader struct {
+	r     io.Reader
+	wire  *limitReader
+	n     int64
+	max   int64
+	ratio int64
+}
+
+// Read - читает распакованное тело и возвращает ErrTooLarge, если ограничение размера или степени сжатия превышено
+func (g *guardReader) Read(p []byte) (int, error) {
+	k, err := g.r.Read(p)
+	g.n += int64(k)
+	if g.max > 0 && g.n > g.max {
+		return 0, fmt.Errorf("%w: decoded size exceeds %d bytes", ErrTooLarge, g.max)
+	}
+	if g.ratio > 0 && g.n > ratioFloor && g.n > g.ratio*g.wire.n {
+		return 0, fmt.Errorf("%w: compression ratio exceeds %d", ErrTooLarge, g.ratio)
+	}
+	return k, err
+}
+
+// Структура ответа, который решает о сжатии по заголовкам и размеру тела. Тело накапливается в буфере, пока
+// не станет ясно, что оно не меньше минимального размера
+type compressWriter struct {
+	http.ResponseWriter
+	c       *Compressor
+	status  int
+	codec   Codec
+	buf     bytes.Buffer
+	enc     io.WriteCloser
+	decided bool
+}
+
+// WriteHeader - откладывает отправку статуса до решения о сжатии
+func (w *compressWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+// Write - накапливает тело до минимального размера, затем начинает сжатый или обычный ответ
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.decided {
+		if w.enc != nil {
+			return w.enc.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+	if !w.eligible() {
+		w.start(false)
+		return w.ResponseWriter.Write(b)
+	}
+	w.buf.Write(b)
+	if w.buf.Len() >= w.c.MinSize {
+		if err := w.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// Функция проверяет по статусу и заголовкам, что ответ можно сжимать
+func (w *compressWriter) eligible() bool {
+	h := w.Header()
+	return w.status != http.StatusNoContent && w.status != http.StatusNotModified &&
+		h.Get("Content-Encoding") == "" && w.c.compressible(h.Get("Content-Type"))
+}
+
+// Функция отправляет статус и накопленное тело, при сжатии заголовки ответа меняются на заголовки сжатого ответа
+func (w *compressWriter) start(compress bool) error {
+	w.decided = true
+	if compress {
+		w.Header().Set("Content-Encoding", w.codec.Name())
+		w.Header().Del("Content-Length")
+		w.enc = w.codec.NewWriter(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	var err error
+	if w.enc != nil {
+		_, err = w.enc.Write(w.buf.Bytes())
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf.Bytes())
+	}
+	w.buf.Reset()
+	return err
+}
+
+// Close - завершает ответ: тело меньше минимального размера отправляется без сжатия
+func (w *compressWriter) Close() error {
+	if !w.decided {
+		if w.status == 0 {
+			return nil
+		}
+		if err := w.start(false); err != nil {
+			return err
+		}
+	}
+	if w.enc != nil {
+		return w.enc.Close()
+	}
+	return nil
+}
+
+// Unwrap - возвращает исходный ответ для http.ResponseController
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
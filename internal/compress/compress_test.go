@@ -0,0 +1,118 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{accept: "", want: ""},
+		{accept: "gzip", want: "gzip"},
+		{accept: "GZIP;q=0.5, deflate", want: "gzip"},
+		{accept: "gzip;q=0", want: ""},
+		{accept: "*", want: "gzip"},
+		{accept: "*;q=0.3, gzip;q=0", want: ""},
+		{accept: "br, identity", want: ""},
+		{accept: "gzip;q=bad", want: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.accept, func(t *testing.T) {
+			assert.Equal(t, test.want, Negotiate(test.accept, []string{"gzip"}))
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	long := strings.Repeat("a", DefaultMinSize)
+	tests := []struct {
+		name        string
+		accept      string
+		contentType string
+		status      int
+		body        string
+		gzipped     bool
+	}{
+		{name: "json", accept: "gzip", contentType: "application/json", status: http.StatusOK, body: long, gzipped: true},
+		{name: "problem", accept: "gzip", contentType: "application/problem+json", status: http.StatusNotFound, body: long, gzipped: true},
+		{name: "small body", accept: "gzip", contentType: "application/json", status: http.StatusOK, body: "{}", gzipped: false},
+		{name: "image", accept: "gzip", contentType: "image/png", status: http.StatusOK, body: long, gzipped: false},
+		{name: "refused", accept: "gzip;q=0", contentType: "text/html", status: http.StatusOK, body: long, gzipped: false},
+		{name: "no content", accept: "gzip", contentType: "application/json", status: http.StatusNoContent, gzipped: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := CompressHandle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", test.contentType)
+				w.WriteHeader(test.status)
+				// тело пишется частями, решение о сжатии принимается по общему размеру
+				io.WriteString(w, test.body[:len(test.body)/2])
+				io.WriteString(w, test.body[len(test.body)/2:])
+			}))
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.Header.Set("Accept-Encoding", test.accept)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, request)
+			resp := w.Result()
+			defer resp.Body.Close()
+
+			assert.Equal(t, test.status, resp.StatusCode)
+			assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+			body := resp.Body.(io.Reader)
+			if test.gzipped {
+				require.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+				gz, err := gzip.NewReader(resp.Body)
+				require.NoError(t, err)
+				body = gz
+			} else {
+				assert.Empty(t, resp.Header.Get("Content-Encoding"))
+			}
+			got, err := io.ReadAll(body)
+			require.NoError(t, err)
+			assert.Equal(t, test.body, string(got))
+		})
+	}
+}
+
+func TestRequestDecoding(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(`{"url":"http://ya.ru/"}`))
+	gz.Close()
+	h := CompressHandle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Empty(t, r.Header.Get("Content-Encoding"))
+		w.Write(body)
+	}))
+
+	// сжатое тело распаковывается независимо от типа содержимого
+	request := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(buf.Bytes()))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, request)
+	assert.Equal(t, `{"url":"http://ya.ru/"}`, w.Body.String())
+
+	request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("plain"))
+	request.Header.Set("Content-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, request)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("plain"))
+	request.Header.Set("Content-Encoding", "compress")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, request)
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+}
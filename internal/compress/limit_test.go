@@ -0,0 +1,155 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Функция собирает gzip бомбу: size нулевых байт, которые сжимаются примерно в тысячу раз
+func gzipBomb(t *testing.T, size int) []byte {
+	var buf bytes.Buffer
+	gz, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	require.NoError(t, err)
+	zeros := make([]byte, 64<<10)
+	for size > 0 {
+		n := min(size, len(zeros))
+		gz.Write(zeros[:n])
+		size -= n
+	}
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+// Функция сжимает size случайных байт из двух символов, они сжимаются примерно в семь раз, поэтому ограничение
+// степени сжатия на них не срабатывает
+func gzipText(t *testing.T, size int) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	rnd := rand.New(rand.NewSource(1))
+	text := make([]byte, size)
+	for i := range text {
+		text[i] = 'a' + byte(rnd.Intn(2))
+	}
+	gz.Write(text)
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+// Кадр zstd с несжатым блоком hello, в заголовке которого объявлено окно 128 МБ. Декодер с ограничениями
+// по умолчанию выделил бы под окно память, хотя тело занимает несколько байт
+var zstdWindowFrame = []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 0x88, 0x29, 0x00, 0x00, 'h', 'e', 'l', 'l', 'o'}
+
+func TestLimits(t *testing.T) {
+	// хандлер читает тело целиком, как это делают хандлеры сервиса
+	var readErr error
+	c := New()
+	c.MaxDecodedSize = 2 << 20
+	h := c.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, readErr = io.ReadAll(r.Body)
+		if readErr != nil {
+			http.Error(w, readErr.Error(), StatusFor(readErr))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	send := func(body []byte, encoding string, chunked bool) int {
+		readErr = nil
+		var reader io.Reader = bytes.NewReader(body)
+		if chunked {
+			// без Content-Length размер тела становится известен только при чтении
+			reader = io.MultiReader(reader)
+		}
+		request := httptest.NewRequest(http.MethodPost, "/", reader)
+		if chunked {
+			request.ContentLength = -1
+		}
+		request.Header.Set("Content-Encoding", encoding)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, request)
+		return w.Code
+	}
+
+	tests := []struct {
+		name     string
+		body     []byte
+		encoding string
+		chunked  bool
+		want     int
+		reason   string
+	}{
+		{name: "small gzip", body: gzipBomb(t, 32<<10), encoding: "gzip", want: http.StatusOK},
+		{name: "text under decoded limit", body: gzipText(t, 1<<20), encoding: "gzip", want: http.StatusOK},
+		{name: "text over decoded limit", body: gzipText(t, 3<<20), encoding: "gzip", want: http.StatusRequestEntityTooLarge, reason: "decoded size"},
+		{name: "bomb over ratio", body: gzipBomb(t, 4<<20), encoding: "gzip", want: http.StatusRequestEntityTooLarge, reason: "compression ratio"},
+		{name: "zstd window over decoded limit", body: zstdWindowFrame, encoding: "zstd", want: http.StatusRequestEntityTooLarge},
+		{name: "plain over wire limit", body: make([]byte, DefaultMaxBodySize+1), want: http.StatusRequestEntityTooLarge},
+		{name: "chunked over wire limit", body: make([]byte, DefaultMaxBodySize+1), chunked: true, want: http.StatusRequestEntityTooLarge},
+		{name: "plain at wire limit", body: make([]byte, DefaultMaxBodySize), chunked: true, want: http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, send(test.body, test.encoding, test.chunked))
+			if test.want == http.StatusRequestEntityTooLarge && readErr != nil {
+				assert.True(t, errors.Is(readErr, ErrTooLarge))
+				assert.Contains(t, readErr.Error(), test.reason)
+			}
+		})
+	}
+}
+
+func TestLimitsDisabled(t *testing.T) {
+	c := New()
+	c.MaxDecodedSize, c.MaxRatio = 0, 0
+	var n int64
+	h := c.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ = io.Copy(io.Discard, r.Body)
+	}))
+	request := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(gzipBomb(t, 16<<20)))
+	request.Header.Set("Content-Encoding", "gzip")
+	h.ServeHTTP(httptest.NewRecorder(), request)
+	assert.Equal(t, int64(16<<20), n)
+
+	request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("x"))
+	request.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, request)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestZstdWindowLimit(t *testing.T) {
+	// без ограничения кадр распаковывается, значит ответ 413 вызван только объявленным окном
+	d, err := zstd.NewReader(bytes.NewReader(zstdWindowFrame))
+	require.NoError(t, err)
+	data, err := io.ReadAll(d)
+	d.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	codec, ok := Lookup("zstd")
+	require.True(t, ok)
+	r, err := codec.(LimitedCodec).NewLimitedReader(bytes.NewReader(zstdWindowFrame), 1<<20)
+	if err == nil {
+		_, err = io.ReadAll(r)
+		r.Close()
+	}
+	assert.ErrorIs(t, err, ErrTooLarge)
+
+	// окно меньше ограничения не мешает распаковке
+	r, err = codec.(LimitedCodec).NewLimitedReader(bytes.NewReader(zstdWindowFrame), 256<<20)
+	require.NoError(t, err)
+	data, err = io.ReadAll(r)
+	r.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+}
@@ -0,0 +1,306 @@
+package logger
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/h1067675/shortUrl/internal/respwriter"
+)
+
+// Форматы журнала доступа NCSA в нотации директив Apache
+const (
+	CommonFormat   = `%h %l %u %t "%r" %>s %b`
+	CombinedFormat = `%h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-Agent}i"`
+)
+
+// Структура настроек журнала доступа. Format - это common, combined или шаблон из директив Apache: %h адрес
+// клиента, %l и %u всегда -, %t время запроса, %r строка запроса, %s и %>s статус, %b и %B размер ответа, %D и %T
+// время выполнения в микросекундах и секундах, %m метод, %U путь, %q параметры запроса, %H протокол,
+// %{Name}i и %{Name}o заголовки запроса и ответа, %% знак процента. TrustedProxies - адреса и подсети прокси,
+// которым доверяется адрес клиента из заголовков X-Forwarded-For и X-Real-IP
+type AccessConfig struct {
+	Output         string
+	Format         string
+	Rotate         Rotate
+	TrustedProxies []string
+}
+
+// Элемент шаблона журнала доступа: текст или директива с необязательным аргументом
+type accessPart struct {
+	text string
+	verb byte
+	arg  string
+}
+
+// AccessLog - журнал доступа, каждый запрос записывается одной строкой по шаблону
+type AccessLog struct {
+	mu      sync.Mutex
+	w       io.Writer
+	closer  io.Closer
+	parts   []accessPart
+	trusted []*net.IPNet
+	now     func() time.Time
+}
+
+// NewAccessLog - открывает вывод журнала доступа и разбирает его шаблон. Файлы открываются на дозапись
+// и ротируются по настройкам Rotate
+func NewAccessLog(cfg AccessConfig) (*AccessLog, error) {
+	parts, err := parseAccessFormat(cfg.Format)
+	if err != nil {
+		return nil, err
+	}
+	trusted, err := ParseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Output == "" {
+		cfg.Output = "stdout"
+	}
+	ws, closer, err := open(cfg.Output, cfg.Rotate)
+	if err != nil {
+		return nil, err
+	}
+	var r = AccessLog{w: ws, closer: closer, parts: parts, trusted: trusted, now: time.Now}
+	return &r, nil
+}
+
+// Close - закрывает файл журнала доступа
+func (a *AccessLog) Close() error {
+	if a.closer == nil {
+		return nil
+	}
+	return a.closer.Close()
+}
+
+// ParseTrustedProxies - разбирает список адресов и подсетей доверенных прокси
+func ParseTrustedProxies(list []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, errors.New("incorrect trusted proxy " + s)
+			}
+			bits := 8 * len(ip.To4())
+			if bits == 0 {
+				bits = 8 * net.IPv6len
+			}
+			s += "/" + strconv.Itoa(bits)
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, errors.New("incorrect trusted proxy " + s)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// Функция разбирает шаблон журнала доступа
+func parseAccessFormat(format string) ([]accessPart, error) {
+	switch format {
+	case "", "combined":
+		format = CombinedFormat
+	case "common":
+		format = CommonFormat
+	}
+	var parts []accessPart
+	var text strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			text.WriteByte(format[i])
+			continue
+		}
+		i++
+		// модификаторы < и > выбирают исходный или итоговый запрос, здесь запрос один
+		for i < len(format) && (format[i] == '>' || format[i] == '<') {
+			i++
+		}
+		var arg string
+		if i < len(format) && format[i] == '{' {
+			end := strings.IndexByte(format[i:], '}')
+			if end < 0 {
+				return nil, errors.New("access log format: unclosed {")
+			}
+			arg, i = format[i+1:i+end], i+end+1
+		}
+		if i >= len(format) {
+			return nil, errors.New("access log format: trailing %")
+		}
+		verb := format[i]
+		switch verb {
+		case '%':
+			text.WriteByte('%')
+			continue
+		case 'h', 'a', 'l', 'u', 't', 'r', 's', 'b', 'B', 'D', 'T', 'm', 'U', 'q', 'H':
+		case 'i', 'o':
+			if arg == "" {
+				return nil, fmt.Errorf("access log format: %%%c requires a header name", verb)
+			}
+		default:
+			return nil, fmt.Errorf("access log format: unknown directive %%%c", verb)
+		}
+		if text.Len() > 0 {
+			parts = append(parts, accessPart{text: text.String()})
+			text.Reset()
+		}
+		parts = append(parts, accessPart{verb: verb, arg: arg})
+	}
+	if text.Len() > 0 {
+		parts = append(parts, accessPart{text: text.String()})
+	}
+	return parts, nil
+}
+
+// Функция проверяет, что адрес принадлежит одному из доверенных прокси
+func trustedIP(trusted []*net.IPNet, ip net.IP) bool {
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP - возвращает адрес клиента по доверенным прокси журнала доступа
+func (a *AccessLog) ClientIP(r *http.Request) string {
+	return ClientIP(r, a.trusted)
+}
+
+// ClientIP - возвращает адрес клиента. Если запрос пришел от доверенного прокси, то адрес берется из заголовка
+// X-Forwarded-For: это самый правый адрес, не принадлежащий доверенным прокси, а без этого заголовка из X-Real-IP
+func ClientIP(r *http.Request, trusted []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !trustedIP(trusted, ip) {
+		return host
+	}
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := net.ParseIP(strings.TrimSpace(hops[i]))
+			if hop == nil {
+				break
+			}
+			host = hop.String()
+			if !trustedIP(trusted, hop) {
+				break
+			}
+		}
+		return host
+	}
+	if real := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); real != nil {
+		return real.String()
+	}
+	return host
+}
+
+// Handler - middleware, который записывает каждый запрос в журнал доступа после ответа на него. Размер ответа
+// считается в байтах, переданных клиенту, поэтому middleware подключается перед сжатием ответов
+func (a *AccessLog) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := a.now()
+		aw := respwriter.New(w)
+		next.ServeHTTP(aw, r)
+		line := a.format(r, aw, start, a.now().Sub(start))
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if _, err := a.w.Write(line); err != nil {
+			FromContext(r.Context()).Error("Can't write access log")
+		}
+	})
+}
+
+// Функция собирает строку журнала доступа. Строка запроса и значения заголовков проходят через правила скрытия
+// чувствительных данных логгера
+func (a *AccessLog) format(r *http.Request, w *respwriter.Recorder, start time.Time, elapsed time.Duration) []byte {
+	red := CurrentRedactor()
+	var b bytes.Buffer
+	for _, p := range a.parts {
+		if p.verb == 0 {
+			b.WriteString(p.text)
+			continue
+		}
+		switch p.verb {
+		case 'h', 'a':
+			b.WriteString(a.ClientIP(r))
+		case 'l', 'u':
+			b.WriteByte('-')
+		case 't':
+			b.WriteString(start.Format("[02/Jan/2006:15:04:05 -0700]"))
+		case 'r':
+			writeEscaped(&b, r.Method+" "+red.Text(r.RequestURI)+" "+r.Proto)
+		case 's':
+			b.WriteString(strconv.Itoa(w.Status()))
+		case 'b':
+			if w.Size() == 0 {
+				b.WriteByte('-')
+			} else {
+				b.WriteString(strconv.Itoa(w.Size()))
+			}
+		case 'B':
+			b.WriteString(strconv.Itoa(w.Size()))
+		case 'D':
+			b.WriteString(strconv.FormatInt(elapsed.Microseconds(), 10))
+		case 'T':
+			b.WriteString(strconv.FormatInt(int64(elapsed/time.Second), 10))
+		case 'm':
+			writeEscaped(&b, r.Method)
+		case 'U':
+			writeEscaped(&b, r.URL.EscapedPath())
+		case 'q':
+			if r.URL.RawQuery != "" {
+				writeEscaped(&b, red.Text("?"+r.URL.RawQuery))
+			}
+		case 'H':
+			writeEscaped(&b, r.Proto)
+		case 'i', 'o':
+			h := r.Header
+			if p.verb == 'o' {
+				h = w.Header()
+			}
+			v := strings.Join(h.Values(p.arg), ", ")
+			switch {
+			case v == "":
+				b.WriteByte('-')
+			case red.sensitive(p.arg):
+				b.WriteString(Redacted)
+			default:
+				writeEscaped(&b, red.Text(v))
+			}
+		}
+	}
+	b.WriteByte('\n')
+	return b.Bytes()
+}
+
+// Функция пишет значение, экранируя кавычки, обратную косую черту и непечатные символы, как это делает Apache,
+// чтобы значение из запроса не могло разорвать строку журнала
+func writeEscaped(b *bytes.Buffer, s string) {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < ' ' || c >= 0x7f:
+			fmt.Fprintf(b, "\\x%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package logger
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessLog(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "access.log")
+	a, err := NewAccessLog(AccessConfig{Output: file, TrustedProxies: []string{"10.0.0.0/8"}})
+	require.NoError(t, err)
+	defer a.Close()
+	start := time.Date(2000, time.October, 10, 13, 55, 36, 0, time.FixedZone("", -7*3600))
+	a.now = func() time.Time { return start }
+
+	h := a.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/empty" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Write([]byte("hello"))
+	}))
+	do := func(target string, remote string, header http.Header) {
+		request := httptest.NewRequest(http.MethodGet, target, nil)
+		request.RemoteAddr = remote
+		for k, v := range header {
+			request.Header[k] = v
+		}
+		h.ServeHTTP(httptest.NewRecorder(), request)
+	}
+	do("/abc?token=t0ken&page=1", "10.1.2.3:5000", http.Header{
+		"X-Forwarded-For": {"203.0.113.7, 10.9.9.9"},
+		"Referer":         {"https://example.com/?key=k3y"},
+		"User-Agent":      {`curl/8.0 "quoted"`},
+	})
+	do("/empty", "198.51.100.1:4000", http.Header{"X-Forwarded-For": {"203.0.113.7"}})
+
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	require.Len(t, lines, 2)
+	assert.Equal(t, `203.0.113.7 - - [10/Oct/2000:13:55:36 -0700] "GET /abc?token=[REDACTED]&page=1 HTTP/1.1" 200 5 "https://example.com/?key=[REDACTED]" "curl/8.0 \"quoted\""`, lines[0])
+	// недоверенный адрес не может подменить адрес клиента заголовком
+	assert.Equal(t, `198.51.100.1 - - [10/Oct/2000:13:55:36 -0700] "GET /empty HTTP/1.1" 204 - "-" "-"`, lines[1])
+}
+
+func TestAccessFormat(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "access.log")
+	a, err := NewAccessLog(AccessConfig{Output: file, Format: `%m %U%q %>s %B %{X-Request-ID}o %{Authorization}i 100%%`})
+	require.NoError(t, err)
+	defer a.Close()
+	h := a.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-ID", "req-1")
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	request := httptest.NewRequest(http.MethodPost, "/x?password=pw", nil)
+	request.Header.Set("Authorization", "Bearer sk_secret")
+	h.ServeHTTP(httptest.NewRecorder(), request)
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "POST /x?password=[REDACTED] 404 0 req-1 [REDACTED] 100%\n", string(data))
+
+	for _, format := range []string{"%z", "%{Referer", "%i", "trailing %"} {
+		_, err := NewAccessLog(AccessConfig{Output: file, Format: format})
+		assert.Error(t, err, format)
+	}
+	_, err = NewAccessLog(AccessConfig{Output: file, TrustedProxies: []string{"not an ip"}})
+	assert.Error(t, err)
+}
+
+func TestClientIP(t *testing.T) {
+	a, err := NewAccessLog(AccessConfig{Output: "stdout", TrustedProxies: []string{"10.0.0.1", "192.168.0.0/16", "::1"}})
+	require.NoError(t, err)
+	tests := []struct {
+		remote string
+		xff    string
+		real   string
+		want   string
+	}{
+		{remote: "203.0.113.1:1", xff: "1.1.1.1", want: "203.0.113.1"},
+		{remote: "10.0.0.1:1", want: "10.0.0.1"},
+		{remote: "10.0.0.1:1", xff: "1.1.1.1", want: "1.1.1.1"},
+		{remote: "10.0.0.1:1", xff: "6.6.6.6, 1.1.1.1, 192.168.1.1", want: "1.1.1.1"},
+		{remote: "10.0.0.2:1", xff: "1.1.1.1", want: "10.0.0.2"},
+		{remote: "10.0.0.1:1", real: "2.2.2.2", want: "2.2.2.2"},
+		{remote: "[::1]:1", xff: "2001:db8::1", want: "2001:db8::1"},
+		{remote: "10.0.0.1:1", xff: "192.168.0.5", want: "192.168.0.5"},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.RemoteAddr = test.remote
+		if test.xff != "" {
+			request.Header.Set("X-Forwarded-For", test.xff)
+		}
+		if test.real != "" {
+			request.Header.Set("X-Real-IP", test.real)
+		}
+		assert.Equal(t, test.want, a.ClientIP(request), test)
+	}
+}
@@ -1,78 +1,188 @@
 package logger
 
 import (
+	"errors"
+	"io"
 	"net/http"
+	"os"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+
+	"github.com/h1067675/shortUrl/internal/respwriter"
 )
 
 var Log *zap.Logger
 
-type (
-	// берём структуру для хранения сведений об ответе
-	responseData struct {
-		status int
-		size   int
-	}
+// Структура настроек логгера. Outputs - это stdout, stderr или пути к файлам, файлы открываются на дозапись
+type Config struct {
+	Level    string
+	Encoding string
+	Outputs  []string
+	Sampling Sampling
+	Rotate   Rotate
+	Redact   Redaction
+}
 
-	// добавляем реализацию http.ResponseWriter
-	loggingResponseWriter struct {
-		http.ResponseWriter // встраиваем оригинальный http.ResponseWriter
-		responseData        *responseData
-	}
-)
+// Структура настроек выборки: из одинаковых записей за секунду пишутся первые Initial, затем каждая Thereafter.
+// Нулевой Initial отключает выборку
+type Sampling struct {
+	Initial    int
+	Thereafter int
+}
 
-func (r *loggingResponseWriter) Write(b []byte) (int, error) {
-	size, err := r.ResponseWriter.Write(b)
-	r.responseData.size += size
-	return size, err
+// Структура настроек ротации файлов лога: файл больше MaxSize мегабайт переименовывается, хранится не больше
+// MaxBackups старых файлов не старше MaxAge дней. Нулевой MaxSize отключает ротацию
+type Rotate struct {
+	MaxSize    int
+	MaxAge     int
+	MaxBackups int
+	Compress   bool
 }
 
-func (r *loggingResponseWriter) WriteHeader(statusCode int) {
-	r.ResponseWriter.WriteHeader(statusCode)
-	r.responseData.status = statusCode
+// Структура настроек скрытия чувствительных данных: заголовки и параметры запроса добавляются к скрываемым
+// по умолчанию, учетные данные из адресов удаляются, если KeepCredentials не установлен
+type Redaction struct {
+	Headers         []string
+	Params          []string
+	KeepCredentials bool
 }
 
-func Initialize(level string) error {
-	lvl, err := zap.ParseAtomicLevel(level)
+// Level - текущий уровень логгера, его можно менять во время работы
+var Level = zap.NewAtomicLevel()
+
+// Файлы, открытые логгером, закрываются при его перенастройке
+var (
+	outputsMu sync.Mutex
+	outputs   []io.Closer
+)
+
+// Setup - настраивает логгер Log по cfg. Пустые поля заменяются значениями по умолчанию: уровень info,
+// формат json, вывод в stdout. Чувствительные данные скрываются во всех записях логгера
+func Setup(cfg Config) error {
+	lvl := zapcore.InfoLevel
+	if cfg.Level != "" {
+		var err error
+		if lvl, err = zapcore.ParseLevel(cfg.Level); err != nil {
+			return err
+		}
+	}
+	encCnf := zap.NewProductionEncoderConfig()
+	encCnf.EncodeTime = zapcore.ISO8601TimeEncoder
+	var enc zapcore.Encoder
+	switch cfg.Encoding {
+	case "", "json":
+		enc = zapcore.NewJSONEncoder(encCnf)
+	case "console":
+		encCnf.EncodeLevel = zapcore.CapitalLevelEncoder
+		enc = zapcore.NewConsoleEncoder(encCnf)
+	default:
+		return errors.New("unknown log encoding " + cfg.Encoding)
+	}
+	if len(cfg.Outputs) == 0 {
+		cfg.Outputs = []string{"stdout"}
+	}
+	syncers := make([]zapcore.WriteSyncer, 0, len(cfg.Outputs))
+	var closers []io.Closer
+	for _, out := range cfg.Outputs {
+		ws, closer, err := open(out, cfg.Rotate)
+		if err != nil {
+			for _, c := range closers {
+				c.Close()
+			}
+			return err
+		}
+		syncers = append(syncers, ws)
+		if closer != nil {
+			closers = append(closers, closer)
+		}
+	}
+	red := NewRedactor(cfg.Redact.Headers, cfg.Redact.Params, !cfg.Redact.KeepCredentials)
+	var core zapcore.Core = &redactCore{Core: zapcore.NewCore(enc, zapcore.NewMultiWriteSyncer(syncers...), Level), r: red}
+	if cfg.Sampling.Initial > 0 {
+		core = zapcore.NewSamplerWithOptions(core, time.Second, cfg.Sampling.Initial, cfg.Sampling.Thereafter)
+	}
+	Level.SetLevel(lvl)
+	redactor.Store(red)
+	if Log != nil {
+		Log.Sync()
+	}
+	Log = zap.New(core, zap.AddCaller(), zap.AddStacktrace(zapcore.ErrorLevel), zap.ErrorOutput(zapcore.Lock(os.Stderr)))
+
+	outputsMu.Lock()
+	prev := outputs
+	outputs = closers
+	outputsMu.Unlock()
+	for _, c := range prev {
+		c.Close()
+	}
+	return nil
+}
+
+// Функция открывает вывод лога, для файлов возвращает их закрывающую функцию
+func open(out string, rotate Rotate) (zapcore.WriteSyncer, io.Closer, error) {
+	switch out {
+	case "stdout":
+		return zapcore.Lock(os.Stdout), nil, nil
+	case "stderr":
+		return zapcore.Lock(os.Stderr), nil, nil
+	}
+	if rotate.MaxSize > 0 {
+		l := &lumberjack.Logger{
+			Filename:   out,
+			MaxSize:    rotate.MaxSize,
+			MaxAge:     rotate.MaxAge,
+			MaxBackups: rotate.MaxBackups,
+			Compress:   rotate.Compress,
+		}
+		return zapcore.AddSync(l), l, nil
+	}
+	fl, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	cnf := zap.NewProductionConfig()
-	cnf.Level = lvl
-	cnf.OutputPaths = ([]string{"stdout"})
-	cnf.Encoding = "console"
-	zapLog, err := cnf.Build()
+	return zapcore.Lock(fl), fl, nil
+}
+
+// Initialize - настраивает логгер с уровнем level и выводом в stdout в консольном формате
+func Initialize(level string) error {
+	return Setup(Config{Level: level, Encoding: "console"})
+}
+
+// SetLevel - меняет уровень логгера во время работы
+func SetLevel(level string) error {
+	lvl, err := zapcore.ParseLevel(level)
 	if err != nil {
 		return err
 	}
-	Log = zapLog
+	Level.SetLevel(lvl)
 	return nil
 }
 
+// RequestLogger - middleware, который пишет в лог каждый запрос с его статусом, размером ответа и временем
+// выполнения. Заголовки запроса пишутся только на уровне debug. Записи содержат идентификатор запроса, если перед
+// этим middleware подключен RequestID
 func RequestLogger(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		responseData := &responseData{
-			status: 0,
-			size:   0,
-		}
-		nw := loggingResponseWriter{
-			ResponseWriter: w,
-			responseData:   responseData,
-		}
+		nw := respwriter.New(w)
 
-		next.ServeHTTP(&nw, r)
+		next.ServeHTTP(nw, r)
 
-		Log.Debug("Request headers:", zap.Any("values", r.Header))
+		log := FromContext(r.Context())
+		if ce := log.Check(zapcore.DebugLevel, "Request headers"); ce != nil {
+			ce.Write(zap.Any("values", r.Header))
+		}
 
-		Log.Debug("User request:",
-			zap.String("URL", r.RequestURI),
+		log.Info("User request",
+			zap.String("uri", r.RequestURI),
 			zap.String("method", r.Method),
-			zap.Duration("execution time", time.Since(start)),
-			zap.Int("size", nw.responseData.size),
-			zap.Int("status", nw.responseData.status))
+			zap.Duration("duration", time.Since(start)),
+			zap.Int("size", nw.Size()),
+			zap.Int("status", nw.Status()))
 
 	})
 }
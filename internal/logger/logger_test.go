@@ -0,0 +1,52 @@
+package logger
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetup(t *testing.T) {
+	defer Initialize("debug")
+	file := filepath.Join(t.TempDir(), "app.log")
+	require.NoError(t, Setup(Config{Level: "info", Outputs: []string{file}, Rotate: Rotate{MaxSize: 1, MaxBackups: 1}}))
+
+	Log.Debug("hidden")
+	Log.Info("shown")
+	require.NoError(t, SetLevel("debug"))
+	Log.Debug("debug after change")
+	assert.Error(t, SetLevel("loud"))
+	Log.Sync()
+
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, "info", entry["level"])
+	assert.Equal(t, "shown", entry["msg"])
+	assert.Contains(t, lines[1], "debug after change")
+
+	assert.Error(t, Setup(Config{Encoding: "xml"}))
+	assert.Error(t, Setup(Config{Level: "loud"}))
+}
+
+func TestSampling(t *testing.T) {
+	defer Initialize("debug")
+	file := filepath.Join(t.TempDir(), "app.log")
+	require.NoError(t, Setup(Config{Outputs: []string{file}, Sampling: Sampling{Initial: 2, Thereafter: 10}}))
+	for i := 0; i < 20; i++ {
+		Log.Info("same entry")
+	}
+	Log.Sync()
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	// пишутся первые 2 записи, затем каждая десятая: 12-я
+	assert.Equal(t, 3, strings.Count(string(data), "same entry"))
+}
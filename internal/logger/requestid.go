@@ -0,0 +1,83 @@
+package logger
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// Заголовок с идентификатором запроса
+const RequestIDHeader = "X-Request-ID"
+
+// Наибольшая длина принимаемого идентификатора запроса, более длинные идентификаторы заменяются новыми
+const maxRequestIDLen = 128
+
+// Ключ идентификатора запроса в контексте
+type requestIDKey struct{}
+
+// NewRequestID - создает случайный идентификатор запроса
+func NewRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// ValidRequestID - проверяет идентификатор запроса, полученный от клиента или прокси: он должен быть непустым,
+// не длиннее 128 символов и состоять из печатных символов ascii без пробелов, чтобы его нельзя было использовать
+// для подделки записей лога
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// WithRequestID - возвращает контекст с идентификатором запроса
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFrom - возвращает идентификатор запроса из контекста
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// FromContext - возвращает логгер, который добавляет к записям идентификатор запроса и идентификатор трассы
+// из контекста. Без них возвращается общий логгер Log
+func FromContext(ctx context.Context) *zap.Logger {
+	var fields []zap.Field
+	if id := RequestIDFrom(ctx); id != "" {
+		fields = append(fields, zap.String("request_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		fields = append(fields, zap.String("trace_id", sc.TraceID().String()), zap.String("span_id", sc.SpanID().String()))
+	}
+	if len(fields) == 0 {
+		return Log
+	}
+	return Log.With(fields...)
+}
+
+// RequestID - middleware, который берет идентификатор запроса из заголовка X-Request-ID или создает новый,
+// сохраняет его в контексте запроса и возвращает в заголовке ответа
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !ValidRequestID(id) {
+			id = NewRequestID()
+			r.Header.Set(RequestIDHeader, id)
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), id)))
+	})
+}
@@ -0,0 +1,54 @@
+package logger
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRequestID(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	prev := Log
+	Log = zap.New(core)
+	defer func() { Log = prev }()
+
+	var seen string
+	h := RequestID(RequestLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestIDFrom(r.Context())
+		FromContext(r.Context()).Info("handler")
+		w.WriteHeader(http.StatusNoContent)
+	})))
+	do := func(id string) string {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		if id != "" {
+			request.Header.Set(RequestIDHeader, id)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, request)
+		return w.Header().Get(RequestIDHeader)
+	}
+
+	// идентификатор от прокси передается дальше как есть
+	assert.Equal(t, "proxy-42", do("proxy-42"))
+	assert.Equal(t, "proxy-42", seen)
+	entries := logs.TakeAll()
+	assert.NotEmpty(t, entries)
+	for _, e := range entries {
+		assert.Equal(t, "proxy-42", e.ContextMap()["request_id"], e.Message)
+	}
+
+	// без идентификатора и с недопустимым идентификатором создается новый
+	for _, id := range []string{"", "bad id", "line\nbreak", strings.Repeat("x", 129)} {
+		got := do(id)
+		assert.Len(t, got, 32)
+		assert.Equal(t, got, seen)
+	}
+	assert.NotEqual(t, do(""), do(""))
+
+	assert.Equal(t, Log, FromContext(httptest.NewRequest(http.MethodGet, "/", nil).Context()))
+}
@@ -0,0 +1,139 @@
+package policy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/h1067675/shortUrl/internal/logger"
+)
+
+// ErrBlocked - ссылка запрещена политикой, причина содержится в тексте обернутой ошибки
+var ErrBlocked = errors.New("destination is blocked")
+
+// Структура политики запрещенных адресов: список доменов (запрещаются вместе с поддоменами) и список регулярных
+// выражений, которые проверяются на всей ссылке
+type Policy struct {
+	mu       sync.RWMutex
+	domains  map[string]bool
+	patterns []*regexp.Regexp
+	modTime  time.Time
+}
+
+// Функция создает пустую политику, которая ничего не запрещает
+func New() *Policy {
+	var r = Policy{domains: map[string]bool{}}
+	return &r
+}
+
+// Check - проверяет ссылку по спискам запрещенных доменов и выражений
+func (p *Policy) Check(link string) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if u, err := url.Parse(link); err == nil {
+		host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+		for host != "" {
+			if p.domains[host] {
+				return fmt.Errorf("%w: domain %s is denylisted", ErrBlocked, host)
+			}
+			_, host, _ = strings.Cut(host, ".")
+		}
+	}
+	for _, re := range p.patterns {
+		if re.MatchString(link) {
+			return fmt.Errorf("%w: matches %s", ErrBlocked, re.String())
+		}
+	}
+	return nil
+}
+
+// Parse - разбирает списки политики: по одному правилу в строке, правило с префиксом "re:" является регулярным
+// выражением, остальные правила являются доменами, пустые строки и строки с "#" пропускаются
+func Parse(text string) (map[string]bool, []*regexp.Regexp, error) {
+	domains := map[string]bool{}
+	var patterns []*regexp.Regexp
+	sc := bufio.NewScanner(strings.NewReader(text))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if expr, ok := strings.CutPrefix(line, "re:"); ok {
+			re, err := regexp.Compile(strings.TrimSpace(expr))
+			if err != nil {
+				return nil, nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			patterns = append(patterns, re)
+			continue
+		}
+		domains[strings.TrimSuffix(strings.ToLower(line), ".")] = true
+	}
+	return domains, patterns, sc.Err()
+}
+
+// LoadFromFile - заменяет списки политики содержимым файла, отсутствие файла не является ошибкой. При ошибке
+// разбора действующие списки не меняются
+func (p *Policy) LoadFromFile(file string) error {
+	if file == "" {
+		return nil
+	}
+	st, err := os.Stat(file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	bt, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	domains, patterns, err := Parse(string(bt))
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.domains, p.patterns, p.modTime = domains, patterns, st.ModTime()
+	return nil
+}
+
+// Watch - раз в interval проверяет время изменения файла и перечитывает политику, если файл изменился.
+// Ошибки перечитывания логируются, при этом продолжает действовать прежняя политика
+func (p *Policy) Watch(file string, interval time.Duration, stop <-chan struct{}) {
+	if file == "" {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			st, err := os.Stat(file)
+			if err != nil {
+				continue
+			}
+			p.mu.RLock()
+			changed := !st.ModTime().Equal(p.modTime)
+			p.mu.RUnlock()
+			if !changed {
+				continue
+			}
+			if err := p.LoadFromFile(file); err != nil {
+				logger.Log.Error("Can't reload policy", zap.String("file", file), zap.Error(err))
+				continue
+			}
+			logger.Log.Info("Policy reloaded", zap.String("file", file))
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package policy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/h1067675/shortUrl/internal/logger"
+)
+
+func TestCheck(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "policy.txt")
+	require.NoError(t, os.WriteFile(file, []byte("# phishing\nevil.example\nre:^https?://[^/]*paypa1\n"), 0o644))
+	p := New()
+	require.NoError(t, p.LoadFromFile(file))
+
+	tests := []struct {
+		url     string
+		blocked bool
+	}{
+		{url: "http://evil.example/login", blocked: true},
+		{url: "https://login.EVIL.example./", blocked: true},
+		{url: "https://notevil.example/", blocked: false},
+		{url: "http://www.paypa1.com/", blocked: true},
+		{url: "http://ya.ru/?q=paypa1", blocked: false},
+	}
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			assert.Equal(t, test.blocked, errors.Is(p.Check(test.url), ErrBlocked))
+		})
+	}
+
+	// ошибка разбора не сбрасывает действующую политику
+	require.NoError(t, os.WriteFile(file, []byte("re:(\n"), 0o644))
+	assert.Error(t, p.LoadFromFile(file))
+	assert.ErrorIs(t, p.Check("http://evil.example/"), ErrBlocked)
+}
+
+func TestWatch(t *testing.T) {
+	logger.Initialize("debug")
+	file := filepath.Join(t.TempDir(), "policy.txt")
+	require.NoError(t, os.WriteFile(file, []byte("evil.example\n"), 0o644))
+	p := New()
+	require.NoError(t, p.LoadFromFile(file))
+	stop := make(chan struct{})
+	defer close(stop)
+	go p.Watch(file, 10*time.Millisecond, stop)
+
+	require.NoError(t, os.WriteFile(file, []byte("other.example\n"), 0o644))
+	require.NoError(t, os.Chtimes(file, time.Now(), time.Now().Add(time.Second)))
+	assert.Eventually(t, func() bool { return p.Check("http://other.example/") != nil }, time.Second, 10*time.Millisecond)
+	assert.NoError(t, p.Check("http://evil.example/"))
+}
@@ -0,0 +1,154 @@
+// Package qr - изображения QR кодов коротких ссылок в форматах PNG и SVG
+package qr
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// Форматы изображений QR кода
+const (
+	FormatPNG = "png"
+	FormatSVG = "svg"
+)
+
+// Параметры изображения по умолчанию и их ограничения. Размер задается в пикселях, поле - в модулях кода
+const (
+	DefaultSize   = 256
+	MaxSize       = 2048
+	DefaultMargin = 4
+	MaxMargin     = 16
+	DefaultLevel  = "M"
+)
+
+// ErrInvalidOptions - параметры изображения некорректны, конкретная причина содержится в тексте обернутой ошибки
+var ErrInvalidOptions = errors.New("invalid qr code options")
+
+// Уровни коррекции ошибок: L восстанавливает 7% кода, M - 15%, Q - 25%, H - 30%
+var levels = map[string]qrcode.RecoveryLevel{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.High,
+	"H": qrcode.Highest,
+}
+
+// Структура параметров изображения: формат, ширина и высота в пикселях, ширина пустого поля вокруг кода
+// в модулях и уровень коррекции ошибок
+type Options struct {
+	Format string
+	Size   int
+	Margin int
+	Level  string
+}
+
+// DefaultOptions - возвращает параметры изображения по умолчанию
+func DefaultOptions() Options {
+	return Options{Format: FormatPNG, Size: DefaultSize, Margin: DefaultMargin, Level: DefaultLevel}
+}
+
+// Структура готового изображения QR кода
+type Image struct {
+	ContentType string
+	Data        []byte
+}
+
+// DataURI - возвращает изображение в виде data: ссылки, которую можно вставить в страницу или документ
+func (i Image) DataURI() string {
+	return "data:" + i.ContentType + ";base64," + base64.StdEncoding.EncodeToString(i.Data)
+}
+
+// Функция возвращает ошибку проверки параметров с причиной
+func invalid(format string, a ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidOptions, fmt.Sprintf(format, a...))
+}
+
+// Encode - строит QR код содержимого и рисует его изображение по параметрам. Код с полем вписывается в размер
+// изображения целым числом пикселей на модуль, оставшиеся пиксели добавляются к полю
+func Encode(content string, o Options) (Image, error) {
+	level, ok := levels[strings.ToUpper(o.Level)]
+	if !ok {
+		return Image{}, invalid("level must be one of L, M, Q, H")
+	}
+	if o.Format != FormatPNG && o.Format != FormatSVG {
+		return Image{}, invalid("format must be png or svg")
+	}
+	if o.Size < 1 || o.Size > MaxSize {
+		return Image{}, invalid("size must be between 1 and %d", MaxSize)
+	}
+	if o.Margin < 0 || o.Margin > MaxMargin {
+		return Image{}, invalid("margin must be between 0 and %d", MaxMargin)
+	}
+	code, err := qrcode.New(content, level)
+	if err != nil {
+		return Image{}, err
+	}
+	code.DisableBorder = true
+	bitmap := code.Bitmap()
+	modules := len(bitmap) + 2*o.Margin
+	if o.Size < modules {
+		return Image{}, invalid("size must be at least %d for this code", modules)
+	}
+	if o.Format == FormatSVG {
+		return Image{ContentType: "image/svg+xml", Data: drawSVG(bitmap, o)}, nil
+	}
+	data, err := drawPNG(bitmap, o)
+	if err != nil {
+		return Image{}, err
+	}
+	return Image{ContentType: "image/png", Data: data}, nil
+}
+
+// Функция рисует двухцветное изображение PNG
+func drawPNG(bitmap [][]bool, o Options) ([]byte, error) {
+	img := image.NewPaletted(image.Rect(0, 0, o.Size, o.Size), color.Palette{color.White, color.Black})
+	scale := o.Size / (len(bitmap) + 2*o.Margin)
+	offset := (o.Size-scale*(len(bitmap)+2*o.Margin))/2 + scale*o.Margin
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if !dark {
+				continue
+			}
+			for py := offset + y*scale; py < offset+(y+1)*scale; py++ {
+				start := img.PixOffset(offset+x*scale, py)
+				for i := 0; i < scale; i++ {
+					img.Pix[start+i] = 1
+				}
+			}
+		}
+	}
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// Функция рисует изображение SVG, темные модули каждой строки объединяются в горизонтальные полосы
+func drawSVG(bitmap [][]bool, o Options) []byte {
+	modules := len(bitmap) + 2*o.Margin
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, o.Size, o.Size, modules, modules)
+	b.WriteString(`<rect width="100%" height="100%" fill="#fff"/><path fill="#000" d="`)
+	for y, row := range bitmap {
+		for x := 0; x < len(row); x++ {
+			if !row[x] {
+				continue
+			}
+			start := x
+			for x < len(row) && row[x] {
+				x++
+			}
+			fmt.Fprintf(&b, "M%d %dh%dv1h-%dz", start+o.Margin, y+o.Margin, x-start, x-start)
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return b.Bytes()
+}
@@ -0,0 +1,68 @@
+package qr
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodePNG(t *testing.T) {
+	img, err := Encode("http://localhost:8080/aaaaaaaa", DefaultOptions())
+	require.NoError(t, err)
+	assert.Equal(t, "image/png", img.ContentType)
+	decoded, err := png.Decode(bytes.NewReader(img.Data))
+	require.NoError(t, err)
+	assert.Equal(t, DefaultSize, decoded.Bounds().Dx())
+	assert.Equal(t, DefaultSize, decoded.Bounds().Dy())
+
+	// код версии 3 занимает 29 модулей, с полем 4 модуля - 37 модулей по 6 пикселей, остаток 34 пикселя
+	// делится между полями поровну, левый верхний угол кода начинается с поискового узора
+	white := color.GrayModel.Convert(color.White)
+	black := color.GrayModel.Convert(color.Black)
+	assert.Equal(t, white, color.GrayModel.Convert(decoded.At(0, 0)))
+	assert.Equal(t, white, color.GrayModel.Convert(decoded.At(17+24-1, 17+24-1)))
+	assert.Equal(t, black, color.GrayModel.Convert(decoded.At(17+24, 17+24)))
+	assert.Equal(t, white, color.GrayModel.Convert(decoded.At(DefaultSize-1, DefaultSize-1)))
+
+	// с уровнем L тот же код помещается в версию 2 из 25 модулей, без поля он начинается с первого пикселя
+	img, err = Encode("http://localhost:8080/aaaaaaaa", Options{Format: FormatPNG, Size: 25, Margin: 0, Level: "l"})
+	require.NoError(t, err)
+	decoded, err = png.Decode(bytes.NewReader(img.Data))
+	require.NoError(t, err)
+	assert.Equal(t, black, color.GrayModel.Convert(decoded.At(0, 0)))
+}
+
+func TestEncodeSVG(t *testing.T) {
+	img, err := Encode("http://localhost:8080/aaaaaaaa", Options{Format: FormatSVG, Size: 512, Margin: 2, Level: "H"})
+	require.NoError(t, err)
+	assert.Equal(t, "image/svg+xml", img.ContentType)
+	svg := string(img.Data)
+	assert.True(t, strings.HasPrefix(svg, "<svg "))
+	assert.Contains(t, svg, `width="512" height="512"`)
+	assert.Contains(t, svg, `d="M2 2h7v1h-7z`)
+	assert.True(t, strings.HasPrefix(img.DataURI(), "data:image/svg+xml;base64,"))
+}
+
+func TestEncodeOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{name: "format", opts: Options{Format: "gif", Size: 256, Margin: 4, Level: "M"}},
+		{name: "level", opts: Options{Format: FormatPNG, Size: 256, Margin: 4, Level: "X"}},
+		{name: "size", opts: Options{Format: FormatPNG, Size: MaxSize + 1, Margin: 4, Level: "M"}},
+		{name: "margin", opts: Options{Format: FormatPNG, Size: 256, Margin: -1, Level: "M"}},
+		{name: "too small", opts: Options{Format: FormatSVG, Size: 20, Margin: 4, Level: "M"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Encode("http://localhost:8080/aaaaaaaa", test.opts)
+			assert.ErrorIs(t, err, ErrInvalidOptions)
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Интервал, с которым из памяти удаляются корзины давно не обращавшихся клиентов
+const sweepInterval = time.Minute
+
+// Структура корзины токенов одного клиента
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Структура ограничителя частоты запросов по алгоритму token bucket. У каждого клиента своя корзина емкостью burst,
+// которая пополняется со скоростью rate токенов в секунду, каждый запрос забирает один токен
+type Limiter struct {
+	mu        sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// Функция создает ограничитель на rate запросов в секунду с допустимым всплеском burst запросов
+func New(rate float64, burst int) *Limiter {
+	var r = Limiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+	return &r
+}
+
+// Allow - забирает по токену из корзин всех ключей клиента, например его идентификатора и адреса. Запрос проходит,
+// только если токен есть в каждой корзине, иначе токены не забираются и возвращается false и время, через которое
+// токены появятся во всех корзинах
+func (l *Limiter) Allow(keys ...string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.sweep(now)
+	var wait time.Duration
+	buckets := make([]*bucket, 0, len(keys))
+	for _, key := range keys {
+		b, ok := l.buckets[key]
+		if !ok {
+			b = &bucket{tokens: l.burst, last: now}
+			l.buckets[key] = b
+		}
+		b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+		b.last = now
+		if b.tokens < 1 {
+			wait = max(wait, time.Duration((1-b.tokens)/l.rate*float64(time.Second)))
+		}
+		buckets = append(buckets, b)
+	}
+	if wait > 0 {
+		return false, wait
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return true, 0
+}
+
+// Функция удаляет корзины, которые успели заполниться полностью, они ничем не отличаются от новых
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for k, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, k)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllow(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := New(2, 3)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		ok, _ := l.Allow("a")
+		assert.True(t, ok)
+	}
+	ok, wait := l.Allow("a")
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	// у других клиентов свои корзины
+	ok, _ = l.Allow("b")
+	assert.True(t, ok)
+
+	now = now.Add(500 * time.Millisecond)
+	ok, _ = l.Allow("a")
+	assert.True(t, ok)
+	ok, _ = l.Allow("a")
+	assert.False(t, ok)
+
+	// запрос с несколькими ключами проходит, только если токен есть во всех корзинах, и тогда забирает токены из каждой
+	ok, _ = l.Allow("b", "a")
+	assert.False(t, ok)
+	ok, _ = l.Allow("b", "d")
+	assert.True(t, ok)
+	assert.Equal(t, float64(2), l.buckets["b"].tokens)
+	assert.Equal(t, float64(2), l.buckets["d"].tokens)
+
+	// заполнившиеся корзины удаляются из памяти
+	now = now.Add(2 * time.Minute)
+	l.Allow("c")
+	assert.Len(t, l.buckets, 1)
+}
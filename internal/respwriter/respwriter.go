@@ -0,0 +1,53 @@
+// Package respwriter - обертка ответа http, которая запоминает статус и размер тела ответа для журналов и трассировки
+package respwriter
+
+import "net/http"
+
+// Recorder - ответ, который запоминает первый записанный статус и считает байты тела, переданные исходному ответу
+type Recorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+// New - оборачивает ответ
+func New(w http.ResponseWriter) *Recorder {
+	var r = Recorder{ResponseWriter: w}
+	return &r
+}
+
+// WriteHeader - запоминает статус ответа
+func (r *Recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write - считает размер тела ответа, запись без явного WriteHeader отправляет статус 200
+func (r *Recorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.size += n
+	return n, err
+}
+
+// Unwrap - возвращает исходный ответ для http.ResponseController
+func (r *Recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Status - возвращает статус ответа. Если хандлер ничего не записал, net/http отправит статус 200
+func (r *Recorder) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+// Size - возвращает количество байт тела, переданных исходному ответу
+func (r *Recorder) Size() int {
+	return r.size
+}
@@ -0,0 +1,43 @@
+package respwriter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecorder(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		status  int
+		size    int
+	}{
+		{name: "empty", handler: func(w http.ResponseWriter, r *http.Request) {}, status: http.StatusOK},
+		{name: "write without header", handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("hello"))
+		}, status: http.StatusOK, size: 5},
+		{name: "explicit status", handler: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("missing"))
+			w.Write([]byte("!"))
+		}, status: http.StatusNotFound, size: 8},
+		{name: "first status wins", handler: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+			w.WriteHeader(http.StatusInternalServerError)
+		}, status: http.StatusCreated},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			rec := New(w)
+			test.handler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			assert.Equal(t, test.status, rec.Status())
+			assert.Equal(t, test.size, rec.Size())
+			assert.Equal(t, test.size, w.Body.Len())
+			assert.Same(t, w, http.ResponseWriter(rec.Unwrap()))
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package tenant
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/h1067675/shortUrl/internal/apikey"
+)
+
+// Структура описывающая арендатора: название, список собственных доменов и ключ доступа к ним. Первый домен
+// из списка используется по умолчанию при сокращении ссылок по ключу арендатора. Ключ читается только из файла
+// арендаторов и переносится в хранилище ключей api, в реестре он не хранится. Домены арендатора с Private или
+// с ключом в настройках доступны только по ключу арендатора, даже если все его ключи отозваны
+type Tenant struct {
+	Name    string   `json:"name"`
+	Domains []string `json:"domains"`
+	APIKey  string   `json:"api_key"`
+	Private bool     `json:"private,omitempty"`
+}
+
+// Ошибки реестра арендаторов
+var (
+	ErrIncorrectTenant = errors.New("incorrect tenant")
+	ErrDomainExists    = errors.New("domain already registered")
+)
+
+// Структура реестра арендаторов с индексами по домену и по названию
+type Registry struct {
+	mu       sync.RWMutex
+	byDomain map[string]*Tenant
+	byName   map[string]*Tenant
+}
+
+// Функция создает пустой реестр арендаторов
+func NewRegistry() *Registry {
+	var r = Registry{
+		byDomain: map[string]*Tenant{},
+		byName:   map[string]*Tenant{},
+	}
+	return &r
+}
+
+// Функция приводит имя хоста к виду, в котором он хранится в реестре: нижний регистр и без порта
+func normalizeDomain(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSpace(host))
+}
+
+// Register - регистрирует арендатора и его домены, домен может принадлежать только одному арендатору. Ключ
+// арендатора не сохраняется, арендатор с ключом считается закрытым
+func (r *Registry) Register(t Tenant) error {
+	if t.Name == "" || len(t.Domains) == 0 {
+		return ErrIncorrectTenant
+	}
+	t.Private = t.Private || t.APIKey != ""
+	t.APIKey = ""
+	for i, d := range t.Domains {
+		t.Domains[i] = normalizeDomain(d)
+		if t.Domains[i] == "" || strings.ContainsAny(t.Domains[i], "/:") {
+			return ErrIncorrectTenant
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.byName[t.Name]; ok {
+		return ErrIncorrectTenant
+	}
+	for _, d := range t.Domains {
+		if _, ok := r.byDomain[d]; ok {
+			return ErrDomainExists
+		}
+	}
+	for _, d := range t.Domains {
+		r.byDomain[d] = &t
+	}
+	r.byName[t.Name] = &t
+	return nil
+}
+
+// ByDomain - возвращает арендатора, которому принадлежит хост
+func (r *Registry) ByDomain(host string) (*Tenant, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	t, ok := r.byDomain[normalizeDomain(host)]
+	return t, ok
+}
+
+// ByName - возвращает арендатора по названию
+func (r *Registry) ByName(name string) (*Tenant, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	t, ok := r.byName[name]
+	return t, ok
+}
+
+// HasDomain - проверяет принадлежит ли домен арендатору
+func (t *Tenant) HasDomain(host string) bool {
+	host = normalizeDomain(host)
+	for _, d := range t.Domains {
+		if d == host {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadFromFile - загружает арендаторов из json файла со списком арендаторов, отсутствие файла не является ошибкой.
+// Ключи арендаторов добавляются в хранилище ключей api, где хранятся только их хеши
+func (r *Registry) LoadFromFile(file string, keys *apikey.Store) error {
+	if file == "" {
+		return nil
+	}
+	bt, err := os.ReadFile(file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	var ts []Tenant
+	if err := json.Unmarshal(bt, &ts); err != nil {
+		return err
+	}
+	for _, t := range ts {
+		key := t.APIKey
+		if err := r.Register(t); err != nil {
+			return err
+		}
+		if key != "" {
+			if _, err := keys.ImportTenantKey(t.Name, key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+// Пакет tracing - трассировка запросов в формате OpenTelemetry. Контекст трассировки принимается и передается
+// дальше в заголовке W3C traceparent, спаны выгружаются в stdout или в коллектор по протоколу OTLP/HTTP
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/h1067675/shortUrl/internal/respwriter"
+)
+
+// Имя инструментирования, под которым создаются спаны сервиса
+const instrumentationName = "github.com/h1067675/shortUrl"
+
+// Выгрузка спанов
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// Структура настроек трассировки. Endpoint - адрес коллектора OTLP/HTTP вида host:port или http(s)://host:port/path,
+// SampleRatio - доля записываемых трасс от 0 до 1, которые начинаются в сервисе, для входящих трасс решение принимает
+// вызывающая сторона. Output - вывод для выгрузки в stdout, по умолчанию os.Stdout
+type Config struct {
+	Exporter    string
+	Endpoint    string
+	ServiceName string
+	SampleRatio float64
+	Output      io.Writer
+}
+
+// Setup - настраивает глобальные распространитель контекста и поставщика трассировщиков OpenTelemetry. Контекст
+// traceparent передается дальше и без выгрузки спанов. Возвращает функцию, которая выгружает оставшиеся спаны
+// и останавливает трассировку
+func Setup(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	var exp sdktrace.SpanExporter
+	var err error
+	switch cfg.Exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		out := cfg.Output
+		if out == nil {
+			out = os.Stdout
+		}
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(out))
+	case ExporterOTLP:
+		endpoint := cfg.Endpoint
+		if endpoint == "" {
+			endpoint = "localhost:4318"
+		}
+		if !strings.Contains(endpoint, "://") {
+			endpoint = "http://" + endpoint
+		}
+		exp, err = otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(strings.TrimSuffix(endpoint, "/")+tracesPath(endpoint)))
+	default:
+		return nil, errors.New("unknown trace exporter " + cfg.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+	name := cfg.ServiceName
+	if name == "" {
+		name = "shortener"
+	}
+	ratio := min(max(cfg.SampleRatio, 0), 1)
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", name))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// Функция возвращает путь приема спанов, если в адресе коллектора путь не указан
+func tracesPath(endpoint string) string {
+	rest := endpoint[strings.Index(endpoint, "://")+3:]
+	if i := strings.IndexByte(rest, '/'); i >= 0 && rest[i:] != "/" {
+		return ""
+	}
+	return "/v1/traces"
+}
+
+// Start - начинает спан внутри сервиса
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// Error - отмечает спан ошибкой, nil ошибка ничего не меняет
+func Error(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// Middleware - начинает серверный спан на каждый запрос, продолжая трассу из заголовка traceparent, и возвращает
+// контекст трассировки в заголовках ответа. Спан называется по методу и шаблону маршрута chi
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prop := otel.GetTextMapPropagator()
+		ctx := prop.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := otel.Tracer(instrumentationName).Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("server.address", r.Host),
+				attribute.String("user_agent.original", r.UserAgent()),
+			))
+		defer span.End()
+		prop.Inject(ctx, propagation.HeaderCarrier(w.Header()))
+		sw := respwriter.New(w)
+		next.ServeHTTP(sw, r.WithContext(ctx))
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			if route := rctx.RoutePattern(); route != "" {
+				span.SetName(r.Method + " " + route)
+				span.SetAttributes(attribute.String("http.route", route))
+			}
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", sw.Status()))
+		if sw.Status() >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(sw.Status()))
+		}
+	})
+}
@@ -0,0 +1,127 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	collector "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// Функция подменяет глобального поставщика трассировщиков записывающим спаны в память
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	rec := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+	return rec
+}
+
+func TestMiddleware(t *testing.T) {
+	rec := recordSpans(t)
+	router := chi.NewRouter()
+	router.Use(Middleware)
+	router.Get("/links/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_, span := Start(r.Context(), "storage.GetURL")
+		span.End()
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	request := httptest.NewRequest(http.MethodGet, "/links/abc", nil)
+	request.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, request)
+
+	spans := rec.Ended()
+	require.Len(t, spans, 2)
+	child, server := spans[0], spans[1]
+	assert.Equal(t, "GET /links/{id}", server.Name())
+	assert.Equal(t, trace.SpanKindServer, server.SpanKind())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent().SpanID().String())
+	assert.Equal(t, server.SpanContext().SpanID(), child.Parent().SpanID())
+	assert.Equal(t, "Error", server.Status().Code.String())
+	// контекст трассировки возвращается в ответе
+	sc := trace.SpanContextFromContext(propagation.TraceContext{}.Extract(context.Background(), propagation.HeaderCarrier(w.Header())))
+	assert.Equal(t, server.SpanContext().SpanID(), sc.SpanID())
+}
+
+func TestStdoutExporter(t *testing.T) {
+	prev := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(prev)
+	var buf bytes.Buffer
+	shutdown, err := Setup(context.Background(), Config{Exporter: ExporterStdout, Output: &buf, SampleRatio: 1})
+	require.NoError(t, err)
+	_, span := Start(context.Background(), "storage.SaveToFile")
+	span.End()
+	require.NoError(t, shutdown(context.Background()))
+	assert.Contains(t, buf.String(), `"Name":"storage.SaveToFile"`)
+
+	_, err = Setup(context.Background(), Config{Exporter: "zipkin"})
+	assert.Error(t, err)
+}
+
+func TestOTLPExporter(t *testing.T) {
+	prev := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(prev)
+	// заменитель коллектора принимает спаны по OTLP/HTTP в protobuf
+	var mu sync.Mutex
+	var names []string
+	var service string
+	collectorSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		body, _ := io.ReadAll(r.Body)
+		var req collector.ExportTraceServiceRequest
+		require.NoError(t, proto.Unmarshal(body, &req))
+		mu.Lock()
+		defer mu.Unlock()
+		for _, rs := range req.GetResourceSpans() {
+			for _, a := range rs.GetResource().GetAttributes() {
+				if a.GetKey() == "service.name" {
+					service = a.GetValue().GetStringValue()
+				}
+			}
+			for _, ss := range rs.GetScopeSpans() {
+				for _, s := range ss.GetSpans() {
+					names = append(names, s.GetName())
+				}
+			}
+		}
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer collectorSrv.Close()
+
+	shutdown, err := Setup(context.Background(), Config{Exporter: ExporterOTLP, Endpoint: collectorSrv.URL, ServiceName: "shortener-test", SampleRatio: 1})
+	require.NoError(t, err)
+	ctx, parent := Start(context.Background(), "Shorten")
+	_, child := Start(ctx, "storage.SaveToFile")
+	child.End()
+	parent.End()
+	require.NoError(t, shutdown(context.Background()))
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.ElementsMatch(t, []string{"Shorten", "storage.SaveToFile"}, names)
+	assert.Equal(t, "shortener-test", service)
+}
+
+func TestTracesPath(t *testing.T) {
+	assert.Equal(t, "/v1/traces", tracesPath("http://localhost:4318"))
+	assert.Equal(t, "/v1/traces", tracesPath("http://localhost:4318/"))
+	assert.Equal(t, "", tracesPath("https://collector/custom/traces"))
+}
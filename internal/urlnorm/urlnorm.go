@@ -0,0 +1,84 @@
+package urlnorm
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// ErrInvalidURL - ссылка не прошла проверку, конкретная причина содержится в тексте обернутой ошибки
+var ErrInvalidURL = errors.New("invalid url")
+
+// Порты по умолчанию, которые убираются из ссылки
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// Структура проверяющая и нормализующая ссылки перед сокращением
+type Normalizer struct {
+	schemes map[string]bool
+}
+
+// Функция создания нормализатора с разрешенными схемами, если схемы не указаны, то разрешены http и https
+func New(schemes ...string) *Normalizer {
+	if len(schemes) == 0 {
+		schemes = []string{"http", "https"}
+	}
+	var r = Normalizer{schemes: map[string]bool{}}
+	for _, s := range schemes {
+		r.schemes[strings.ToLower(s)] = true
+	}
+	return &r
+}
+
+// Функция возвращает ошибку проверки ссылки с причиной
+func invalid(format string, a ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidURL, fmt.Sprintf(format, a...))
+}
+
+// Normalize - проверяет ссылку и приводит ее к каноническому виду, чтобы равнозначные ссылки получали один код:
+// обрезает пробелы и переводы строк, проверяет схему по списку разрешенных и наличие хоста, переводит
+// интернациональное имя хоста в punycode и нижний регистр, убирает порт по умолчанию и добавляет пустой путь "/"
+func (n *Normalizer) Normalize(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", invalid("url is empty")
+	}
+	if strings.ContainsAny(raw, " \t\r\n") {
+		return "", invalid("url contains whitespace")
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", invalid("url can't be parsed")
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if !n.schemes[u.Scheme] {
+		return "", invalid("scheme %q is not allowed", u.Scheme)
+	}
+	if u.Opaque != "" || u.Hostname() == "" {
+		return "", invalid("url has no host")
+	}
+	host := strings.ToLower(u.Hostname())
+	if net.ParseIP(host) == nil {
+		host, err = idna.Lookup.ToASCII(strings.TrimSuffix(host, "."))
+		if err != nil {
+			return "", invalid("host %q is incorrect", u.Hostname())
+		}
+	}
+	if port := u.Port(); port != "" && port != defaultPorts[u.Scheme] {
+		u.Host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		u.Host = "[" + host + "]"
+	} else {
+		u.Host = host
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return u.String(), nil
+}
@@ -0,0 +1,45 @@
+package urlnorm
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+		err  bool
+	}{
+		{name: "plain", raw: "http://ya.ru/", want: "http://ya.ru/"},
+		{name: "trailing newline", raw: "http://ya.ru/\n", want: "http://ya.ru/"},
+		{name: "empty path", raw: "https://ya.ru", want: "https://ya.ru/"},
+		{name: "upper case host and scheme", raw: "HTTPS://YA.RU/Path", want: "https://ya.ru/Path"},
+		{name: "default port", raw: "http://ya.ru:80/a?b=c", want: "http://ya.ru/a?b=c"},
+		{name: "default https port", raw: "https://ya.ru:443", want: "https://ya.ru/"},
+		{name: "other port", raw: "http://ya.ru:8080/", want: "http://ya.ru:8080/"},
+		{name: "idn host", raw: "http://яндекс.рф/путь", want: "http://xn--d1acpjx3f.xn--p1ai/%D0%BF%D1%83%D1%82%D1%8C"},
+		{name: "ipv6 host", raw: "http://[::1]:80/", want: "http://[::1]/"},
+		{name: "empty", raw: " \n", err: true},
+		{name: "javascript", raw: "javascript:alert(1)", err: true},
+		{name: "ftp", raw: "ftp://ya.ru/", err: true},
+		{name: "no scheme", raw: "ya.ru", err: true},
+		{name: "no host", raw: "http:///path", err: true},
+		{name: "inner whitespace", raw: "http://ya.ru/ a", err: true},
+	}
+	n := New()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := n.Normalize(test.raw)
+			if test.err {
+				assert.True(t, errors.Is(err, ErrInvalidURL))
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
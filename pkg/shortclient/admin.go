@@ -0,0 +1,311 @@
+package shortclient
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Области действия ключей api
+const (
+	ScopeShorten = "shorten"
+	ScopeRead    = "read"
+	ScopeDelete  = "delete"
+	ScopeStats   = "stats"
+	ScopeEdit    = "edit"
+	ScopeAdmin   = "admin"
+)
+
+// Структура запроса на создание ключа api, ключ с арендатором открывает его домены в заголовке X-API-Key
+type KeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	Tenant string   `json:"tenant,omitempty"`
+}
+
+// Структура сведений о ключе api, Key заполняется только в ответе на создание ключа
+type Key struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Scopes  []string  `json:"scopes"`
+	Tenant  string    `json:"tenant,omitempty"`
+	Created time.Time `json:"created"`
+	Revoked bool      `json:"revoked,omitempty"`
+	Key     string    `json:"key,omitempty"`
+}
+
+// Структура запроса на блокировку короткой ссылки
+type BlockRequest struct {
+	ShortURL string `json:"short_url"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// Структура запроса и ответа с уровнем логгера сервиса
+type LogLevel struct {
+	Level string `json:"level"`
+}
+
+// Структура записи журнала аудита: действие, кто и откуда его выполнил, над чем и какие были значения до и после
+type AuditEvent struct {
+	ID        int64     `json:"id"`
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"`
+	Actor     string    `json:"actor"`
+	KeyID     string    `json:"key_id,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	Target    string    `json:"target"`
+	Before    string    `json:"before,omitempty"`
+	After     string    `json:"after,omitempty"`
+}
+
+// Структура условий выборки из журнала аудита, пустые условия не ограничивают выборку
+type AuditQuery struct {
+	Action string
+	Actor  string
+	IP     string
+	Target string
+	From   time.Time
+	To     time.Time
+	Offset int
+	Limit  int
+}
+
+// Структура страницы журнала аудита, Total - количество записей, подходящих под условия
+type AuditPage struct {
+	Events []AuditEvent `json:"events"`
+	Total  int          `json:"total"`
+	Offset int          `json:"offset"`
+	Limit  int          `json:"limit"`
+}
+
+// Структура сведений о ссылке для администратора, у ссылок из старых файлов хранилища Created пустое
+type LinkInfo struct {
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	UserID      string     `json:"user_id,omitempty"`
+	Created     *time.Time `json:"created,omitempty"`
+	Deleted     bool       `json:"is_deleted,omitempty"`
+	Blocked     string     `json:"blocked,omitempty"`
+	Clicks      int64      `json:"clicks,omitempty"`
+}
+
+// Структура условий поиска ссылок: подстрока исходной ссылки, владелец и интервал времени создания [From, To)
+type LinkQuery struct {
+	Destination string
+	Owner       string
+	From        time.Time
+	To          time.Time
+	Deleted     bool
+	Offset      int
+	Limit       int
+}
+
+// Структура страницы найденных ссылок, Total - количество ссылок, подходящих под условия
+type LinkPage struct {
+	Links  []LinkInfo `json:"links"`
+	Total  int        `json:"total"`
+	Offset int        `json:"offset"`
+	Limit  int        `json:"limit"`
+}
+
+// Структура статистики хранилища сервиса
+type StorageStats struct {
+	Links    int    `json:"links"`
+	Active   int    `json:"active"`
+	Deleted  int    `json:"deleted"`
+	Blocked  int    `json:"blocked"`
+	Edited   int    `json:"edited"`
+	Users    int    `json:"users"`
+	File     string `json:"file"`
+	FileSize int64  `json:"file_size"`
+}
+
+// Структура ответа с количеством ссылок, которые затронула операция
+type affected struct {
+	Affected int `json:"affected"`
+}
+
+// Block - блокирует короткую ссылку, требует токена администратора в APIKey
+func (c *Client) Block(ctx context.Context, short string, reason string) error {
+	resp, err := c.doJSON(ctx, http.MethodPost, c.Endpoint+"/api/v1/admin/block", BlockRequest{ShortURL: short, Reason: reason})
+	if err != nil {
+		return err
+	}
+	return decode(resp, nil)
+}
+
+// Unblock - снимает блокировку администратора с короткой ссылки
+func (c *Client) Unblock(ctx context.Context, short string) error {
+	resp, err := c.doJSON(ctx, http.MethodPost, c.Endpoint+"/api/v1/admin/unblock", BlockRequest{ShortURL: short})
+	if err != nil {
+		return err
+	}
+	return decode(resp, nil)
+}
+
+// CreateKey - создает ключ api с областями действия, сам ключ возвращается только в этом ответе
+func (c *Client) CreateKey(ctx context.Context, name string, scopes ...string) (Key, error) {
+	return c.CreateTenantKey(ctx, name, "", scopes...)
+}
+
+// CreateTenantKey - создает ключ api, привязанный к зарегистрированному арендатору
+func (c *Client) CreateTenantKey(ctx context.Context, name string, tenant string, scopes ...string) (Key, error) {
+	resp, err := c.doJSON(ctx, http.MethodPost, c.Endpoint+"/api/v1/admin/keys", KeyRequest{Name: name, Scopes: scopes, Tenant: tenant})
+	if err != nil {
+		return Key{}, err
+	}
+	var out Key
+	return out, decode(resp, &out)
+}
+
+// ListKeys - возвращает все ключи api, включая отозванные
+func (c *Client) ListKeys(ctx context.Context) ([]Key, error) {
+	resp, err := c.do(ctx, http.MethodGet, c.Endpoint+"/api/v1/admin/keys", nil, "")
+	if err != nil {
+		return nil, err
+	}
+	var out []Key
+	return out, decode(resp, &out)
+}
+
+// RevokeKey - отзывает ключ api
+func (c *Client) RevokeKey(ctx context.Context, id string) error {
+	resp, err := c.do(ctx, http.MethodDelete, c.Endpoint+"/api/v1/admin/keys/"+url.PathEscape(id), nil, "")
+	if err != nil {
+		return err
+	}
+	return decode(resp, nil)
+}
+
+// LogLevel - возвращает текущий уровень логгера сервиса
+func (c *Client) LogLevel(ctx context.Context) (string, error) {
+	resp, err := c.do(ctx, http.MethodGet, c.Endpoint+"/api/v1/admin/log/level", nil, "")
+	if err != nil {
+		return "", err
+	}
+	var out LogLevel
+	return out.Level, decode(resp, &out)
+}
+
+// SetLogLevel - меняет уровень логгера сервиса до его перезапуска: debug, info, warn или error
+func (c *Client) SetLogLevel(ctx context.Context, level string) error {
+	resp, err := c.doJSON(ctx, http.MethodPut, c.Endpoint+"/api/v1/admin/log/level", LogLevel{Level: level})
+	if err != nil {
+		return err
+	}
+	return decode(resp, nil)
+}
+
+// Audit - возвращает страницу журнала аудита, записи идут от новых к старым
+func (c *Client) Audit(ctx context.Context, q AuditQuery) (AuditPage, error) {
+	v := url.Values{}
+	for name, value := range map[string]string{"action": q.Action, "actor": q.Actor, "ip": q.IP, "target": q.Target} {
+		if value != "" {
+			v.Set(name, value)
+		}
+	}
+	setPage(v, q.From, q.To, q.Offset, q.Limit)
+	resp, err := c.do(ctx, http.MethodGet, withQuery(c.Endpoint+"/api/v1/admin/audit", v), nil, "")
+	if err != nil {
+		return AuditPage{}, err
+	}
+	var out AuditPage
+	return out, decode(resp, &out)
+}
+
+// SearchLinks - ищет ссылки всех пользователей, ссылки возвращаются от новых к старым
+func (c *Client) SearchLinks(ctx context.Context, q LinkQuery) (LinkPage, error) {
+	v := url.Values{}
+	if q.Destination != "" {
+		v.Set("q", q.Destination)
+	}
+	if q.Owner != "" {
+		v.Set("owner", q.Owner)
+	}
+	if q.Deleted {
+		v.Set("deleted", "true")
+	}
+	setPage(v, q.From, q.To, q.Offset, q.Limit)
+	resp, err := c.do(ctx, http.MethodGet, withQuery(c.Endpoint+"/api/v1/admin/links", v), nil, "")
+	if err != nil {
+		return LinkPage{}, err
+	}
+	var out LinkPage
+	return out, decode(resp, &out)
+}
+
+// DeleteLinks - удаляет ссылки независимо от владельца по коротким ссылкам или их кодам на базовом домене,
+// возвращает количество удаленных ссылок
+func (c *Client) DeleteLinks(ctx context.Context, links ...string) (int, error) {
+	resp, err := c.doJSON(ctx, http.MethodDelete, c.Endpoint+"/api/v1/admin/links", links)
+	if err != nil {
+		return 0, err
+	}
+	var out affected
+	return out.Affected, decode(resp, &out)
+}
+
+// ReassignLinks - передает ссылки пользователю userID, возвращает количество переданных ссылок
+func (c *Client) ReassignLinks(ctx context.Context, userID string, links ...string) (int, error) {
+	in := struct {
+		ShortURLs []string `json:"short_urls"`
+		UserID    string   `json:"user_id"`
+	}{links, userID}
+	resp, err := c.doJSON(ctx, http.MethodPost, c.Endpoint+"/api/v1/admin/links/owner", in)
+	if err != nil {
+		return 0, err
+	}
+	var out affected
+	return out.Affected, decode(resp, &out)
+}
+
+// StorageStats - возвращает статистику хранилища сервиса
+func (c *Client) StorageStats(ctx context.Context) (StorageStats, error) {
+	resp, err := c.do(ctx, http.MethodGet, c.Endpoint+"/api/v1/admin/stats", nil, "")
+	if err != nil {
+		return StorageStats{}, err
+	}
+	var out StorageStats
+	return out, decode(resp, &out)
+}
+
+// Функция добавляет в параметры запроса интервал времени и страницу выборки
+func setPage(v url.Values, from time.Time, to time.Time, offset int, limit int) {
+	if !from.IsZero() {
+		v.Set("from", from.Format(time.RFC3339))
+	}
+	if !to.IsZero() {
+		v.Set("to", to.Format(time.RFC3339))
+	}
+	if offset > 0 {
+		v.Set("offset", strconv.Itoa(offset))
+	}
+	if limit > 0 {
+		v.Set("limit", strconv.Itoa(limit))
+	}
+}
+
+// Функция добавляет к адресу параметры запроса, если они есть
+func withQuery(target string, v url.Values) string {
+	if len(v) == 0 {
+		return target
+	}
+	return target + "?" + v.Encode()
+}
+
+// OpenAPI - возвращает спецификацию api сервиса в формате OpenAPI
+func (c *Client) OpenAPI(ctx context.Context) ([]byte, error) {
+	resp, err := c.do(ctx, http.MethodGet, c.Endpoint+"/api/v1/openapi.json", nil, "")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return nil, decode(resp, nil)
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,489 @@
+// Package shortclient - клиент http api сервиса сокращения ссылок
+package shortclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// Имя cookie, в которой сервис передает подписанный идентификатор пользователя
+const cookieName = "user_id"
+
+// Error - ошибка api, разобранная из ответа в формате application/problem+json. Для уже сокращенной ссылки
+// ShortURL содержит существующую короткую ссылку
+type Error struct {
+	Status    int    `json:"status"`
+	Code      string `json:"code"`
+	Detail    string `json:"detail"`
+	ShortURL  string `json:"short_url,omitempty"`
+	RequestID string `json:"-"`
+}
+
+// Error - реализует интерфейс error, идентификатор запроса добавляется для поиска запроса в логах сервиса
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("shortener: status %d", e.Status)
+	if e.Code != "" {
+		msg = fmt.Sprintf("shortener: %s: %s", e.Code, e.Detail)
+	}
+	if e.RequestID != "" {
+		msg += " (request id " + e.RequestID + ")"
+	}
+	return msg
+}
+
+// Ключ идентификатора запроса в контексте
+type requestIDKey struct{}
+
+// WithRequestID - возвращает контекст, запросы с которым передают сервису идентификатор id в заголовке
+// X-Request-ID. Так запрос, пришедший в приложение, можно найти в логах сервиса по его идентификатору
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// Структура запроса на сокращение ссылки, домен указывается для сокращения на домене арендатора, QRCode запрашивает
+// в ответе QR код короткой ссылки
+type ShortenRequest struct {
+	URL    string `json:"url"`
+	Domain string `json:"domain,omitempty"`
+	QRCode bool   `json:"qr_code,omitempty"`
+}
+
+// Структура запроса на изменение исходной ссылки
+type UpdateRequest struct {
+	URL string `json:"url"`
+}
+
+// Структура ответа на запрос сокращения ссылки, QRCode содержит data: ссылку на изображение PNG, если код был запрошен
+type ShortenResponse struct {
+	Result string `json:"result"`
+	QRCode string `json:"qr_code,omitempty"`
+}
+
+// Структура параметров изображения QR кода: формат png или svg, ширина и высота в пикселях, ширина поля вокруг кода
+// в модулях и уровень коррекции ошибок L, M, Q или H. Пустые значения означают значения сервиса по умолчанию,
+// NoMargin убирает поле вокруг кода
+type QROptions struct {
+	Format   string
+	Size     int
+	Margin   int
+	NoMargin bool
+	Level    string
+}
+
+// Структура элемента пакетного запроса на сокращение
+type BatchItem struct {
+	CorrelationID string `json:"correlation_id"`
+	OriginalURL   string `json:"original_url"`
+}
+
+// Структура короткой ссылки из ответа на пакетный запрос
+type BatchResult struct {
+	CorrelationID string `json:"correlation_id"`
+	ShortURL      string `json:"short_url"`
+}
+
+// Структура ссылки пользователя
+type Link struct {
+	ShortURL    string `json:"short_url"`
+	OriginalURL string `json:"original_url"`
+	Clicks      int64  `json:"clicks,omitempty"`
+}
+
+// Структура прежней исходной ссылки и времени, когда ее заменили
+type Revision struct {
+	OriginalURL string    `json:"original_url"`
+	Replaced    time.Time `json:"replaced_at"`
+}
+
+// Структура истории ссылки пользователя: текущая исходная ссылка и прежние в порядке их замены
+type History struct {
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	History     []Revision `json:"history"`
+}
+
+// Структура статистики сервиса
+type Stats struct {
+	URLs  int `json:"urls"`
+	Users int `json:"users"`
+}
+
+// Структура клиента. Endpoint - базовый адрес сервиса вместе с путем, APIKey - ключ api или токен администратора,
+// который передается в заголовке Authorization: Bearer, без ключа пользователь определяется по cookie, выданной
+// сервисом. TenantKey - ключ арендатора для сокращения ссылок на его доменах. Gzip включает сжатие тел запросов
+// и ответов. Запросы повторяются до MaxRetries раз с экспоненциально растущей паузой начиная с RetryBackoff.
+// Идемпотентные запросы повторяются при сетевой и временной ошибке, остальные только на Too many requests
+// и Service unavailable, когда сервис точно не выполнил запрос
+type Client struct {
+	Endpoint     string
+	HTTPClient   *http.Client
+	APIKey       string
+	TenantKey    string
+	Gzip         bool
+	MaxRetries   int
+	RetryBackoff time.Duration
+
+	mu    sync.Mutex
+	token string
+}
+
+// Наибольшая пауза между повторами запроса
+const maxBackoff = 10 * time.Second
+
+// Функция создания клиента сервиса с указанным базовым адресом
+func New(endpoint string) *Client {
+	var r = Client{
+		Endpoint:     strings.TrimRight(endpoint, "/"),
+		HTTPClient:   &http.Client{},
+		MaxRetries:   3,
+		RetryBackoff: 100 * time.Millisecond,
+	}
+	return &r
+}
+
+// Token - возвращает токен пользователя из cookie, его можно сохранить и восстановить в следующем сеансе
+func (c *Client) Token() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token
+}
+
+// SetToken - устанавливает токен пользователя, полученный в прошлом сеансе
+func (c *Client) SetToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = token
+}
+
+// Функция сериализует тело запроса в json
+func jsonBody(in interface{}) ([]byte, string, error) {
+	if in == nil {
+		return nil, "", nil
+	}
+	bt, err := json.Marshal(in)
+	return bt, "application/json", err
+}
+
+// Функция отправляет json запрос
+func (c *Client) doJSON(ctx context.Context, method string, target string, in interface{}) (*http.Response, error) {
+	body, contentType, err := jsonBody(in)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(ctx, method, target, body, contentType)
+}
+
+// Функция отправляет запрос и повторяет его при сетевых и временных ошибках сервиса. Пауза перед повтором берется
+// из заголовка Retry-After, если сервис его передал, иначе растет вдвое с каждой попыткой
+func (c *Client) do(ctx context.Context, method string, target string, body []byte, contentType string) (*http.Response, error) {
+	backoff := c.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, method, target, body, contentType)
+		if attempt >= c.MaxRetries || !retryable(method, resp, err) {
+			return resp, err
+		}
+		wait := backoff
+		if resp != nil {
+			if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s >= 0 {
+				wait = time.Duration(s) * time.Second
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// Функция проверяет, что метод запроса идемпотентен и его повтор не изменит результат
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// Функция проверяет, что запрос стоит повторить. Сетевая ошибка, кроме отмены контекста, и ответ прокси о недоступности
+// сервиса не говорят, выполнил ли сервис запрос, поэтому так повторяются только идемпотентные запросы. Too many
+// requests и Service unavailable означают, что запрос не выполнен, и повторяются для любого метода
+func retryable(method string, resp *http.Response, err error) bool {
+	if err != nil {
+		return idempotent(method) && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent(method)
+	}
+	return false
+}
+
+// Функция собирает и отправляет один запрос. При включенном Gzip тело запроса сжимается, если сервис выдал токен
+// пользователя, то он запоминается
+func (c *Client) send(ctx context.Context, method string, target string, body []byte, contentType string) (*http.Response, error) {
+	if body != nil && c.Gzip {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write(body)
+		gz.Close()
+		body = buf.Bytes()
+	}
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	// повторы запроса передаются с тем же идентификатором
+	if id, _ := ctx.Value(requestIDKey{}).(string); id != "" {
+		request.Header.Set("X-Request-ID", id)
+	}
+	// контекст трассировки передается сервису распространителем OpenTelemetry, который настроило приложение
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(request.Header))
+	if body != nil && c.Gzip {
+		request.Header.Set("Content-Encoding", "gzip")
+	}
+	if c.Gzip {
+		request.Header.Set("Accept-Encoding", "gzip")
+	}
+	if c.TenantKey != "" {
+		request.Header.Set("X-API-Key", c.TenantKey)
+	}
+	if c.APIKey != "" {
+		request.Header.Set("Authorization", "Bearer "+c.APIKey)
+	} else if token := c.Token(); token != "" {
+		request.AddCookie(&http.Cookie{Name: cookieName, Value: token})
+	}
+	client := *c.HTTPClient
+	// переход по короткой ссылке не выполняется, нужен только адрес из заголовка Location
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	resp, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	for _, ck := range resp.Cookies() {
+		if ck.Name == cookieName {
+			c.SetToken(ck.Value)
+		}
+	}
+	// ответ без тела сервис тоже может пометить сжатым, распаковывать в нем нечего
+	if resp.Header.Get("Content-Encoding") == "gzip" && resp.StatusCode != http.StatusNoContent && resp.ContentLength != 0 {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{gz, resp.Body}
+	}
+	return resp, nil
+}
+
+// Функция разбирает ответ: на статус ошибки возвращает Error, иначе декодирует json тело в out
+func decode(resp *http.Response, out interface{}) error {
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		e := Error{Status: resp.StatusCode}
+		json.NewDecoder(resp.Body).Decode(&e)
+		e.Status = resp.StatusCode
+		e.RequestID = resp.Header.Get("X-Request-ID")
+		return &e
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Shorten - сокращает ссылку. Если ссылка уже была сокращена, то возвращает существующую короткую ссылку вместе
+// с ошибкой Error с кодом conflict
+func (c *Client) Shorten(ctx context.Context, url string) (string, error) {
+	return c.ShortenDomain(ctx, url, "")
+}
+
+// ShortenDomain - сокращает ссылку на домене арендатора, пустой домен означает базовый домен сервиса или первый
+// домен арендатора с ключом TenantKey
+func (c *Client) ShortenDomain(ctx context.Context, url string, domain string) (string, error) {
+	resp, err := c.doJSON(ctx, http.MethodPost, c.Endpoint+"/api/v1/shorten", ShortenRequest{URL: url, Domain: domain})
+	if err != nil {
+		return "", err
+	}
+	var out ShortenResponse
+	if err := decode(resp, &out); err != nil {
+		return conflictURL(err), err
+	}
+	return out.Result, nil
+}
+
+// ShortenQR - сокращает ссылку и возвращает вместе с короткой ссылкой ее QR код в виде data: ссылки на изображение PNG
+func (c *Client) ShortenQR(ctx context.Context, url string) (string, string, error) {
+	resp, err := c.doJSON(ctx, http.MethodPost, c.Endpoint+"/api/v1/shorten", ShortenRequest{URL: url, QRCode: true})
+	if err != nil {
+		return "", "", err
+	}
+	var out ShortenResponse
+	if err := decode(resp, &out); err != nil {
+		return conflictURL(err), "", err
+	}
+	return out.Result, out.QRCode, nil
+}
+
+// ShortenText - сокращает ссылку через корневой маршрут, который принимает ссылку в теле text/plain
+func (c *Client) ShortenText(ctx context.Context, url string) (string, error) {
+	resp, err := c.do(ctx, http.MethodPost, c.Endpoint+"/", []byte(url), "text/plain")
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		err := decode(resp, nil)
+		return conflictURL(err), err
+	}
+	defer resp.Body.Close()
+	short, err := io.ReadAll(resp.Body)
+	return string(short), err
+}
+
+// Функция возвращает существующую короткую ссылку из ошибки конфликта
+func conflictURL(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.ShortURL
+	}
+	return ""
+}
+
+// ShortenBatch - сокращает пакет ссылок, для уже сокращенных ссылок возвращаются существующие короткие ссылки
+func (c *Client) ShortenBatch(ctx context.Context, batch []BatchItem) ([]BatchResult, error) {
+	resp, err := c.doJSON(ctx, http.MethodPost, c.Endpoint+"/api/v1/shorten/batch", batch)
+	if err != nil {
+		return nil, err
+	}
+	var out []BatchResult
+	return out, decode(resp, &out)
+}
+
+// Expand - возвращает исходную ссылку по короткой ссылке или по ее коду
+func (c *Client) Expand(ctx context.Context, short string) (string, error) {
+	if !strings.Contains(short, "://") {
+		short = c.Endpoint + "/" + short
+	}
+	resp, err := c.do(ctx, http.MethodGet, short, nil, "")
+	if err != nil {
+		return "", err
+	}
+	if err := decode(resp, nil); err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusTemporaryRedirect {
+		return "", &Error{Status: resp.StatusCode, Code: "unexpected_status", Detail: resp.Status, RequestID: resp.Header.Get("X-Request-ID")}
+	}
+	return resp.Header.Get("Location"), nil
+}
+
+// QRCode - возвращает изображение QR кода короткой ссылки или ссылки с кодом и его тип содержимого
+func (c *Client) QRCode(ctx context.Context, short string, o QROptions) ([]byte, string, error) {
+	if !strings.Contains(short, "://") {
+		short = c.Endpoint + "/" + short
+	}
+	v := neturl.Values{}
+	if o.Format != "" {
+		v.Set("format", o.Format)
+	}
+	if o.Size > 0 {
+		v.Set("size", strconv.Itoa(o.Size))
+	}
+	if o.Margin > 0 || o.NoMargin {
+		v.Set("margin", strconv.Itoa(o.Margin))
+	}
+	if o.Level != "" {
+		v.Set("level", o.Level)
+	}
+	resp, err := c.do(ctx, http.MethodGet, withQuery(short+"/qr", v), nil, "")
+	if err != nil {
+		return nil, "", err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return nil, "", decode(resp, nil)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	return data, resp.Header.Get("Content-Type"), err
+}
+
+// UserURLs - возвращает ссылки пользователя
+func (c *Client) UserURLs(ctx context.Context) ([]Link, error) {
+	resp, err := c.do(ctx, http.MethodGet, c.Endpoint+"/api/v1/user/urls", nil, "")
+	if err != nil {
+		return nil, err
+	}
+	var out []Link
+	return out, decode(resp, &out)
+}
+
+// DeleteUserURLs - удаляет ссылки пользователя по кодам
+func (c *Client) DeleteUserURLs(ctx context.Context, codes []string) error {
+	resp, err := c.doJSON(ctx, http.MethodDelete, c.Endpoint+"/api/v1/user/urls", codes)
+	if err != nil {
+		return err
+	}
+	return decode(resp, nil)
+}
+
+// UpdateURL - меняет исходную ссылку у ссылки пользователя с кодом code, прежняя исходная ссылка сохраняется в истории
+func (c *Client) UpdateURL(ctx context.Context, code string, url string) (Link, error) {
+	resp, err := c.doJSON(ctx, http.MethodPatch, c.Endpoint+"/api/v1/user/urls/"+neturl.PathEscape(code), UpdateRequest{URL: url})
+	if err != nil {
+		return Link{}, err
+	}
+	var out Link
+	return out, decode(resp, &out)
+}
+
+// URLHistory - возвращает историю исходных ссылок у ссылки пользователя с кодом code
+func (c *Client) URLHistory(ctx context.Context, code string) (History, error) {
+	resp, err := c.do(ctx, http.MethodGet, c.Endpoint+"/api/v1/user/urls/"+neturl.PathEscape(code)+"/history", nil, "")
+	if err != nil {
+		return History{}, err
+	}
+	var out History
+	return out, decode(resp, &out)
+}
+
+// Stats - возвращает статистику сервиса
+func (c *Client) Stats(ctx context.Context) (Stats, error) {
+	resp, err := c.do(ctx, http.MethodGet, c.Endpoint+"/api/v1/internal/stats", nil, "")
+	if err != nil {
+		return Stats{}, err
+	}
+	var out Stats
+	return out, decode(resp, &out)
+}
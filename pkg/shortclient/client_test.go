@@ -0,0 +1,274 @@
+package shortclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/h1067675/shortUrl/cmd/configsurl"
+	"github.com/h1067675/shortUrl/cmd/netservice"
+	"github.com/h1067675/shortUrl/cmd/storage"
+	"github.com/h1067675/shortUrl/internal/audit"
+	"github.com/h1067675/shortUrl/internal/logger"
+)
+
+// Функция запускает тестовый сервер сервиса с токеном администратора admin
+func newServer(t *testing.T) *httptest.Server {
+	logger.Initialize("debug")
+	conf := configsurl.NewConfig("localhost:8080", "http://localhost:8080", t.TempDir()+"/storage.json")
+	conn := netservice.NewConnect(storage.NewStorage(), conf)
+	conn.AdminToken = "admin"
+	conn.Audit = audit.NewStore()
+	srv := httptest.NewServer(conn.RouterFunc())
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestClient(t *testing.T) {
+	srv := newServer(t)
+	ctx := context.Background()
+	c := New(srv.URL)
+
+	short, err := c.ShortenText(ctx, "http://ya.ru/")
+	require.NoError(t, err)
+	require.NotEmpty(t, short)
+	require.NotEmpty(t, c.Token())
+
+	// повторное сокращение возвращает существующую ссылку вместе с ошибкой
+	again, err := c.Shorten(ctx, "http://ya.ru/")
+	var apiErr *Error
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusConflict, apiErr.Status)
+	assert.Equal(t, "conflict", apiErr.Code)
+	assert.Equal(t, short, again)
+
+	c.Gzip = true
+	batch, err := c.ShortenBatch(ctx, []BatchItem{{CorrelationID: "1", OriginalURL: "http://mail.ru/"}})
+	require.NoError(t, err)
+	require.Len(t, batch, 1)
+	assert.Equal(t, "1", batch[0].CorrelationID)
+
+	code := short[len("http://localhost:8080/"):]
+	orig, err := c.Expand(ctx, code)
+	require.NoError(t, err)
+	assert.Equal(t, "http://ya.ru/", orig)
+
+	img, ctype, err := c.QRCode(ctx, code, QROptions{Format: "svg", Size: 128, NoMargin: true, Level: "Q"})
+	require.NoError(t, err)
+	assert.Equal(t, "image/svg+xml", ctype)
+	assert.Contains(t, string(img), `width="128"`)
+	qrShort, qrCode, err := c.ShortenQR(ctx, "http://ya.ru/qr")
+	require.NoError(t, err)
+	assert.NotEmpty(t, qrShort)
+	assert.Contains(t, qrCode, "data:image/png;base64,")
+
+	links, err := c.UserURLs(ctx)
+	require.NoError(t, err)
+	require.Len(t, links, 3)
+	var clicks int64
+	for _, l := range links {
+		clicks += l.Clicks
+	}
+	assert.Equal(t, int64(1), clicks)
+
+	link, err := c.UpdateURL(ctx, code, "http://ya.ru/maps")
+	require.NoError(t, err)
+	assert.Equal(t, Link{ShortURL: short, OriginalURL: "http://ya.ru/maps"}, link)
+	history, err := c.URLHistory(ctx, code)
+	require.NoError(t, err)
+	require.Len(t, history.History, 1)
+	assert.Equal(t, "http://ya.ru/", history.History[0].OriginalURL)
+
+	require.NoError(t, c.DeleteUserURLs(ctx, []string{code}))
+	_, err = c.Expand(ctx, code)
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, "gone", apiErr.Code)
+
+	// статистика доступна только администратору
+	_, err = c.Stats(ctx)
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusForbidden, apiErr.Status)
+	admin := New(srv.URL)
+	admin.APIKey = "admin"
+	stats, err := admin.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, Stats{URLs: 2, Users: 1}, stats)
+
+	spec, err := c.OpenAPI(ctx)
+	require.NoError(t, err)
+	assert.Contains(t, string(spec), "openapi")
+}
+
+func TestAdmin(t *testing.T) {
+	srv := newServer(t)
+	ctx := context.Background()
+	admin := New(srv.URL)
+	admin.APIKey = "admin"
+
+	key, err := admin.CreateKey(ctx, "backend", ScopeShorten, ScopeRead)
+	require.NoError(t, err)
+	require.NotEmpty(t, key.Key)
+
+	c := New(srv.URL)
+	c.APIKey = key.Key
+	short, err := c.Shorten(ctx, "http://ya.ru/")
+	require.NoError(t, err)
+	links, err := c.UserURLs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []Link{{ShortURL: short, OriginalURL: "http://ya.ru/"}}, links)
+	_, err = c.Stats(ctx)
+	var apiErr *Error
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusForbidden, apiErr.Status)
+
+	require.NoError(t, admin.Block(ctx, short, "phishing"))
+	_, err = c.Expand(ctx, short[len("http://localhost:8080/"):])
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusForbidden, apiErr.Status)
+	require.NoError(t, admin.Unblock(ctx, short))
+
+	page, err := admin.Audit(ctx, AuditQuery{Target: short, Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, 3, page.Total)
+	require.Len(t, page.Events, 1)
+	assert.Equal(t, "unblock", page.Events[0].Action)
+	assert.Equal(t, "phishing", page.Events[0].Before)
+
+	found, err := admin.SearchLinks(ctx, LinkQuery{Destination: "YA.RU", From: time.Now().Add(-time.Hour)})
+	require.NoError(t, err)
+	require.Equal(t, 1, found.Total)
+	assert.Equal(t, short, found.Links[0].ShortURL)
+	require.NotNil(t, found.Links[0].Created)
+	n, err := admin.ReassignLinks(ctx, "someone", short)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = admin.DeleteLinks(ctx, short)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	st, err := admin.StorageStats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, st.Links)
+	assert.Equal(t, 1, st.Deleted)
+
+	keys, err := admin.ListKeys(ctx)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Empty(t, keys[0].Key)
+	require.NoError(t, admin.RevokeKey(ctx, key.ID))
+	_, err = c.UserURLs(ctx)
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusUnauthorized, apiErr.Status)
+
+	level, err := admin.LogLevel(ctx)
+	require.NoError(t, err)
+	defer admin.SetLogLevel(ctx, level)
+	require.NoError(t, admin.SetLogLevel(ctx, "warn"))
+	level, err = admin.LogLevel(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "warn", level)
+	err = admin.SetLogLevel(WithRequestID(ctx, "trace-1"), "loud")
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusBadRequest, apiErr.Status)
+	assert.Equal(t, "trace-1", apiErr.RequestID)
+	assert.Contains(t, err.Error(), "request id trace-1")
+}
+
+func TestRetry(t *testing.T) {
+	srv := newServer(t)
+	var calls atomic.Int32
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= 2 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		srv.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer flaky.Close()
+	ctx := context.Background()
+
+	c := New(flaky.URL)
+	c.RetryBackoff = time.Millisecond
+	_, err := c.Shorten(ctx, "http://ya.ru/")
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), calls.Load())
+
+	calls.Store(0)
+	c.MaxRetries = 1
+	_, err = c.Stats(ctx)
+	var apiErr *Error
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusServiceUnavailable, apiErr.Status)
+
+	// пауза между повторами прерывается отменой контекста
+	calls.Store(0)
+	c.MaxRetries = 3
+	c.RetryBackoff = time.Hour
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer down.Close()
+	c.Endpoint = down.URL
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = c.Stats(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestRetryIdempotent(t *testing.T) {
+	// сервис обрывает соединение, не ответив, и неизвестно, выполнен ли запрос
+	var calls atomic.Int32
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		require.NoError(t, err)
+		conn.Close()
+	}))
+	defer broken.Close()
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer gateway.Close()
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		endpoint string
+		call     func(c *Client) error
+		calls    int32
+	}{
+		{name: "create key after network error", endpoint: broken.URL, calls: 1, call: func(c *Client) error {
+			_, err := c.CreateKey(ctx, "backend", ScopeShorten)
+			return err
+		}},
+		{name: "shorten after bad gateway", endpoint: gateway.URL, calls: 1, call: func(c *Client) error {
+			_, err := c.Shorten(ctx, "http://ya.ru/")
+			return err
+		}},
+		{name: "list keys after network error", endpoint: broken.URL, calls: 3, call: func(c *Client) error {
+			_, err := c.ListKeys(ctx)
+			return err
+		}},
+		{name: "revoke key after bad gateway", endpoint: gateway.URL, calls: 3, call: func(c *Client) error {
+			return c.RevokeKey(ctx, "id")
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls.Store(0)
+			c := New(test.endpoint)
+			c.MaxRetries = 2
+			c.RetryBackoff = time.Millisecond
+			assert.Error(t, test.call(c))
+			assert.Equal(t, test.calls, calls.Load())
+		})
+	}
+}
@@ -0,0 +1,8 @@
+//go:build tools
+
+// Package tools - фиксирует версии инструментов генерации кода в go.mod, сами инструменты запускаются через go run
+package tools
+
+import (
+	_ "github.com/swaggo/swag/v2/cmd/swag"
+)